@@ -0,0 +1,219 @@
+// gitdep populates the CodeCommit repository created by DevopsStack.
+// It is a separate program from the CDK app, run from the devops folder:
+// go run ./cmd/gitdep deploy
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"CDK/pkg/clusterimport"
+	"CDK/pkg/kubetunnel"
+	"CDK/pkg/mainconfig"
+	"CDK/pkg/populate"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/codecommit"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/briandowns/spinner"
+	"github.com/golang/glog"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// Logical key of the build role output in NewDevopsStack (../../devops.go)
+const buildRoleOutputKey = "ARNRoleBuildProject"
+
+type Configuration struct {
+	Reponame         string
+	Desc             string
+	GitRepo          string
+	Recr             string
+	ImgTag           string
+	BuildPr          string
+	PiplineN         string
+	ClusterName      string
+	EksAdminRole     string
+	SecondBramchName string
+	// Cluster.Import, an existing cluster used instead of the one of the
+	// EKS stack
+	Cluster clusterimport.Settings
+}
+
+func readJSONConfig(filename string, config interface{}) {
+	fconfig, err := os.ReadFile(filename)
+	if err != nil {
+		panic(fmt.Sprintf("❌ Problem with the configuration file: %s", filename))
+	}
+	if err := json.Unmarshal(fconfig, config); err != nil {
+		fmt.Println("❌ Error unmarshaling JSON:", err)
+		os.Exit(1)
+	}
+}
+
+func GetConfig(configcrd mainconfig.ConfAuth, configjs Configuration) (mainconfig.ConfAuth, Configuration) {
+
+	readJSONConfig("config.json", &configjs)
+	readJSONConfig("../config_crd.json", &configcrd)
+
+	return configcrd, configjs
+}
+
+func CheckIfError(err error) {
+	if err == nil {
+		return
+	}
+
+	fmt.Printf("\x1b[31;1m%s\x1b[0m\n", fmt.Sprintf(" ❌ Error: %s", err))
+	os.Exit(1)
+}
+
+func getCurrentClusterName(config *rest.Config, kubeconfigPath string) (string, error) {
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfigPath},
+		&clientcmd.ConfigOverrides{ClusterInfo: clientcmdapi.Cluster{Server: config.Host}},
+	)
+
+	rawConfig, err := clientConfig.RawConfig()
+	if err != nil {
+		return "", fmt.Errorf("failed to load kubeconfig: %v", err)
+	}
+
+	// Get the current context
+	currentContext := rawConfig.CurrentContext
+	if currentContext == "" {
+		return "", fmt.Errorf("current context not set in kubeconfig")
+	}
+
+	// Get the cluster name for the current context
+	clusterARN := rawConfig.Contexts[currentContext].Cluster
+	if clusterARN == "" {
+		return "", fmt.Errorf("cluster name not found for current context")
+	}
+
+	clusterName := getLastSegmentAfterSlash(clusterARN)
+
+	return clusterName, nil
+}
+
+func getLastSegmentAfterSlash(s string) string {
+	parts := strings.Split(s, "/")
+	if len(parts) > 0 {
+		return parts[len(parts)-1]
+	}
+	return ""
+}
+
+func main() {
+
+	var configcrd mainconfig.ConfAuth
+	var config1 Configuration
+	var AppConfig1, AppConfig = GetConfig(configcrd, config1)
+	RepoNameCd := AppConfig.Reponame + "-" + AppConfig1.Index
+	ERCReposName := AppConfig.Recr + "-" + AppConfig1.Index
+	secretName := AppConfig1.AWSsecret + AppConfig1.Index
+	BuildSecretToken := secretName + ":SONAR_TOKEN"
+	BuildSecretURL := secretName + ":SONAR_HOST_URL"
+	stackName := "DevopsStack" + AppConfig1.Index
+
+	clusterName := AppConfig.ClusterName + AppConfig1.Index
+	AdmRole := clusterName + AppConfig.EksAdminRole
+	imported := AppConfig.Cluster.Import
+	if imported != nil {
+		// The build role deploys the application with the kubectl role
+		if err := imported.Validate(false); err != nil {
+			glog.Fatalf("❌ Invalid cluster import: %v", err)
+		}
+		AdmRole = imported.KubectlRoleName()
+	}
+
+	os.Setenv("AWS_SDK_LOAD_CONFIG", "true")
+	os.Setenv("AWS_PROFILE", AppConfig1.SSOProfile)
+	codeCommitRepoURL := "codecommit://" + AppConfig1.SSOProfile + "@" + RepoNameCd
+
+	// Create a new AWS session
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region: aws.String(AppConfig1.Region),
+	}))
+
+	// Load Kubeconfig
+	kubeconfigPath := filepath.Join(os.Getenv("HOME"), ".kube", "config")
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	}
+	if err != nil {
+		glog.Fatalf("❌ Failed to load kubeconfig: %v", err)
+	}
+	// A private endpoint is reached through the port-forward of EKS_API_TUNNEL
+	if err := kubetunnel.FromEnv(config); err != nil {
+		glog.Fatalf("❌ Invalid API tunnel: %v", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		glog.Fatalf("❌ Failed to create a ClientSet: %v. Exiting.", err)
+	}
+
+	// Get the current context's cluster name
+	EKSClusterName, err := getCurrentClusterName(config, kubeconfigPath)
+	if err != nil {
+		glog.Fatalf("❌ Failed to get cluster name: %v", err)
+	}
+	if imported != nil {
+		err := clusterimport.Check(context.Background(), *imported, eks.New(sess), clientset, clusterimport.Host(config))
+		if err != nil {
+			glog.Fatalf("❌ Imported cluster not usable: %v", err)
+		}
+		EKSClusterName = imported.ClusterName()
+	}
+
+	spin := spinner.New(spinner.CharSets[37], 100*time.Millisecond, spinner.WithWriter(os.Stderr))
+	spin.Suffix = " Populate CodeCommit Repository ..."
+	spin.Start()
+
+	err = populate.Run(context.Background(), populate.Config{
+		RepoName:      RepoNameCd,
+		SourceRepoURL: AppConfig.GitRepo,
+		RemoteURL:     codeCommitRepoURL,
+		MainBranch:    "main",
+		SecondBranch:  AppConfig.SecondBramchName,
+		BuildSpecFile: "buildspec.yml",
+		BuildSpec: populate.BuildSpecValues{
+			SonarTokenSecret:   BuildSecretToken,
+			SonarHostURLSecret: BuildSecretURL,
+			ImageRepoName:      ERCReposName,
+			EKSClusterName:     EKSClusterName,
+			EKSRole:            AdmRole,
+		},
+		DevopsStackName:    stackName,
+		BuildRoleOutputKey: buildRoleOutputKey,
+		EksAdminRole:       AdmRole,
+	}, populate.Clients{
+		IAM:            iam.New(sess),
+		CloudFormation: cloudformation.New(sess),
+		CodeCommit:     codecommit.New(sess),
+		Kubernetes:     clientset,
+		Git: &populate.GoGit{
+			AuthorName:  "EC",
+			AuthorEmail: "ec@loclahost.com",
+		},
+	}, func(step string) {
+		spin.Stop()
+		fmt.Printf("✅ %s\n", step)
+		spin.Start()
+	})
+	spin.Stop()
+	CheckIfError(err)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"CDK/pkg/mainconfig"
+
+	"k8s.io/client-go/rest"
+)
+
+const kubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: arn:aws:eks:eu-central-1:123456789012:cluster/SonarAWSTuto02
+  cluster:
+    server: https://0123456789ABCDEF.gr7.eu-central-1.eks.amazonaws.com
+contexts:
+- name: sonar
+  context:
+    cluster: arn:aws:eks:eu-central-1:123456789012:cluster/SonarAWSTuto02
+    user: sonar
+current-context: sonar
+users:
+- name: sonar
+  user: {}
+`
+
+func TestGetCurrentClusterName(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(kubeconfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	name, err := getCurrentClusterName(&rest.Config{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "SonarAWSTuto02" {
+		t.Errorf("got %q, want SonarAWSTuto02", name)
+	}
+}
+
+func TestGetConfig(t *testing.T) {
+	// gitdep runs from the devops folder, next to config.json
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	_, config := GetConfig(mainconfig.ConfAuth{}, Configuration{})
+	if config.Reponame == "" || config.ClusterName == "" {
+		t.Errorf("config.json not read: %+v", config)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/aws/aws-cdk-go/awscdk/v2/awscodecommit"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awscodepipeline"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awscodepipelineactions"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsecr"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awssecretsmanager"
@@ -42,6 +43,10 @@ type Configuration struct {
 	PiplineN     string
 	ClusterName  string
 	EksAdminRole string
+	// AttachVpcSecurityGroup runs the build project in the private subnets
+	// of VPCid, with the security group exported by the VPC step
+	AttachVpcSecurityGroup bool
+	VPCid                  string
 }
 
 func GetConfig(configcrd ConfAuth, configjs Configuration) (ConfAuth, Configuration) {
@@ -49,7 +54,6 @@ func GetConfig(configcrd ConfAuth, configjs Configuration) (ConfAuth, Configurat
 	fconfig, err := os.ReadFile("config.json")
 	if err != nil {
 		panic("❌ Problem with the configuration file : config.json")
-		os.Exit(1)
 	}
 	if err := json.Unmarshal(fconfig, &configjs); err != nil {
 		fmt.Println("❌ Error unmarshaling JSON:", err)
@@ -59,7 +63,6 @@ func GetConfig(configcrd ConfAuth, configjs Configuration) (ConfAuth, Configurat
 	fconfig2, err := os.ReadFile("../config_crd.json")
 	if err != nil {
 		panic("❌ Problem with the configuration file : config_crd.json")
-		os.Exit(1)
 	}
 	if err := json.Unmarshal(fconfig2, &configcrd); err != nil {
 		fmt.Println("❌ Error unmarshaling JSON:", err)
@@ -68,12 +71,23 @@ func GetConfig(configcrd ConfAuth, configjs Configuration) (ConfAuth, Configurat
 	return configcrd, configjs
 }
 
+// checkConfiguration checks the settings of config.json that synth can't.
+func checkConfiguration(AppConfig Configuration) error {
+	if AppConfig.AttachVpcSecurityGroup && AppConfig.VPCid == "" {
+		return fmt.Errorf("AttachVpcSecurityGroup needs the VPCid of the VPC step")
+	}
+	return nil
+}
+
 func NewDevopsStack(scope constructs.Construct, id string, props *DevopsStackProps, AppConfig Configuration, AppConfig1 ConfAuth) awscdk.Stack {
 	var sprops awscdk.StackProps
 	if props != nil {
 		sprops = props.StackProps
 	}
 	stack := awscdk.NewStack(scope, &id, &sprops)
+	if err := checkConfiguration(AppConfig); err != nil {
+		panic("❌ Invalid devops configuration: " + err.Error())
+	}
 
 	os.Setenv("AWS_SDK_LOAD_CONFIG", "true")
 	os.Setenv("AWS_PROFILE", AppConfig1.SSOProfile)
@@ -108,14 +122,31 @@ func NewDevopsStack(scope constructs.Construct, id string, props *DevopsStackPro
 		AutoDeleteImages: jsii.Bool(true),
 	})
 
+	// The build reaches the internet through the NAT of the private subnets,
+	// and what the security group of the VPC step is allowed into
+	var vpc awsec2.IVpc
+	var subnets *awsec2.SubnetSelection
+	var securityGroups *[]awsec2.ISecurityGroup
+	if AppConfig.AttachVpcSecurityGroup {
+		vpc = awsec2.Vpc_FromLookup(stack, jsii.String("Vpc"), &awsec2.VpcLookupOptions{VpcId: &AppConfig.VPCid})
+		subnets = &awsec2.SubnetSelection{SubnetType: awsec2.SubnetType_PRIVATE_WITH_EGRESS}
+		securityGroups = &[]awsec2.ISecurityGroup{
+			awsec2.SecurityGroup_FromSecurityGroupId(stack, jsii.String("VpcSecurityGroup"),
+				awscdk.Fn_ImportValue(jsii.String("VPCStack"+AppConfig1.Index+"-SecurityGroupId")), nil),
+		}
+	}
+
 	// Define a CodeBuild project
 	//codeBuildProject := awscodebuild.NewProject(stack, &AppConfig.BuildPr, &awscodebuild.ProjectProps{
 	awscodebuild.NewProject(stack, &AppConfig.BuildPr, &awscodebuild.ProjectProps{
 		Source: awscodebuild.Source_CodeCommit(&awscodebuild.CodeCommitSourceProps{
 			Repository: Repo,
 		}),
-		ProjectName: &BuildPrName,
-		Role:        buildAdminRole,
+		ProjectName:     &BuildPrName,
+		Role:            buildAdminRole,
+		Vpc:             vpc,
+		SubnetSelection: subnets,
+		SecurityGroups:  securityGroups,
 		Environment: &awscodebuild.BuildEnvironment{
 			BuildImage: awscodebuild.LinuxBuildImage_AMAZON_LINUX_2_5(),
 			//BuildImage: awscodebuild.LinuxBuildImage_AMAZON_LINUX_2_ARM_2(),
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"testing"
+
+	"CDK/pkg/snapshot"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
+	"github.com/aws/jsii-runtime-go"
+)
+
+func testConfig() (Configuration, ConfAuth) {
+	return Configuration{
+		Reponame:     "sonar-sample-app",
+		Desc:         "Sample application",
+		GitRepo:      "https://github.com/SonarSource-Demos/sonar-aws-java-app.git",
+		Recr:         "app-container-repo",
+		ImgTag:       "Latest",
+		BuildPr:      "clean-java-code-build",
+		PiplineN:     "main-java-code-build",
+		ClusterName:  "SonarAWSTuto",
+		EksAdminRole: "AdminRole",
+	}, ConfAuth{
+		Region:     "eu-central-1",
+		Account:    "123456789012",
+		SSOProfile: "default",
+		Index:      "02",
+		AWSsecret:  "prod/sonar",
+	}
+}
+
+func TestDevopsStack(t *testing.T) {
+	// GIVEN
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := testConfig()
+
+	// WHEN
+	stack := NewDevopsStack(app, "DevopsStack02", &DevopsStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN
+	template := assertions.Template_FromStack(stack, nil)
+
+	template.ResourceCountIs(jsii.String("AWS::CodeCommit::Repository"), jsii.Number(1))
+	template.ResourceCountIs(jsii.String("AWS::ECR::Repository"), jsii.Number(1))
+	template.ResourceCountIs(jsii.String("AWS::CodeBuild::Project"), jsii.Number(1))
+	template.ResourceCountIs(jsii.String("AWS::CodePipeline::Pipeline"), jsii.Number(1))
+
+	template.HasResourceProperties(jsii.String("AWS::CodeCommit::Repository"), map[string]interface{}{
+		"RepositoryName": "sonar-sample-app-02",
+	})
+
+	template.HasResourceProperties(jsii.String("AWS::CodeBuild::Project"), map[string]interface{}{
+		"Name": "clean-java-code-build-02",
+		"Environment": assertions.Match_ObjectLike(&map[string]interface{}{
+			"PrivilegedMode": true,
+			"EnvironmentVariables": assertions.Match_ArrayWith(&[]interface{}{
+				map[string]interface{}{"Name": "AWS_ACCOUNT_ID", "Type": "PLAINTEXT", "Value": "123456789012"},
+				map[string]interface{}{"Name": "IMAGE_REPO_NAME", "Type": "PLAINTEXT", "Value": "app-container-repo-02"},
+				map[string]interface{}{"Name": "IMAGE_TAG", "Type": "PLAINTEXT", "Value": "Latest"},
+			}),
+		}),
+	})
+
+	// The build role pushes images, reads the Sonar secret and reaches EKS
+	template.HasResourceProperties(jsii.String("AWS::IAM::Role"), map[string]interface{}{
+		"RoleName": "BuildAdminRole02",
+		"AssumeRolePolicyDocument": map[string]interface{}{
+			"Statement": []interface{}{
+				map[string]interface{}{
+					"Action":    "sts:AssumeRole",
+					"Effect":    "Allow",
+					"Principal": map[string]interface{}{"Service": "codebuild.amazonaws.com"},
+				},
+			},
+			"Version": "2012-10-17",
+		},
+	})
+	template.HasResourceProperties(jsii.String("AWS::IAM::Policy"), map[string]interface{}{
+		"PolicyDocument": map[string]interface{}{
+			"Statement": assertions.Match_ArrayWith(&[]interface{}{
+				assertions.Match_ObjectLike(&map[string]interface{}{
+					"Action": assertions.Match_ArrayWith(&[]interface{}{
+						"ecr:PutImage",
+						"eks:*",
+						"secretsmanager:GetSecretValue",
+						"sts:AssumeRole",
+					}),
+					"Effect": "Allow",
+				}),
+			}),
+		},
+	})
+
+	template.HasResourceProperties(jsii.String("AWS::CodePipeline::Pipeline"), map[string]interface{}{
+		"Name": "main-java-code-build-02",
+		"Stages": []interface{}{
+			assertions.Match_ObjectLike(&map[string]interface{}{
+				"Name": "SourceStage",
+				"Actions": []interface{}{
+					assertions.Match_ObjectLike(&map[string]interface{}{
+						"ActionTypeId": map[string]interface{}{
+							"Category": "Source",
+							"Owner":    "AWS",
+							"Provider": "CodeCommit",
+							"Version":  "1",
+						},
+						"Configuration": assertions.Match_ObjectLike(&map[string]interface{}{
+							"RepositoryName": "sonar-sample-app-02",
+						}),
+					}),
+				},
+			}),
+			assertions.Match_ObjectLike(&map[string]interface{}{
+				"Name": "BuildStage",
+				"Actions": []interface{}{
+					assertions.Match_ObjectLike(&map[string]interface{}{
+						"ActionTypeId": map[string]interface{}{
+							"Category": "Build",
+							"Owner":    "AWS",
+							"Provider": "CodeBuild",
+							"Version":  "1",
+						},
+						"Configuration": assertions.Match_ObjectLike(&map[string]interface{}{
+							"ProjectName": "clean-java-code-build-02",
+						}),
+					}),
+				},
+			}),
+		},
+	})
+
+	template.HasOutput(jsii.String("ARNRoleBuildProject"), map[string]interface{}{})
+
+	snapshot.Match(t, "DevopsStack", template.ToJSON())
+}
+
+func TestDevopsStackVpcSecurityGroup(t *testing.T) {
+	// GIVEN
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.AttachVpcSecurityGroup = true
+	AppConfig.VPCid = "vpc-0123456789abcdef0"
+
+	// WHEN
+	stack := NewDevopsStack(app, "DevopsStack02", &DevopsStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN
+	template := assertions.Template_FromStack(stack, nil)
+
+	// The build runs in the private subnets, with the exported security group
+	template.HasResourceProperties(jsii.String("AWS::CodeBuild::Project"), map[string]interface{}{
+		"VpcConfig": map[string]interface{}{
+			"VpcId":   "vpc-12345",
+			"Subnets": assertions.Match_AnyValue(),
+			"SecurityGroupIds": []interface{}{
+				map[string]interface{}{"Fn::ImportValue": "VPCStack02-SecurityGroupId"},
+			},
+		},
+	})
+}
+
+func TestCheckConfiguration(t *testing.T) {
+	AppConfig, _ := testConfig()
+	if err := checkConfiguration(AppConfig); err != nil {
+		t.Errorf("default configuration: %v", err)
+	}
+
+	AppConfig.AttachVpcSecurityGroup = true
+	if err := checkConfiguration(AppConfig); err == nil {
+		t.Error("AttachVpcSecurityGroup without VPCid: no error")
+	}
+}
@@ -3,7 +3,12 @@ module devops
 go 1.21.1
 
 require (
+	CDK/pkg/clusterimport v1.0.0
+	CDK/pkg/kubetunnel v1.0.0
 	CDK/pkg/mainconfig v1.0.0
+	CDK/pkg/populate v1.0.0
+	CDK/pkg/snapshot v1.0.0
+	CDK/pkg/stackoutputs v1.0.0
 	github.com/aws/aws-cdk-go/awscdk/v2 v2.110.1
 	github.com/aws/aws-sdk-go v1.47.9
 	github.com/aws/constructs-go/constructs/v10 v10.3.0
@@ -86,3 +91,13 @@ require (
 )
 
 replace CDK/pkg/mainconfig v1.0.0 => ../pkg/mainconfig
+
+replace CDK/pkg/stackoutputs v1.0.0 => ../pkg/stackoutputs
+
+replace CDK/pkg/populate v1.0.0 => ../pkg/populate
+
+replace CDK/pkg/snapshot v1.0.0 => ../pkg/snapshot
+
+replace CDK/pkg/kubetunnel v1.0.0 => ../pkg/kubetunnel
+
+replace CDK/pkg/clusterimport v1.0.0 => ../pkg/clusterimport
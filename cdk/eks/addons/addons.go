@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+
+	"CDK/pkg/k8sversions"
+	"CDK/pkg/podidentity"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseks"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/constructs-go/constructs/v10"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// latestVersion asks for the newest addon version compatible with the
+// cluster, as does an empty version.
+const latestVersion = "latest"
+
+// addonLockFile records the resolved addon versions, next to cdk.json.
+const addonLockFile = "addons.lock.json"
+
+// Addon describes an EKS managed addon installed by the addons stage.
+type Addon struct {
+	// EKS addon name, e.g. vpc-cni or aws-ebs-csi-driver
+	Name string
+	// Addon version. When empty, the default of K8sVersion for the addons
+	// of the version matrix; "latest", or empty for the other addons, is
+	// the newest version compatible with K8sVersion, pinned in
+	// addons.lock.json
+	Version string
+	// Addon configuration, see aws eks describe-addon-configuration
+	ConfigurationValues map[string]interface{}
+	// What to do with fields changed outside of EKS: NONE, OVERWRITE or
+	// PRESERVE
+	ResolveConflicts string
+}
+
+// knownAddon is what the stage knows about an addon: the construct ID of
+// its CfnAddon and, for the controllers calling AWS, their service account.
+type knownAddon struct {
+	id             string
+	serviceAccount string
+	policy         string
+	roleSuffix     string
+}
+
+var knownAddons = map[string]knownAddon{
+	"vpc-cni":    {id: "VpcCniAddon"},
+	"coredns":    {id: "CoreDnsAddon"},
+	"kube-proxy": {id: "KubeProxyAddon"},
+	"aws-ebs-csi-driver": {
+		id:             "EbsCsiAddon",
+		serviceAccount: "ebs-csi-controller-sa",
+		policy:         "arn:aws:iam::aws:policy/service-role/AmazonEBSCSIDriverPolicy",
+	},
+	"aws-efs-csi-driver": {
+		id:             "EfsCsiAddon",
+		serviceAccount: "efs-csi-controller-sa",
+		policy:         "arn:aws:iam::aws:policy/service-role/AmazonEFSCSIDriverPolicy",
+		roleSuffix:     "EFSCSIDriverRole",
+	},
+	"metrics-server":           {id: "MetricsServerAddon"},
+	podidentity.AgentAddonName: {id: "PodIdentityAgentAddon"},
+}
+
+// addonList returns the configured addons. Without an Addons list it is
+// the EBS CSI driver at AddonVersion, as before the list existed. The Pod
+// Identity agent is added when PodIdentity needs it.
+func addonList(AppConfig Configuration) ([]Addon, error) {
+	addons := AppConfig.Addons
+	if len(addons) == 0 {
+		addons = []Addon{{Name: "aws-ebs-csi-driver", Version: AppConfig.AddonVersion}}
+	}
+
+	seen := make(map[string]bool)
+	for _, addon := range addons {
+		if addon.Name == "" {
+			return nil, errors.New("addon without a Name")
+		}
+		if seen[addon.Name] {
+			return nil, fmt.Errorf("addon %s is listed twice", addon.Name)
+		}
+		seen[addon.Name] = true
+
+		switch addon.ResolveConflicts {
+		case "", "NONE", "OVERWRITE", "PRESERVE":
+		default:
+			return nil, fmt.Errorf("addon %s: unsupported ResolveConflicts %q", addon.Name, addon.ResolveConflicts)
+		}
+	}
+
+	if AppConfig.PodIdentity && !seen[podidentity.AgentAddonName] {
+		addons = append([]Addon{{Name: podidentity.AgentAddonName}}, addons...)
+	}
+	return addons, nil
+}
+
+/*--------------------------- Version resolution ---------------------------------*/
+
+// EKSAPI is the subset of the EKS client used to resolve addon versions.
+// *eks.EKS satisfies it.
+type EKSAPI interface {
+	DescribeAddonVersionsPages(*eks.DescribeAddonVersionsInput, func(*eks.DescribeAddonVersionsOutput, bool) bool) error
+}
+
+// addonLock maps a Kubernetes version to the resolved version of each addon.
+type addonLock map[string]map[string]string
+
+func readAddonLock(file string) (addonLock, error) {
+	content, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return addonLock{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lock := addonLock{}
+	if err := json.Unmarshal(content, &lock); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return lock, nil
+}
+
+func writeAddonLock(file string, lock addonLock) error {
+	content, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(content, '\n'), 0o644)
+}
+
+// latestAddonVersion returns the newest version of name compatible with
+// k8sVersion.
+func latestAddonVersion(api EKSAPI, name, k8sVersion string) (string, error) {
+	var newest *semver.Version
+	var newestName string
+	err := api.DescribeAddonVersionsPages(&eks.DescribeAddonVersionsInput{
+		AddonName:         aws.String(name),
+		KubernetesVersion: aws.String(k8sVersion),
+	}, func(page *eks.DescribeAddonVersionsOutput, _ bool) bool {
+		for _, addon := range page.Addons {
+			for _, info := range addon.AddonVersions {
+				if !compatible(info, k8sVersion) {
+					continue
+				}
+				version, err := semver.NewVersion(aws.StringValue(info.AddonVersion))
+				if err != nil {
+					continue
+				}
+				if newest == nil || version.GreaterThan(newest) {
+					newest, newestName = version, aws.StringValue(info.AddonVersion)
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return "", fmt.Errorf("describing %s versions: %w", name, err)
+	}
+	if newest == nil {
+		return "", fmt.Errorf("no %s version is compatible with Kubernetes %s", name, k8sVersion)
+	}
+	return newestName, nil
+}
+
+func compatible(info *eks.AddonVersionInfo, k8sVersion string) bool {
+	for _, compatibility := range info.Compatibilities {
+		if aws.StringValue(compatibility.ClusterVersion) == k8sVersion {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultAddonVersions sets the version of the addons without one to their
+// default in release, if it has one.
+func defaultAddonVersions(addons []Addon, release k8sversions.Release) {
+	for i, addon := range addons {
+		if version, ok := release.Addons[addon.Name]; ok && addon.Version == "" {
+			addons[i].Version = version
+		}
+	}
+}
+
+// resolveAddonVersions sets the version of the addons asking for the latest
+// one, from lock or, when missing or refresh is set, from EKS. It reports
+// whether lock was changed.
+func resolveAddonVersions(addons []Addon, k8sVersion string, lock addonLock, api func() EKSAPI, refresh bool) (bool, error) {
+	locked := lock[k8sVersion]
+	changed := false
+	for i, addon := range addons {
+		if addon.Version != "" && addon.Version != latestVersion {
+			continue
+		}
+		version, ok := locked[addon.Name]
+		if !ok || refresh {
+			var err error
+			version, err = latestAddonVersion(api(), addon.Name, k8sVersion)
+			if err != nil {
+				return changed, err
+			}
+			if locked == nil {
+				locked = make(map[string]string)
+				lock[k8sVersion] = locked
+			}
+			changed = changed || locked[addon.Name] != version
+			locked[addon.Name] = version
+		}
+		addons[i].Version = version
+	}
+	return changed, nil
+}
+
+// lockAddonVersions resolves the addon versions of AppConfig: the defaults
+// of K8sVersion, then addons.lock.json, calling EKS only for the addons it
+// does not pin yet, or for all of them with refresh. It fails when
+// K8sVersion is not supported.
+func lockAddonVersions(AppConfig Configuration, AppConfig1 ConfAuth, refresh bool) ([]Addon, error) {
+	release, err := k8sversions.Lookup(AppConfig.K8sVersion)
+	if err != nil {
+		return nil, err
+	}
+	addons, err := addonList(AppConfig)
+	if err != nil {
+		return nil, err
+	}
+	defaultAddonVersions(addons, release)
+
+	lock, err := readAddonLock(addonLockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var client EKSAPI
+	api := func() EKSAPI {
+		if client == nil {
+			client = eks.New(session.Must(session.NewSession()), aws.NewConfig().WithRegion(AppConfig1.Region))
+		}
+		return client
+	}
+	changed, err := resolveAddonVersions(addons, AppConfig.K8sVersion, lock, api, refresh)
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		if err := writeAddonLock(addonLockFile, lock); err != nil {
+			return nil, err
+		}
+	}
+	return addons, nil
+}
+
+/*--------------------------- Addon resources ---------------------------------*/
+
+// newAddon creates the CfnAddon of addon. roleArn, when set, is bound to
+// its service account by the addon (IRSA).
+func newAddon(scope constructs.Construct, clusterName string, addon Addon, roleArn *string) (awseks.CfnAddon, error) {
+	id := "Addon-" + addon.Name
+	if known, ok := knownAddons[addon.Name]; ok {
+		id = known.id
+	}
+
+	props := &awseks.CfnAddonProps{
+		ClusterName:           &clusterName,
+		AddonName:             jsii.String(addon.Name),
+		ServiceAccountRoleArn: roleArn,
+	}
+	// EKS installs its default version for the cluster when none is set
+	if addon.Version != "" && addon.Version != latestVersion {
+		props.AddonVersion = jsii.String(addon.Version)
+	}
+	if addon.ResolveConflicts != "" {
+		props.ResolveConflicts = jsii.String(addon.ResolveConflicts)
+	}
+	if len(addon.ConfigurationValues) > 0 {
+		values, err := json.Marshal(addon.ConfigurationValues)
+		if err != nil {
+			return nil, fmt.Errorf("addon %s: %w", addon.Name, err)
+		}
+		props.ConfigurationValues = jsii.String(string(values))
+	}
+	return awseks.NewCfnAddon(scope, &id, props), nil
+}
+
+// newAddons creates the addons and the roles of their service accounts.
+// The Pod Identity agent comes first, the associations wait for it.
+func newAddons(scope constructs.Construct, AppConfig Configuration, clusterName string, addons []Addon, ident *identity) error {
+	sorted := append([]Addon(nil), addons...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name == podidentity.AgentAddonName && sorted[j].Name != podidentity.AgentAddonName
+	})
+
+	for _, addon := range sorted {
+		known := knownAddons[addon.Name]
+		if known.serviceAccount == "" {
+			cfnAddon, err := newAddon(scope, clusterName, addon, nil)
+			if err != nil {
+				return err
+			}
+			if addon.Name == podidentity.AgentAddonName && ident.provider == nil {
+				ident.agent = cfnAddon
+			}
+			continue
+		}
+
+		// The EBS CSI role keeps its configured name and deployed logical ID,
+		// a named role can't be replaced. The ID drops the characters
+		// CloudFormation doesn't allow, e.g. of an imported cluster name
+		roleName := clusterName + known.roleSuffix
+		if addon.Name == "aws-ebs-csi-driver" {
+			roleName = clusterName + AppConfig.EBSRole
+		}
+		policyArn := known.policy
+		role := ident.newRole(scope, roleName, "kube-system", known.serviceAccount, &roleName,
+			[]awsiam.IManagedPolicy{
+				awsiam.ManagedPolicy_FromManagedPolicyArn(scope, jsii.String(path.Base(policyArn)), &policyArn),
+			}, nil)
+		role.role.Node().DefaultChild().(awsiam.CfnRole).OverrideLogicalId(jsii.String(logicalID(roleName)))
+
+		// With Pod Identity the association gives the controller its role
+		var roleArn *string
+		if ident.provider != nil {
+			roleArn = role.role.RoleArn()
+		}
+		cfnAddon, err := newAddon(scope, clusterName, addon, roleArn)
+		if err != nil {
+			return err
+		}
+		cfnAddon.Node().AddDependency(role.construct)
+	}
+	return nil
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^A-Za-z0-9]`)
+
+// logicalID returns name without the characters a CloudFormation logical
+// ID can't have. An alphanumeric name is kept as is.
+func logicalID(name string) string {
+	return nonAlphanumeric.ReplaceAllString(name, "")
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"CDK/pkg/k8sversions"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/jsii-runtime-go"
+)
+
+type fakeEKS struct {
+	// versions of each addon, by Kubernetes version they support
+	versions map[string]map[string][]string
+	calls    int
+}
+
+func (f *fakeEKS) DescribeAddonVersionsPages(in *eks.DescribeAddonVersionsInput, fn func(*eks.DescribeAddonVersionsOutput, bool) bool) error {
+	f.calls++
+	var infos []*eks.AddonVersionInfo
+	for k8sVersion, versions := range f.versions[aws.StringValue(in.AddonName)] {
+		for _, version := range versions {
+			infos = append(infos, &eks.AddonVersionInfo{
+				AddonVersion:    aws.String(version),
+				Compatibilities: []*eks.Compatibility{{ClusterVersion: aws.String(k8sVersion)}},
+			})
+		}
+	}
+	// One page per version, to check that every page is read
+	for i, info := range infos {
+		page := &eks.DescribeAddonVersionsOutput{Addons: []*eks.AddonInfo{{
+			AddonName:     in.AddonName,
+			AddonVersions: []*eks.AddonVersionInfo{info},
+		}}}
+		if !fn(page, i == len(infos)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func newFakeEKS() *fakeEKS {
+	return &fakeEKS{versions: map[string]map[string][]string{
+		"coredns": {
+			"1.28": {"v1.10.1-eksbuild.2", "v1.10.1-eksbuild.6", "v1.9.3-eksbuild.11"},
+			"1.29": {"v1.11.1-eksbuild.4"},
+		},
+		"vpc-cni": {
+			"1.28": {"v1.15.1-eksbuild.1", "v1.16.0-eksbuild.1"},
+		},
+	}}
+}
+
+func TestLatestAddonVersion(t *testing.T) {
+	api := newFakeEKS()
+
+	version, err := latestAddonVersion(api, "coredns", "1.28")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "v1.10.1-eksbuild.6" {
+		t.Errorf("got %s, want v1.10.1-eksbuild.6", version)
+	}
+
+	if _, err := latestAddonVersion(api, "vpc-cni", "1.29"); err == nil {
+		t.Errorf("no error without a compatible version")
+	}
+}
+
+func TestResolveAddonVersions(t *testing.T) {
+	api := newFakeEKS()
+	lock := addonLock{"1.28": {"coredns": "v1.10.1-eksbuild.2"}}
+	addons := []Addon{
+		{Name: "coredns", Version: "latest"},
+		{Name: "vpc-cni"},
+		{Name: "aws-ebs-csi-driver", Version: "v1.25.0-eksbuild.1"},
+	}
+
+	changed, err := resolveAddonVersions(addons, "1.28", lock, func() EKSAPI { return api }, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The locked version is kept, only vpc-cni is resolved
+	if !changed || api.calls != 1 {
+		t.Errorf("got changed %v after %d calls, want true after 1", changed, api.calls)
+	}
+	want := []string{"v1.10.1-eksbuild.2", "v1.16.0-eksbuild.1", "v1.25.0-eksbuild.1"}
+	for i, addon := range addons {
+		if addon.Version != want[i] {
+			t.Errorf("%s: got %s, want %s", addon.Name, addon.Version, want[i])
+		}
+	}
+	if lock["1.28"]["vpc-cni"] != "v1.16.0-eksbuild.1" {
+		t.Errorf("vpc-cni not locked: %v", lock)
+	}
+	if _, ok := lock["1.28"]["aws-ebs-csi-driver"]; ok {
+		t.Errorf("pinned version locked: %v", lock)
+	}
+
+	// Resolving again is reproducible and calls nothing
+	addons = []Addon{{Name: "coredns"}, {Name: "vpc-cni"}}
+	changed, err = resolveAddonVersions(addons, "1.28", lock, func() EKSAPI {
+		t.Fatal("EKS called with every version locked")
+		return nil
+	}, false)
+	if err != nil || changed {
+		t.Errorf("got changed %v, %v", changed, err)
+	}
+
+	// A refresh resolves the locked versions again
+	addons = []Addon{{Name: "coredns"}, {Name: "vpc-cni"}}
+	changed, err = resolveAddonVersions(addons, "1.28", lock, func() EKSAPI { return api }, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed || addons[0].Version != "v1.10.1-eksbuild.6" {
+		t.Errorf("got changed %v, coredns %s", changed, addons[0].Version)
+	}
+}
+
+func TestDefaultAddonVersions(t *testing.T) {
+	release, err := k8sversions.Lookup("1.28")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addons := []Addon{
+		{Name: "kube-proxy"},
+		{Name: "coredns", Version: "latest"},
+		{Name: "aws-ebs-csi-driver", Version: "v1.24.0-eksbuild.1"},
+		{Name: "metrics-server"},
+	}
+	defaultAddonVersions(addons, release)
+
+	// latest, pinned versions and addons outside of the matrix are kept
+	want := []string{release.Addons["kube-proxy"], "latest", "v1.24.0-eksbuild.1", ""}
+	for i, addon := range addons {
+		if addon.Version != want[i] {
+			t.Errorf("%s: got %q, want %q", addon.Name, addon.Version, want[i])
+		}
+	}
+}
+
+func TestAddonLockFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), addonLockFile)
+
+	lock, err := readAddonLock(file)
+	if err != nil || len(lock) != 0 {
+		t.Fatalf("got %v, %v for a missing lock file", lock, err)
+	}
+
+	lock["1.28"] = map[string]string{"coredns": "v1.10.1-eksbuild.6"}
+	if err := writeAddonLock(file, lock); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readAddonLock(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read["1.28"]["coredns"] != "v1.10.1-eksbuild.6" {
+		t.Errorf("got %v", read)
+	}
+}
+
+func TestAddonList(t *testing.T) {
+	AppConfig, _ := testConfig()
+
+	// Without a list, the EBS CSI driver at AddonVersion
+	addons, err := addonList(AppConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addons) != 1 || addons[0].Name != "aws-ebs-csi-driver" || addons[0].Version != "v1.25.0-eksbuild.1" {
+		t.Errorf("got %v", addons)
+	}
+
+	AppConfig.PodIdentity = true
+	addons, _ = addonList(AppConfig)
+	if len(addons) != 2 || addons[0].Name != "eks-pod-identity-agent" {
+		t.Errorf("got %v, want the Pod Identity agent first", addons)
+	}
+
+	for _, invalid := range [][]Addon{
+		{{Name: "coredns"}, {Name: "coredns"}},
+		{{Name: ""}},
+		{{Name: "coredns", ResolveConflicts: "IGNORE"}},
+	} {
+		AppConfig.Addons = invalid
+		if _, err := addonList(AppConfig); err == nil {
+			t.Errorf("no error for %v", invalid)
+		}
+	}
+}
+
+func TestAddons(t *testing.T) {
+	// GIVEN
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.Addons = []Addon{
+		{Name: "vpc-cni", Version: "v1.16.0-eksbuild.1", ResolveConflicts: "OVERWRITE",
+			ConfigurationValues: map[string]interface{}{"env": map[string]interface{}{"ENABLE_PREFIX_DELEGATION": "true"}}},
+		{Name: "coredns", Version: "latest"},
+		{Name: "aws-ebs-csi-driver", Version: "v1.25.0-eksbuild.1"},
+		{Name: "aws-efs-csi-driver", Version: "v1.7.0-eksbuild.1"},
+		{Name: "metrics-server", Version: "v0.7.2-eksbuild.1"},
+	}
+
+	// WHEN
+	stack := NewEksstackconfigStack(app, "EksStackConfig02", &EksstackconfigStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN
+	template := assertions.Template_FromStack(stack, nil)
+	template.ResourceCountIs(jsii.String("AWS::EKS::Addon"), jsii.Number(5))
+
+	template.HasResourceProperties(jsii.String("AWS::EKS::Addon"), map[string]interface{}{
+		"AddonName":           "vpc-cni",
+		"AddonVersion":        "v1.16.0-eksbuild.1",
+		"ResolveConflicts":    "OVERWRITE",
+		"ConfigurationValues": `{"env":{"ENABLE_PREFIX_DELEGATION":"true"}}`,
+	})
+	// Not resolved, EKS picks its default version
+	template.HasResourceProperties(jsii.String("AWS::EKS::Addon"), map[string]interface{}{
+		"AddonName":    "coredns",
+		"AddonVersion": assertions.Match_Absent(),
+	})
+
+	template.HasResourceProperties(jsii.String("AWS::IAM::Role"), map[string]interface{}{
+		"RoleName":          "SonarAWSTuto02EFSCSIDriverRole",
+		"ManagedPolicyArns": []interface{}{"arn:aws:iam::aws:policy/service-role/AmazonEFSCSIDriverPolicy"},
+	})
+	template.HasResource(jsii.String("AWS::EKS::Addon"), map[string]interface{}{
+		"Properties": assertions.Match_ObjectLike(&map[string]interface{}{
+			"AddonName": "aws-efs-csi-driver",
+			"ServiceAccountRoleArn": map[string]interface{}{
+				"Fn::GetAtt": []interface{}{"SonarAWSTuto02EFSCSIDriverRole", "Arn"},
+			},
+		}),
+		"DependsOn": assertions.Match_ArrayWith(&[]interface{}{"SonarAWSTuto02EFSCSIDriverRole"}),
+	})
+}
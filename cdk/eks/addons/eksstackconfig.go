@@ -1,19 +1,15 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/aws/aws-cdk-go/awscdk/v2"
-	"github.com/aws/aws-cdk-go/awscdk/v2/awseks"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -21,19 +17,16 @@ import (
 	"github.com/aws/constructs-go/constructs/v10"
 	"github.com/aws/jsii-runtime-go"
 
+	"CDK/pkg/clusterimport"
+	"CDK/pkg/fargateprofile"
+	"CDK/pkg/kubeapply"
+	"CDK/pkg/kubetunnel"
+
 	"github.com/golang/glog"
-	"k8s.io/apimachinery/pkg/api/meta"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/serializer/yaml"
-
-	//"k8s.io/apimachinery/pkg/util/yaml"
-	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
@@ -58,102 +51,160 @@ type Configuration struct {
 	EBSRole      string
 	Instance     string
 	InstanceSize string
+	// EBS CSI driver version, used when Addons is empty
 	AddonVersion string
 	ScName       string
-	ScNamef      string
+	// EBS volume type (gp3, gp2, io1, io2, st1, sc1), IOPS and throughput
+	// (MiB/s) of the Storage Class
+	StorageType       string
+	StorageIops       int
+	StorageThroughput int
+	// Encrypt the volumes, with the KMS key when set or the AWS managed key
+	StorageEncrypted bool
+	StorageKmsKeyArn string
+	// Delete or Retain, and WaitForFirstConsumer or Immediate
+	StorageReclaimPolicy  string
+	StorageBindingMode    string
+	StorageAllowExpansion bool
+	// Make the Storage Class the cluster default, in place of gp2
+	StorageDefault bool
+	// EKS managed addons, versions resolved into addons.lock.json
+	Addons []Addon
+	// IAM roles for Kubernetes service accounts
+	ServiceAccountRoles []ServiceAccountRole
+	// Bind the CSI drivers and service account roles with EKS Pod
+	// Identity instead of IRSA, which needs no OIDC provider
+	PodIdentity bool
+	// Directories of manifests templates applied to the cluster, relative
+	// to the addons folder
+	ManifestDirs []string
+	// Labels of the worker nodes, set by their nodegroup in the EKS stack,
+	// and the labels the reconcile-nodes lifecycle adds to existing nodes
+	// (NodeLabels when empty)
+	NodeLabels          map[string]string
+	ReconcileNodeLabels map[string]string
+	// Delete the objects applied by a previous run that are no longer in
+	// the manifests
+	PruneManifests bool
+	// How long to wait for the applied workloads and volume claims to be
+	// ready (e.g. "5m"). No wait when empty
+	WaitTimeout string
+	// Fargate profiles of the EKS stack, checked for EBS volume claims
+	FargateProfiles []fargateprofile.Profile
+	// Cluster.Import, an existing cluster used instead of the one of the
+	// EKS stack
+	Cluster clusterimport.Settings
 }
 
+// fieldManager owns the fields applied to the cluster by this stage.
+const fieldManager = "eksstackconfig"
+
 type ClusterProps struct {
-	stack       awscdk.Stack
-	clusterName string
-	region      string
+	stack     awscdk.Stack
+	stackName string
+	// oidcProviderArn, when set, is used instead of the export of stackName
+	oidcProviderArn string
 }
 
 type EksClusterWithOIDC struct {
-	OidcIssuer string
+	OidcProvider awsiam.IOpenIdConnectProvider
 }
 
-func applyResourcesFromYAML(yamlContent []byte, clientset *kubernetes.Clientset, dd *dynamic.DynamicClient) error {
-	decoder := yamlutil.NewYAMLOrJSONDecoder(bytes.NewReader(yamlContent), 100)
-
-	for {
-		var rawObj runtime.RawExtension
-		if err := decoder.Decode(&rawObj); err != nil {
-			if err == io.EOF {
-				break
-			}
-			return err
-		}
-
-		obj, gvk, err := yaml.NewDecodingSerializer(unstructured.UnstructuredJSONScheme).Decode(rawObj.Raw, nil, nil)
-		if err != nil {
-			return err
-		}
-
-		unstructuredMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
-		if err != nil {
-			return err
-		}
+// manifestValues are the config values the manifest templates can use,
+// e.g. {{ .ScName }} or {{ .ClusterName }}. The Storage* values are those of
+// the Storage Class, defaults included.
+type manifestValues struct {
+	Index                string
+	ClusterName          string
+	Region               string
+	Account              string
+	K8sVersion           string
+	ScName               string
+	StorageType          string
+	StorageIops          int
+	StorageThroughput    int
+	StorageEncrypted     bool
+	StorageKmsKeyArn     string
+	StorageReclaimPolicy string
+	StorageBindingMode   string
+}
 
-		unstructuredObj := &unstructured.Unstructured{Object: unstructuredMap}
+func newManifestValues(AppConfig Configuration, AppConfig1 ConfAuth) manifestValues {
+	storage := storageDefaults(AppConfig)
+	return manifestValues{
+		Index:                AppConfig1.Index,
+		ClusterName:          eksClusterName(AppConfig, AppConfig1),
+		Region:               AppConfig1.Region,
+		Account:              AppConfig1.Account,
+		K8sVersion:           AppConfig.K8sVersion,
+		ScName:               AppConfig.ScName,
+		StorageType:          storage.StorageType,
+		StorageIops:          storage.StorageIops,
+		StorageThroughput:    storage.StorageThroughput,
+		StorageEncrypted:     storage.StorageEncrypted,
+		StorageKmsKeyArn:     storage.StorageKmsKeyArn,
+		StorageReclaimPolicy: storage.StorageReclaimPolicy,
+		StorageBindingMode:   storage.StorageBindingMode,
+	}
+}
 
-		gr, err := restmapper.GetAPIGroupResources(clientset.Discovery())
-		if err != nil {
-			return err
-		}
+// manifestDirs returns the directories applied to the cluster, "manifests"
+// when none is configured.
+func manifestDirs(AppConfig Configuration) []string {
+	if len(AppConfig.ManifestDirs) == 0 {
+		return []string{"manifests"}
+	}
+	return AppConfig.ManifestDirs
+}
 
-		mapper := restmapper.NewDiscoveryRESTMapper(gr)
-		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
-		if err != nil {
-			return err
-		}
+// applyManifests server-side applies objs, by kind priority, and reports
+// what happened to each of them. Re-running it leaves unchanged objects alone.
+func applyManifests(objs []*unstructured.Unstructured, AppConfig Configuration, waitTimeout time.Duration, clientset *kubernetes.Clientset, dd *dynamic.DynamicClient) error {
+	applier := &kubeapply.Applier{
+		Client:       dd,
+		Mapper:       kubeapply.NewMapper(clientset.Discovery()),
+		FieldManager: fieldManager,
+		Inventory:    fieldManager + "-inventory",
+		Prune:        AppConfig.PruneManifests,
+		WaitTimeout:  waitTimeout,
+	}
+	results, err := applier.Apply(context.Background(), objs)
+	for _, result := range results {
+		fmt.Printf("✅ %s %s\n", result.Object, result.Action)
+	}
+	return err
+}
 
-		var dri dynamic.ResourceInterface
-		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
-			if unstructuredObj.GetNamespace() == "" {
-				unstructuredObj.SetNamespace("default")
-			}
-			dri = dd.Resource(mapping.Resource).Namespace(unstructuredObj.GetNamespace())
-		} else {
-			dri = dd.Resource(mapping.Resource)
-		}
+// parseWaitTimeout returns the WaitTimeout of AppConfig, or fallback when
+// it is not set.
+func parseWaitTimeout(AppConfig Configuration, fallback time.Duration) (time.Duration, error) {
+	if AppConfig.WaitTimeout == "" {
+		return fallback, nil
+	}
+	return time.ParseDuration(AppConfig.WaitTimeout)
+}
 
-		_, err = dri.Create(context.Background(), unstructuredObj, metav1.CreateOptions{})
-		if err != nil {
-			return err
-		}
+// eksClusterName returns the name of the imported cluster, or of the cluster
+// of the EKS stack.
+func eksClusterName(AppConfig Configuration, AppConfig1 ConfAuth) string {
+	if AppConfig.Cluster.Import != nil {
+		return AppConfig.Cluster.Import.ClusterName()
 	}
-	return nil
+	return AppConfig.ClusterName + AppConfig1.Index
 }
 
+// EksClusterInfo imports the OIDC provider exported by the EKS stack, or
+// the one of an imported cluster. The exported ARN is resolved by
+// CloudFormation at deploy time, so synth needs no AWS call.
 func EksClusterInfo(scope constructs.Construct, id *string, props *ClusterProps) *EksClusterWithOIDC {
 
-	sess := session.Must(session.NewSession())
-	svc := eks.New(sess, aws.NewConfig().WithRegion(props.region))
-
-	for {
-		input := &eks.DescribeClusterInput{
-			Name: aws.String(props.clusterName),
-		}
-		result, err := svc.DescribeCluster(input)
-		if err != nil {
-			fmt.Println("❌ Error describing EKS cluster:", err)
-			return nil
-		}
-
-		status := aws.StringValue(result.Cluster.Status)
-
-		if status == "ACTIVE" {
-			fmt.Println("EKS Cluster is now active.")
-			oidcIssuer := aws.StringValue(result.Cluster.Identity.Oidc.Issuer)
-			return &EksClusterWithOIDC{
-				OidcIssuer: oidcIssuer,
-			}
-		} else {
-			fmt.Println("Cluster status:", status)
-		}
+	oidcProviderArn := awscdk.Fn_ImportValue(jsii.String(props.stackName + "-OidcProviderArn"))
+	if props.oidcProviderArn != "" {
+		oidcProviderArn = jsii.String(props.oidcProviderArn)
+	}
 
-		time.Sleep(30 * time.Second)
+	return &EksClusterWithOIDC{
+		OidcProvider: awsiam.OpenIdConnectProvider_FromOpenIdConnectProviderArn(scope, id, oidcProviderArn),
 	}
 }
 
@@ -162,7 +213,6 @@ func GetConfig(configcrd ConfAuth, configjs Configuration) (ConfAuth, Configurat
 	fconfig, err := os.ReadFile("../config.json")
 	if err != nil {
 		panic("❌ Problem with the configuration file : config.json")
-		os.Exit(1)
 	}
 	if err := json.Unmarshal(fconfig, &configjs); err != nil {
 		fmt.Println("❌ Error unmarshaling JSON:", err)
@@ -172,7 +222,6 @@ func GetConfig(configcrd ConfAuth, configjs Configuration) (ConfAuth, Configurat
 	fconfig2, err := os.ReadFile("../../config_crd.json")
 	if err != nil {
 		panic("❌ Problem with the configuration file : config_crd.json")
-		os.Exit(1)
 	}
 	if err := json.Unmarshal(fconfig2, &configcrd); err != nil {
 		fmt.Println("❌ Error unmarshaling JSON:", err)
@@ -181,38 +230,20 @@ func GetConfig(configcrd ConfAuth, configjs Configuration) (ConfAuth, Configurat
 	return configcrd, configjs
 }
 
-func NewEksstackconfigStack(scope constructs.Construct, id string, props *EksstackconfigStackProps, AppConfig Configuration, AppConfig1 ConfAuth, destroy string) awscdk.Stack {
-	var sprops awscdk.StackProps
-	if props != nil {
-		sprops = props.StackProps
-	}
-	stack := awscdk.NewStack(scope, &id, &sprops)
-
-	// Set Variables
-	var clusterName = AppConfig.ClusterName + AppConfig1.Index
-	var EbsRole = clusterName + AppConfig.EBSRole
-
-	var policyArn = "arn:aws:iam::aws:policy/service-role/AmazonEBSCSIDriverPolicy"
-
-	eksClusterProps := ClusterProps{
-		clusterName: clusterName,
-		region:      AppConfig1.Region,
-	}
-
-	InfosEks := EksClusterInfo(stack, jsii.String("EKSInfo"), &eksClusterProps)
-
-	oidcIssuer := InfosEks.OidcIssuer
-	parts := strings.Split(oidcIssuer, "/")
-	// Get the last part (element) from the slice
-	OpenID := parts[len(parts)-1]
-
-	/*------------------------------ Connect K8s ---------------------------------------------*/
-	// Load Kubeconfig
+// Load Kubeconfig and create the kubernetes clients
+func kubeClients() (*rest.Config, *kubernetes.Clientset, *dynamic.DynamicClient) {
 	kubeconfigPath := filepath.Join(os.Getenv("HOME"), ".kube", "config")
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		config, err = clientcmd.BuildConfigFromFlags("", kubeconfigPath)
 	}
+	if err != nil {
+		glog.Fatalf("❌ Failed to load kubeconfig: %v", err)
+	}
+	// A private endpoint is reached through the port-forward of EKS_API_TUNNEL
+	if err := kubetunnel.FromEnv(config); err != nil {
+		glog.Fatalf("❌ Invalid API tunnel: %v", err)
+	}
 
 	// create kubernetes client
 	dd, err := dynamic.NewForConfig(config)
@@ -224,104 +255,172 @@ func NewEksstackconfigStack(scope constructs.Construct, id string, props *Ekssta
 	if err != nil {
 		glog.Fatalf("❌ Failed to create a ClientSet: %v. Exiting.", err)
 	}
+	return config, clientset, dd
+}
+
+// checkImportedCluster makes sure an imported cluster is the one of the
+// kubeconfig and can be used by the tutorial, before changing it.
+func checkImportedCluster(AppConfig Configuration, AppConfig1 ConfAuth, config *rest.Config, clientset *kubernetes.Clientset) {
+	imported := AppConfig.Cluster.Import
+	if imported == nil {
+		return
+	}
+	api := eks.New(session.Must(session.NewSession()), aws.NewConfig().WithRegion(AppConfig1.Region))
+	if err := clusterimport.Check(context.Background(), *imported, api, clientset, clusterimport.Host(config)); err != nil {
+		log.Fatalf("❌ Imported cluster not usable: %v\n", err)
+	}
+	fmt.Printf("✅ Imported cluster %s checked\n", imported.ClusterName())
+}
 
-	/*---------------------------End Connect K8s ---------------------------------------------*/
+// configureCluster applies the Storage Class and the manifests
+// directories. It talks to the cluster, so it runs from main and never
+// during synth.
+func configureCluster(AppConfig Configuration, AppConfig1 ConfAuth) {
+	config, clientset, dd := kubeClients()
+	checkImportedCluster(AppConfig, AppConfig1, config, clientset)
 
-	/*--------------------------- Change Role Label EKS Node ---------------------------------*/
-	if destroy == "false" {
-		// List all nodes in the cluster
-		nodes, err := clientset.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
+	/*--------------------------- Storage Class ---------------------------------*/
+	sc, err := newStorageClass(AppConfig)
+	if err != nil {
+		log.Fatalf("❌ Invalid Storage Class configuration: %v\n", err)
+	}
+	scObj, err := storageClassObject(sc)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Load and render everything before touching the cluster, so that an
+	// invalid manifest doesn't leave it without its Storage Class
+	objs, err := kubeapply.LoadDirs(manifestDirs(AppConfig), newManifestValues(AppConfig, AppConfig1))
+	if err != nil {
+		log.Fatalf("❌ Error loading manifests: %v\n", err)
+	}
+	objs = append([]*unstructured.Unstructured{scObj}, objs...)
+	objs = append(objs, serviceAccountObjects(AppConfig, AppConfig1)...)
+	waitTimeout, err := parseWaitTimeout(AppConfig, 0)
+	if err != nil {
+		log.Fatalf("❌ Invalid WaitTimeout: %v\n", err)
+	}
+
+	// Parameters, reclaim policy and binding mode can't be updated in place
+	recreated, err := prepareStorageClass(context.Background(), clientset, sc)
+	if err != nil {
+		log.Fatalf("❌ Error checking Storage Class %s: %v\n", sc.Name, err)
+	}
+	if recreated {
+		fmt.Printf("✅ Storage Class %s deleted to change immutable fields, it will be recreated\n", sc.Name)
+	}
+
+	// Apply the Storage Class and the manifests directories
+	err = applyManifests(objs, AppConfig, waitTimeout, clientset, dd)
+	if err != nil {
+		log.Fatalf("❌ Error applying manifests: %v\n", err)
+	}
+
+	if AppConfig.StorageDefault {
+		changed, err := undefaultOtherClasses(context.Background(), clientset, sc.Name)
 		if err != nil {
-			log.Fatal(err)
+			log.Fatalf("❌ Error changing the default Storage Class: %v\n", err)
 		}
+		for _, name := range changed {
+			fmt.Printf("✅ Storage Class %s is no longer the default\n", name)
+		}
+	}
+	fmt.Println("✅ Manifests applied successfully")
 
-		// Label each node with the desired label
-		for _, node := range nodes.Items {
-			nodeName := node.ObjectMeta.Name
-			labels := node.ObjectMeta.Labels
-			if labels == nil {
-				labels = make(map[string]string)
-			}
+	// Pods on Fargate can't mount EBS volumes
+	claims, err := fargateEBSClaims(context.Background(), clientset, AppConfig.FargateProfiles)
+	if err != nil {
+		log.Fatalf("❌ Error checking the volume claims of the Fargate namespaces: %v\n", err)
+	}
+	for _, claim := range claims {
+		fmt.Printf("⚠️  Claim %s is backed by EBS, which its pods can't mount on Fargate: keep them off the Fargate profiles\n", claim)
+	}
+}
+
+// destroyCluster releases the volumes of the Storage Class and removes it,
+// before cdk destroy removes the CSI addon.
+func destroyCluster(AppConfig Configuration, AppConfig1 ConfAuth, deleteVolumes bool) {
+	config, clientset, _ := kubeClients()
+	checkImportedCluster(AppConfig, AppConfig1, config, clientset)
+
+	timeout, err := parseWaitTimeout(AppConfig, defaultVolumeTimeout)
+	if err != nil {
+		log.Fatalf("❌ Invalid WaitTimeout: %v\n", err)
+	}
 
-			// Add or update the label "node-role.kubernetes.io/worker" to "worker"
-			labels["node-role.kubernetes.io/worker"] = "worker"
+	err = destroyStorage(context.Background(), clientset, AppConfig.ScName, deleteVolumes, timeout, 5*time.Second)
+	if err != nil {
+		log.Fatalf("❌ %v\n", err)
+	}
+	fmt.Printf("✅ StorageClass %s deleted successfully\n", AppConfig.ScName)
+}
 
-			node.ObjectMeta.Labels = labels
-			_, err = clientset.CoreV1().Nodes().Update(context.Background(), &node, metav1.UpdateOptions{})
-			if err != nil {
-				log.Printf("❌ Failed to label node %s: %v", nodeName, err)
+// reconcileNodes labels the existing nodes, for clusters whose nodegroups
+// don't set the labels themselves.
+func reconcileNodes(AppConfig Configuration, AppConfig1 ConfAuth) {
+	config, clientset, _ := kubeClients()
+	checkImportedCluster(AppConfig, AppConfig1, config, clientset)
 
-			} else {
-				log.Printf("✅ Successfully labeled node %s", nodeName)
+	patched, err := reconcileNodeLabels(context.Background(), clientset, reconcileLabels(AppConfig))
+	for _, name := range patched {
+		fmt.Printf("✅ Successfully labeled node %s\n", name)
+	}
+	if err != nil {
+		log.Fatalf("❌ Failed to label the nodes: %v\n", err)
+	}
+	fmt.Printf("✅ %d nodes labeled\n", len(patched))
+}
 
-			}
+func NewEksstackconfigStack(scope constructs.Construct, id string, props *EksstackconfigStackProps, AppConfig Configuration, AppConfig1 ConfAuth) awscdk.Stack {
+	var sprops awscdk.StackProps
+	if props != nil {
+		sprops = props.StackProps
+	}
+	stack := awscdk.NewStack(scope, &id, &sprops)
+
+	// Set Variables
+	var clusterName = eksClusterName(AppConfig, AppConfig1)
+
+	// An imported cluster replaces the exports of the EKS stack
+	eksClusterProps := ClusterProps{
+		stackName: "EksStack" + AppConfig1.Index,
+	}
+	if imported := AppConfig.Cluster.Import; imported != nil {
+		if err := imported.Validate(!AppConfig.PodIdentity); err != nil {
+			panic("❌ Invalid cluster import: " + err.Error())
 		}
+		eksClusterProps.oidcProviderArn = imported.OidcProviderArn
 	}
-	/*--------------------------- Change Role Label EKS Node ---------------------------------*/
-
-	/*--------------------------- Created a Role for EBS CSI Storage ------------------------*/
-
-	//Set Federated, Auth and Sub Trust Relationships For Role at CSI Drivers
-	Fed := fmt.Sprintf("%s%s%s%s%s%s", "arn:aws:iam::", AppConfig1.Account, ":oidc-provider/oidc.eks.", AppConfig1.Region, ".amazonaws.com/id/", OpenID)
-	Aud := fmt.Sprintf("%s%s%s%s%s", "oidc.eks.", AppConfig1.Region, ".amazonaws.com/id/", OpenID, ":aud")
-	Sub := fmt.Sprintf("%s%s%s%s%s", "oidc.eks.", AppConfig1.Region, ".amazonaws.com/id/", OpenID, ":sub")
-
-	// Create a PolicyDocument for the AssumeRolePolicyDocument for CSI Role
-	assumeRolePolicy := awsiam.NewPolicyDocument(&awsiam.PolicyDocumentProps{
-		Statements: &[]awsiam.PolicyStatement{
-			awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
-				Effect:  awsiam.Effect_ALLOW,
-				Actions: &[]*string{jsii.String("sts:AssumeRoleWithWebIdentity")},
-				Principals: &[]awsiam.IPrincipal{
-					awsiam.NewFederatedPrincipal(&Fed, nil, nil),
-				},
-				Conditions: &map[string]interface{}{
-					"StringEquals": map[string]interface{}{
-						Aud: "sts.amazonaws.com",
-						Sub: "system:serviceaccount:kube-system:ebs-csi-controller-sa",
-					},
-				},
-			}),
-		},
-	})
-
-	// Create a CfnRole with the AssumeRolePolicyDocument
-	cfnRole := awsiam.NewCfnRole(stack, &EbsRole, &awsiam.CfnRoleProps{
-		AssumeRolePolicyDocument: assumeRolePolicy,
-		RoleName:                 &EbsRole,
-		ManagedPolicyArns: &[]*string{
-			&policyArn,
-		},
-	})
 
-	/*--------------------- End Created a Role dor EBS CSI Storage ------------------------*/
+	addons, err := addonList(AppConfig)
+	if err != nil {
+		panic("❌ Invalid addons configuration: " + err.Error())
+	}
 
-	// Create an EKS addon using CfnAddon
-	EksAddon := awseks.NewCfnAddon(stack, jsii.String("EbsCsiAddon"), &awseks.CfnAddonProps{
-		ClusterName:           &clusterName,
-		AddonName:             jsii.String("aws-ebs-csi-driver"),
-		AddonVersion:          &AppConfig.AddonVersion,
-		ServiceAccountRoleArn: cfnRole.AttrArn(),
-	})
+	ident := identity{clusterName: clusterName}
+	if !AppConfig.PodIdentity {
+		ident.provider = EksClusterInfo(stack, jsii.String("EKSInfo"), &eksClusterProps).OidcProvider
+	}
 
-	EksAddon.Node().AddDependency(cfnRole)
+	// EKS addons, with the roles of the EBS and EFS CSI drivers
+	if err := newAddons(stack, AppConfig, clusterName, addons, &ident); err != nil {
+		panic("❌ Invalid addons configuration: " + err.Error())
+	}
 
-	// Create Storage Class :  managed-csi
-	if destroy == "false" {
-		scYAMLPath := AppConfig.ScNamef
+	// IAM roles for the service accounts of other controllers and workloads
+	newServiceAccountRoles(stack, ident, AppConfig.ServiceAccountRoles)
 
-		scYAML, err := os.ReadFile(scYAMLPath)
+	// Pods on Fargate can't mount EBS volumes: warn before the manifests
+	// are applied
+	if len(AppConfig.FargateProfiles) > 0 {
+		objs, err := kubeapply.LoadDirs(manifestDirs(AppConfig), newManifestValues(AppConfig, AppConfig1))
 		if err != nil {
-			fmt.Printf("Error reading SC YAML file: %v\n", err)
-			os.Exit(1)
+			panic("❌ Invalid manifests: " + err.Error())
 		}
-
-		err = applyResourcesFromYAML(scYAML, clientset, dd)
-		if err != nil {
-			log.Fatalf("❌ Error applying sc.yaml file: %v\n", err)
-			os.Exit(1)
+		for _, claim := range manifestEBSClaims(objs, AppConfig) {
+			awscdk.Annotations_Of(stack).AddWarning(jsii.String("Claim " + claim + " is backed by EBS, which its pods can't mount on Fargate: keep them off the Fargate profiles"))
 		}
-		fmt.Println("✅ Storage Class created successfully")
 	}
 
 	return stack
@@ -338,38 +437,35 @@ func main() {
 	Stack := "EksStackConfig" + AppConfig1.Index
 	app := awscdk.NewApp(nil)
 
-	destroy := app.Node().TryGetContext(jsii.String("destroy"))
-	destroyStr := destroy.(string)
-	if destroy == "true" {
-		//Are you sure you want to delete: DevopsStack02 (y/n)? y
-		kubeconfigPath := filepath.Join(os.Getenv("HOME"), ".kube", "config")
-		config, err := rest.InClusterConfig()
-		if err != nil {
-			config, err = clientcmd.BuildConfigFromFlags("", kubeconfigPath)
-		}
-
-		clientset, err := kubernetes.NewForConfig(config)
-		if err != nil {
-			glog.Fatalf("❌ Failed to create a ClientSet: %v. Exiting.", err)
-		}
-
-		storageClassName := AppConfig.ScName
-
-		err = clientset.StorageV1().StorageClasses().Delete(context.TODO(), storageClassName, metav1.DeleteOptions{})
-		if err != nil {
-			fmt.Printf("❌ Error deleting StorageClass: %v\n", err)
-			os.Exit(1)
-		}
-
-		fmt.Printf("✅ StorageClass %s deleted successfully\n", storageClassName)
+	// The lifecycle context selects the cluster side step: deploy configures
+	// the cluster, destroy releases its volumes before the stack is removed.
+	// Without it the app only synthesizes.
+	mode, err := lifecycleMode(app)
+	if err != nil {
+		log.Fatalf("❌ %v\n", err)
+	}
+	switch mode {
+	case lifecycleDeploy:
+		configureCluster(AppConfig, AppConfig1)
+	case lifecycleDestroy:
+		destroyCluster(AppConfig, AppConfig1, contextValue(app, "delete-volumes") == "true")
+	case lifecycleReconcileNodes:
+		reconcileNodes(AppConfig, AppConfig1)
+	}
 
+	// Pin the addons asking for the latest version, from addons.lock.json or
+	// EKS when not locked yet. --context update-addons=true resolves them again.
+	addons, err := lockAddonVersions(AppConfig, AppConfig1, contextValue(app, "update-addons") == "true")
+	if err != nil {
+		log.Fatalf("❌ Error resolving the addon versions: %v\n", err)
 	}
+	AppConfig.Addons = addons
 
 	NewEksstackconfigStack(app, Stack, &EksstackconfigStackProps{
 		awscdk.StackProps{
 			Env: env(AppConfig1.Region, AppConfig1.Account),
 		},
-	}, AppConfig, AppConfig1, destroyStr)
+	}, AppConfig, AppConfig1)
 
 	app.Synth(nil)
 }
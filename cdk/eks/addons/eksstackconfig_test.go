@@ -1,26 +1,182 @@
 package main
 
-// import (
-// 	"testing"
-
-// 	"github.com/aws/aws-cdk-go/awscdk/v2"
-// 	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
-// 	"github.com/aws/jsii-runtime-go"
-// )
-
-// example tests. To run these tests, uncomment this file along with the
-// example resource in eksstackconfig_test.go
-// func TestEksstackconfigStack(t *testing.T) {
-// 	// GIVEN
-// 	app := awscdk.NewApp(nil)
-
-// 	// WHEN
-// 	stack := NewEksstackconfigStack(app, "MyStack", nil)
-
-// 	// THEN
-// 	template := assertions.Template_FromStack(stack)
-
-// 	template.HasResourceProperties(jsii.String("AWS::SQS::Queue"), map[string]interface{}{
-// 		"VisibilityTimeout": 300,
-// 	})
-// }
+import (
+	"testing"
+
+	"CDK/pkg/clusterimport"
+	"CDK/pkg/kubeapply"
+	"CDK/pkg/snapshot"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
+	"github.com/aws/jsii-runtime-go"
+)
+
+func testConfig() (Configuration, ConfAuth) {
+	return Configuration{
+		ClusterName:  "SonarAWSTuto",
+		K8sVersion:   "1.28",
+		EBSRole:      "CSIDriverRole",
+		AddonVersion: "v1.25.0-eksbuild.1",
+		ScName:       "managed-csi",
+	}, ConfAuth{
+		Region:  "eu-central-1",
+		Account: "123456789012",
+		Index:   "02",
+	}
+}
+
+func TestEksstackconfigStack(t *testing.T) {
+	// GIVEN
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := testConfig()
+
+	// WHEN
+	stack := NewEksstackconfigStack(app, "EksStackConfig02", &EksstackconfigStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN
+	template := assertions.Template_FromStack(stack, nil)
+
+	template.ResourceCountIs(jsii.String("AWS::IAM::Role"), jsii.Number(2))
+	template.ResourceCountIs(jsii.String("AWS::EKS::Addon"), jsii.Number(1))
+
+	// The CSI role trusts the OIDC provider exported by the EKS stack
+	template.HasResourceProperties(jsii.String("AWS::IAM::Role"), map[string]interface{}{
+		"RoleName": "SonarAWSTuto02CSIDriverRole",
+		"AssumeRolePolicyDocument": map[string]interface{}{
+			"Statement": []interface{}{
+				map[string]interface{}{
+					"Action": "sts:AssumeRoleWithWebIdentity",
+					"Effect": "Allow",
+					"Principal": map[string]interface{}{
+						"Federated": map[string]interface{}{"Fn::ImportValue": "EksStack02-OidcProviderArn"},
+					},
+					"Condition": assertions.Match_AnyValue(),
+				},
+			},
+		},
+		"ManagedPolicyArns": []interface{}{
+			"arn:aws:iam::aws:policy/service-role/AmazonEBSCSIDriverPolicy",
+		},
+	})
+
+	template.HasResourceProperties(jsii.String("AWS::EKS::Addon"), map[string]interface{}{
+		"AddonName":    "aws-ebs-csi-driver",
+		"AddonVersion": "v1.25.0-eksbuild.1",
+		"ClusterName":  "SonarAWSTuto02",
+	})
+
+	snapshot.Match(t, "EksStackConfig", template.ToJSON())
+}
+
+func TestEksstackconfigStackImportedCluster(t *testing.T) {
+	// GIVEN
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := testConfig()
+	oidcProviderArn := "arn:aws:iam::123456789012:oidc-provider/oidc.eks.eu-central-1.amazonaws.com/id/0123456789ABCDEF"
+	AppConfig.Cluster.Import = &clusterimport.Import{
+		Cluster:         "arn:aws:eks:eu-central-1:123456789012:cluster/platform",
+		OidcProviderArn: oidcProviderArn,
+		KubectlRoleArn:  "arn:aws:iam::123456789012:role/PlatformAdmin",
+	}
+
+	// WHEN
+	stack := NewEksstackconfigStack(app, "EksStackConfig02", &EksstackconfigStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN
+	template := assertions.Template_FromStack(stack, nil)
+
+	// The roles trust the provider of the imported cluster, not an export
+	template.HasResourceProperties(jsii.String("AWS::IAM::Role"), map[string]interface{}{
+		"RoleName": "platformCSIDriverRole",
+		"AssumeRolePolicyDocument": map[string]interface{}{
+			"Statement": []interface{}{
+				assertions.Match_ObjectLike(&map[string]interface{}{
+					"Principal": map[string]interface{}{"Federated": oidcProviderArn},
+				}),
+			},
+		},
+	})
+	template.HasResourceProperties(jsii.String("AWS::EKS::Addon"), map[string]interface{}{
+		"AddonName":   "aws-ebs-csi-driver",
+		"ClusterName": "platform",
+	})
+
+	values := newManifestValues(AppConfig, AppConfig1)
+	if values.ClusterName != "platform" {
+		t.Errorf("manifest ClusterName = %q, want platform", values.ClusterName)
+	}
+}
+
+func TestEksstackconfigStackImportedClusterLogicalIds(t *testing.T) {
+	// GIVEN
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.Cluster.Import = &clusterimport.Import{
+		Cluster:         "arn:aws:eks:eu-central-1:123456789012:cluster/sonar-eks",
+		OidcProviderArn: "arn:aws:iam::123456789012:oidc-provider/oidc.eks.eu-central-1.amazonaws.com/id/0123456789ABCDEF",
+		KubectlRoleArn:  "arn:aws:iam::123456789012:role/PlatformAdmin",
+	}
+
+	// WHEN
+	stack := NewEksstackconfigStack(app, "EksStackConfig02", &EksstackconfigStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN the role keeps the cluster name, its logical ID is alphanumeric
+	template := assertions.Template_FromStack(stack, nil)
+	roles := template.FindResources(jsii.String("AWS::IAM::Role"), &map[string]interface{}{
+		"Properties": map[string]interface{}{"RoleName": "sonar-eksCSIDriverRole"},
+	})
+	if _, ok := (*roles)["sonareksCSIDriverRole"]; !ok || len(*roles) != 1 {
+		t.Errorf("got roles %v, want the logical ID sonareksCSIDriverRole", *roles)
+	}
+}
+
+func TestManifestsRender(t *testing.T) {
+	AppConfig, AppConfig1 := testConfig()
+
+	// The shipped manifests must render with the config values
+	if _, err := kubeapply.LoadDirs(manifestDirs(AppConfig), newManifestValues(AppConfig, AppConfig1)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManifestValuesStorage(t *testing.T) {
+	AppConfig, AppConfig1 := testConfig()
+	manifest := []byte("{{ .StorageType }} {{ .StorageIops }} {{ .StorageThroughput }} {{ .StorageEncrypted }} " +
+		"{{ .StorageKmsKeyArn }} {{ .StorageReclaimPolicy }} {{ .StorageBindingMode }}")
+
+	// The defaults of the Storage Class are rendered when not set
+	got, err := kubeapply.Render("storage.yaml", manifest, newManifestValues(AppConfig, AppConfig1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "gp3 0 0 false  Delete WaitForFirstConsumer"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	AppConfig.StorageType = "io2"
+	AppConfig.StorageIops = 6000
+	AppConfig.StorageEncrypted = true
+	AppConfig.StorageKmsKeyArn = "arn:aws:kms:eu-central-1:123456789012:key/0123abcd-4567-89ef-0123-456789abcdef"
+	AppConfig.StorageReclaimPolicy = "Retain"
+	AppConfig.StorageBindingMode = "Immediate"
+	got, err = kubeapply.Render("storage.yaml", manifest, newManifestValues(AppConfig, AppConfig1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "io2 6000 0 true " + AppConfig.StorageKmsKeyArn + " Retain Immediate"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
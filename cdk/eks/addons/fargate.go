@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"CDK/pkg/fargateprofile"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// legacyEBSProvisioner is the in-tree EBS provisioner of the gp2 class EKS
+// creates.
+const legacyEBSProvisioner = "kubernetes.io/aws-ebs"
+
+// fargateEBSClaims returns the claims, as "namespace/name (class)", backed
+// by EBS and used by pods the Fargate profiles select. Fargate can't mount
+// EBS volumes, so these pods won't start there. Without labels a selector
+// takes the whole namespace, so all its claims count.
+func fargateEBSClaims(ctx context.Context, clientset kubernetes.Interface, profiles []fargateprofile.Profile) ([]string, error) {
+	if len(profiles) == 0 {
+		return nil, nil
+	}
+	classes, err := clientset.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	ebsClasses := make(map[string]bool)
+	defaultClass := ""
+	for _, class := range classes.Items {
+		if class.Provisioner == ebsProvisioner || class.Provisioner == legacyEBSProvisioner {
+			ebsClasses[class.Name] = true
+		}
+		if class.Annotations[defaultClassAnnotation] == "true" {
+			defaultClass = class.Name
+		}
+	}
+
+	found := make(map[string]bool)
+	for _, profile := range profiles {
+		for _, selector := range profile.Selectors {
+			claims, err := clientset.CoreV1().PersistentVolumeClaims(selector.Namespace).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+
+			var used map[string]bool
+			if len(selector.Labels) > 0 {
+				pods, err := clientset.CoreV1().Pods(selector.Namespace).List(ctx, metav1.ListOptions{
+					LabelSelector: labels.SelectorFromSet(selector.Labels).String(),
+				})
+				if err != nil {
+					return nil, err
+				}
+				used = podClaims(pods.Items)
+			}
+
+			for _, claim := range claims.Items {
+				if used != nil && !used[claim.Name] {
+					continue
+				}
+				class := defaultClass
+				if claim.Spec.StorageClassName != nil {
+					class = *claim.Spec.StorageClassName
+				}
+				if ebsClasses[class] {
+					found[fmt.Sprintf("%s/%s (%s)", claim.Namespace, claim.Name, class)] = true
+				}
+			}
+		}
+	}
+
+	return sortedKeys(found), nil
+}
+
+// podClaims returns the names of the claims pods mount.
+func podClaims(pods []corev1.Pod) map[string]bool {
+	claims := make(map[string]bool)
+	for _, pod := range pods {
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil {
+				claims[volume.PersistentVolumeClaim.ClaimName] = true
+			}
+		}
+	}
+	return claims
+}
+
+// podTemplatePaths are the fields holding the pod template of each
+// workload kind.
+var podTemplatePaths = map[string][]string{
+	"Deployment":  {"spec", "template"},
+	"StatefulSet": {"spec", "template"},
+	"DaemonSet":   {"spec", "template"},
+	"ReplicaSet":  {"spec", "template"},
+	"Job":         {"spec", "template"},
+	"CronJob":     {"spec", "jobTemplate", "spec", "template"},
+}
+
+// manifestEBSClaims returns the claims of the manifests objs, as in
+// fargateEBSClaims, so that synth warns before they are applied. It counts
+// the PersistentVolumeClaims and the volumeClaimTemplates of the
+// StatefulSets. The classes known are gp2, which EKS creates as the
+// default class, the Storage Class of the stage and those of objs: a claim
+// without a class counts as EBS unless a manifest sets another default.
+func manifestEBSClaims(objs []*unstructured.Unstructured, AppConfig Configuration) []string {
+	profiles := AppConfig.FargateProfiles
+	if len(profiles) == 0 {
+		return nil
+	}
+	ebsClasses := map[string]bool{"gp2": true, AppConfig.ScName: true}
+	defaultClass := ""
+	if AppConfig.StorageDefault {
+		defaultClass = AppConfig.ScName
+	}
+	for _, obj := range objs {
+		if obj.GetKind() != "StorageClass" {
+			continue
+		}
+		provisioner, _, _ := unstructured.NestedString(obj.Object, "provisioner")
+		ebsClasses[obj.GetName()] = provisioner == ebsProvisioner || provisioner == legacyEBSProvisioner
+		if obj.GetAnnotations()[defaultClassAnnotation] == "true" {
+			defaultClass = obj.GetName()
+		}
+	}
+	// The class of a claim spec, and whether it is backed by EBS
+	claimClass := func(spec map[string]interface{}) (string, bool) {
+		class, found, _ := unstructured.NestedString(spec, "storageClassName")
+		if !found {
+			if defaultClass == "" {
+				return "default", true
+			}
+			class = defaultClass
+		}
+		return class, ebsClasses[class]
+	}
+
+	found := make(map[string]bool)
+	used := make(map[string]bool)
+	for _, obj := range objs {
+		namespace := objectNamespace(obj)
+		template := obj.Object
+		if path, ok := podTemplatePaths[obj.GetKind()]; ok {
+			template, _, _ = unstructured.NestedMap(obj.Object, path...)
+		} else if obj.GetKind() != "Pod" {
+			continue
+		}
+		podLabels, _, _ := unstructured.NestedStringMap(template, "metadata", "labels")
+		if !fargateprofile.Selects(profiles, namespace, podLabels) {
+			continue
+		}
+
+		volumes, _, _ := unstructured.NestedSlice(template, "spec", "volumes")
+		for _, volume := range volumes {
+			if volume, ok := volume.(map[string]interface{}); ok {
+				if name, found, _ := unstructured.NestedString(volume, "persistentVolumeClaim", "claimName"); found {
+					used[namespace+"/"+name] = true
+				}
+			}
+		}
+
+		// Each pod of a StatefulSet gets a claim of its templates
+		claimTemplates, _, _ := unstructured.NestedSlice(obj.Object, "spec", "volumeClaimTemplates")
+		for _, claimTemplate := range claimTemplates {
+			claimTemplate, ok := claimTemplate.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _, _ := unstructured.NestedString(claimTemplate, "metadata", "name")
+			spec, _, _ := unstructured.NestedMap(claimTemplate, "spec")
+			if class, ebs := claimClass(spec); ebs {
+				found[fmt.Sprintf("%s/%s-%s-* (%s)", namespace, name, obj.GetName(), class)] = true
+			}
+		}
+	}
+
+	for _, obj := range objs {
+		if obj.GetKind() != "PersistentVolumeClaim" {
+			continue
+		}
+		namespace := objectNamespace(obj)
+		if !fargateprofile.SelectsNamespace(profiles, namespace) && !used[namespace+"/"+obj.GetName()] {
+			continue
+		}
+		spec, _, _ := unstructured.NestedMap(obj.Object, "spec")
+		if class, ebs := claimClass(spec); ebs {
+			found[fmt.Sprintf("%s/%s (%s)", namespace, obj.GetName(), class)] = true
+		}
+	}
+	return sortedKeys(found)
+}
+
+// objectNamespace returns the namespace obj is applied to.
+func objectNamespace(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() == "" {
+		return metav1.NamespaceDefault
+	}
+	return obj.GetNamespace()
+}
+
+func sortedKeys(set map[string]bool) []string {
+	var keys []string
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"CDK/pkg/fargateprofile"
+	"CDK/pkg/kubeapply"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
+	"github.com/aws/jsii-runtime-go"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestFargateEBSClaims(t *testing.T) {
+	efs := "efs-sc"
+	clientset := fake.NewSimpleClientset(
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "managed-csi"}, Provisioner: ebsProvisioner},
+		&storagev1.StorageClass{
+			ObjectMeta:  metav1.ObjectMeta{Name: "gp2", Annotations: map[string]string{defaultClassAnnotation: "true"}},
+			Provisioner: legacyEBSProvisioner,
+		},
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: efs}, Provisioner: "efs.csi.aws.com"},
+
+		// The whole namespace is on Fargate
+		claim("sonar-aws-javaapp-ns", "data"),
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "sonar-aws-javaapp-ns", Name: "cache"}},
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "sonar-aws-javaapp-ns", Name: "shared"},
+			Spec:       corev1.PersistentVolumeClaimSpec{StorageClassName: &efs},
+		},
+
+		// Only the pods labeled runner=fargate are
+		claim("builds", "workspace"),
+		claim("builds", "other"),
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "builds", Name: "job", Labels: map[string]string{"runner": "fargate"}},
+			Spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+				Name:         "workspace",
+				VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "workspace"}},
+			}}},
+		},
+
+		claim("sonarqube", "data-sonarqube-0"),
+	)
+
+	claims, err := fargateEBSClaims(context.Background(), clientset, []fargateprofile.Profile{
+		{Name: "sample-app", Selectors: []fargateprofile.Selector{{Namespace: "sonar-aws-javaapp-ns"}}},
+		{Name: "builds", Selectors: []fargateprofile.Selector{{Namespace: "builds", Labels: map[string]string{"runner": "fargate"}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"builds/workspace (managed-csi)",
+		"sonar-aws-javaapp-ns/cache (gp2)",
+		"sonar-aws-javaapp-ns/data (managed-csi)",
+	}
+	if !reflect.DeepEqual(claims, want) {
+		t.Errorf("got %v, want %v", claims, want)
+	}
+}
+
+func TestFargateEBSClaimsWithoutProfiles(t *testing.T) {
+	claims, err := fargateEBSClaims(context.Background(), fake.NewSimpleClientset(claim("sonarqube", "data")), nil)
+	if err != nil || len(claims) != 0 {
+		t.Errorf("got %v, %v", claims, err)
+	}
+}
+
+const fargateManifests = `
+apiVersion: storage.k8s.io/v1
+kind: StorageClass
+metadata:
+  name: efs-sc
+provisioner: efs.csi.aws.com
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: data
+  namespace: sonar-aws-javaapp-ns
+spec:
+  storageClassName: managed-csi
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: shared
+  namespace: sonar-aws-javaapp-ns
+spec:
+  storageClassName: efs-sc
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: workspace
+  namespace: builds
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: other
+  namespace: builds
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: job
+  namespace: builds
+spec:
+  template:
+    metadata:
+      labels:
+        runner: fargate
+    spec:
+      volumes:
+      - name: workspace
+        persistentVolumeClaim:
+          claimName: workspace
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: cache
+  namespace: sonar-aws-javaapp-ns
+spec:
+  template:
+    metadata:
+      labels:
+        app: cache
+  volumeClaimTemplates:
+  - metadata:
+      name: data
+    spec:
+      storageClassName: gp2
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: data-sonarqube-0
+  namespace: sonarqube
+`
+
+func fargateConfig() (Configuration, ConfAuth) {
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.FargateProfiles = []fargateprofile.Profile{
+		{Name: "sample-app", Selectors: []fargateprofile.Selector{{Namespace: "sonar-aws-javaapp-ns"}}},
+		{Name: "builds", Selectors: []fargateprofile.Selector{{Namespace: "builds", Labels: map[string]string{"runner": "fargate"}}}},
+	}
+	return AppConfig, AppConfig1
+}
+
+func TestManifestEBSClaims(t *testing.T) {
+	AppConfig, _ := fargateConfig()
+	objs, err := kubeapply.Decode([]byte(fargateManifests))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := manifestEBSClaims(objs, AppConfig)
+	want := []string{
+		"builds/workspace (default)",
+		"sonar-aws-javaapp-ns/data (managed-csi)",
+		"sonar-aws-javaapp-ns/data-cache-* (gp2)",
+	}
+	if !reflect.DeepEqual(claims, want) {
+		t.Errorf("got %v, want %v", claims, want)
+	}
+
+	// A manifest setting an EFS default class takes the claims without one
+	objs[0].SetAnnotations(map[string]string{defaultClassAnnotation: "true"})
+	claims = manifestEBSClaims(objs, AppConfig)
+	if len(claims) != 2 || claims[0] != "sonar-aws-javaapp-ns/data (managed-csi)" {
+		t.Errorf("got %v with an EFS default class", claims)
+	}
+}
+
+func TestEksstackconfigStackFargateEBSClaims(t *testing.T) {
+	// GIVEN
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "app.yaml"), []byte(fargateManifests), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := fargateConfig()
+	AppConfig.ManifestDirs = []string{dir}
+
+	// WHEN
+	stack := NewEksstackconfigStack(app, "EksStackConfig02", &EksstackconfigStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN
+	// The claims are reported at synth, before the manifests are applied
+	annotations := assertions.Annotations_FromStack(stack)
+	annotations.HasWarning(jsii.String("*"), assertions.Match_StringLikeRegexp(jsii.String("Claim sonar-aws-javaapp-ns/data \\(managed-csi\\) is backed by EBS")))
+	annotations.HasWarning(jsii.String("*"), assertions.Match_StringLikeRegexp(jsii.String("Claim builds/workspace \\(default\\)")))
+	annotations.HasNoWarning(jsii.String("*"), assertions.Match_StringLikeRegexp(jsii.String("sonarqube|shared|builds/other")))
+}
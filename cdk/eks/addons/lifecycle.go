@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/jsii-runtime-go"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Lifecycle modes of the app, selected with --context lifecycle=<mode>.
+const (
+	// lifecycleSynth only builds the template, without AWS or cluster access
+	lifecycleSynth = "synth"
+	// lifecycleDeploy configures the cluster before the stack is deployed
+	lifecycleDeploy = "deploy"
+	// lifecycleDestroy releases the volumes and removes the Storage Class
+	// before the stack, and so the CSI addon, is destroyed
+	lifecycleDestroy = "destroy"
+	// lifecycleReconcileNodes adds the node labels to the existing nodes
+	lifecycleReconcileNodes = "reconcile-nodes"
+)
+
+// defaultVolumeTimeout bounds the wait for the volumes to be deleted when
+// WaitTimeout is not set.
+const defaultVolumeTimeout = 10 * time.Minute
+
+// contextValue returns the context value of key as a string, "" when not
+// set. Values from cdk.json may be booleans, the command line gives strings.
+func contextValue(app awscdk.App, key string) string {
+	value := app.Node().TryGetContext(jsii.String(key))
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// lifecycleMode returns the mode selected by the lifecycle context, or by
+// the destroy context of earlier versions (destroy=false to deploy,
+// destroy=true to destroy). Without either the app only synthesizes.
+func lifecycleMode(app awscdk.App) (string, error) {
+	mode := contextValue(app, "lifecycle")
+	legacy := contextValue(app, "destroy")
+	switch {
+	case mode != "" && legacy != "":
+		return "", fmt.Errorf("set either the lifecycle or the destroy context, not both")
+	case legacy == "true":
+		return lifecycleDestroy, nil
+	case legacy == "false":
+		return lifecycleDeploy, nil
+	case legacy != "":
+		return "", fmt.Errorf("unsupported destroy context %q, use true or false", legacy)
+	case mode == "":
+		return lifecycleSynth, nil
+	}
+
+	switch mode {
+	case lifecycleSynth, lifecycleDeploy, lifecycleDestroy, lifecycleReconcileNodes:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unsupported lifecycle %q, use %s, %s, %s or %s", mode, lifecycleSynth, lifecycleDeploy, lifecycleDestroy, lifecycleReconcileNodes)
+}
+
+// classVolumes lists the volume claims and volumes of the Storage Class name.
+func classVolumes(ctx context.Context, clientset kubernetes.Interface, name string) ([]corev1.PersistentVolumeClaim, []corev1.PersistentVolume, error) {
+	claims, err := clientset.CoreV1().PersistentVolumeClaims(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	var pvcs []corev1.PersistentVolumeClaim
+	for _, pvc := range claims.Items {
+		if pvc.Spec.StorageClassName != nil && *pvc.Spec.StorageClassName == name {
+			pvcs = append(pvcs, pvc)
+		}
+	}
+
+	volumes, err := clientset.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	var pvs []corev1.PersistentVolume
+	for _, pv := range volumes.Items {
+		if pv.Spec.StorageClassName == name {
+			pvs = append(pvs, pv)
+		}
+	}
+	return pvcs, pvs, nil
+}
+
+// podsUsingClaims returns the pods, as namespace/name, mounting one of pvcs.
+// Their claims can't be deleted while they run.
+func podsUsingClaims(ctx context.Context, clientset kubernetes.Interface, pvcs []corev1.PersistentVolumeClaim) ([]string, error) {
+	claims := make(map[string]bool)
+	for _, pvc := range pvcs {
+		claims[pvc.Namespace+"/"+pvc.Name] = true
+	}
+
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var users []string
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil && claims[pod.Namespace+"/"+volume.PersistentVolumeClaim.ClaimName] {
+				users = append(users, pod.Namespace+"/"+pod.Name)
+				break
+			}
+		}
+	}
+	return users, nil
+}
+
+// releaseVolumes deletes pvcs and pvs, then waits until the volumes of the
+// class are gone: the CSI driver deletes a volume once it is detached.
+// Volumes with the Retain policy are only removed from the cluster, their
+// EBS volume is kept and reported.
+func releaseVolumes(ctx context.Context, clientset kubernetes.Interface, name string, pvcs []corev1.PersistentVolumeClaim, pvs []corev1.PersistentVolume, timeout, interval time.Duration) ([]string, error) {
+	for _, pvc := range pvcs {
+		err := clientset.CoreV1().PersistentVolumeClaims(pvc.Namespace).Delete(ctx, pvc.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("deleting claim %s/%s: %w", pvc.Namespace, pvc.Name, err)
+		}
+	}
+
+	var retained []string
+	for _, pv := range pvs {
+		if pv.Spec.PersistentVolumeReclaimPolicy != corev1.PersistentVolumeReclaimRetain {
+			continue
+		}
+		err := clientset.CoreV1().PersistentVolumes().Delete(ctx, pv.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("deleting volume %s: %w", pv.Name, err)
+		}
+		if pv.Spec.CSI != nil {
+			retained = append(retained, pv.Spec.CSI.VolumeHandle)
+		}
+	}
+
+	err := wait.PollUntilContextTimeout(ctx, interval, timeout, true, func(ctx context.Context) (bool, error) {
+		remainingPVCs, remainingPVs, err := classVolumes(ctx, clientset, name)
+		if err != nil {
+			return false, err
+		}
+		attached, err := attachedVolumes(ctx, clientset, pvs)
+		if err != nil {
+			return false, err
+		}
+		return len(remainingPVCs) == 0 && len(remainingPVs) == 0 && attached == 0, nil
+	})
+	if err != nil {
+		return retained, fmt.Errorf("waiting for the volumes of %s to be deleted: %w", name, err)
+	}
+	return retained, nil
+}
+
+// attachedVolumes counts the VolumeAttachments still holding one of pvs.
+func attachedVolumes(ctx context.Context, clientset kubernetes.Interface, pvs []corev1.PersistentVolume) (int, error) {
+	names := make(map[string]bool)
+	for _, pv := range pvs {
+		names[pv.Name] = true
+	}
+	attachments, err := clientset.StorageV1().VolumeAttachments().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, attachment := range attachments.Items {
+		if source := attachment.Spec.Source.PersistentVolumeName; source != nil && names[*source] {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// destroyStorage checks the volumes of the Storage Class before removing
+// it. With volumes left it refuses, unless deleteVolumes is set: then it
+// deletes them and waits for the EBS volumes to detach and be deleted.
+func destroyStorage(ctx context.Context, clientset kubernetes.Interface, name string, deleteVolumes bool, timeout, interval time.Duration) error {
+	pvcs, pvs, err := classVolumes(ctx, clientset, name)
+	if err != nil {
+		return fmt.Errorf("listing the volumes of %s: %w", name, err)
+	}
+
+	if len(pvcs) > 0 || len(pvs) > 0 {
+		for _, pvc := range pvcs {
+			fmt.Printf("   claim %s/%s (%s)\n", pvc.Namespace, pvc.Name, pvc.Status.Phase)
+		}
+		for _, pv := range pvs {
+			fmt.Printf("   volume %s (%s, %s)\n", pv.Name, pv.Status.Phase, pv.Spec.PersistentVolumeReclaimPolicy)
+		}
+		if !deleteVolumes {
+			return fmt.Errorf("Storage Class %s still has %d claims and %d volumes, delete them or run again with --context delete-volumes=true", name, len(pvcs), len(pvs))
+		}
+
+		users, err := podsUsingClaims(ctx, clientset, pvcs)
+		if err != nil {
+			return err
+		}
+		if len(users) > 0 {
+			return fmt.Errorf("the claims are used by pods %s, uninstall them first", strings.Join(users, ", "))
+		}
+
+		retained, err := releaseVolumes(ctx, clientset, name, pvcs, pvs, timeout, interval)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ %d claims and %d volumes of %s deleted\n", len(pvcs), len(pvs), name)
+		for _, volume := range retained {
+			fmt.Printf("✅ EBS volume %s is retained, delete it in the EC2 console when no longer needed\n", volume)
+		}
+	}
+
+	err = clientset.StorageV1().StorageClasses().Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("deleting Storage Class %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestLifecycleMode(t *testing.T) {
+	for _, tc := range []struct {
+		context map[string]interface{}
+		want    string
+	}{
+		{nil, lifecycleSynth},
+		{map[string]interface{}{"lifecycle": "deploy"}, lifecycleDeploy},
+		{map[string]interface{}{"lifecycle": "destroy"}, lifecycleDestroy},
+		{map[string]interface{}{"lifecycle": "reconcile-nodes"}, lifecycleReconcileNodes},
+		{map[string]interface{}{"destroy": "false"}, lifecycleDeploy},
+		{map[string]interface{}{"destroy": true}, lifecycleDestroy},
+		{map[string]interface{}{"lifecycle": "remove"}, ""},
+		{map[string]interface{}{"destroy": "yes"}, ""},
+		{map[string]interface{}{"lifecycle": "deploy", "destroy": "false"}, ""},
+	} {
+		app := awscdk.NewApp(&awscdk.AppProps{Context: &tc.context})
+		mode, err := lifecycleMode(app)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("%v: got %s, want an error", tc.context, mode)
+			}
+			continue
+		}
+		if err != nil || mode != tc.want {
+			t.Errorf("%v: got %q, %v, want %s", tc.context, mode, err, tc.want)
+		}
+	}
+}
+
+func claim(namespace, name string) *corev1.PersistentVolumeClaim {
+	class := "managed-csi"
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &class,
+			VolumeName:       "pv-" + name,
+		},
+		Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+	}
+}
+
+func volume(name string, policy corev1.PersistentVolumeReclaimPolicy) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-" + name},
+		Spec: corev1.PersistentVolumeSpec{
+			StorageClassName:              "managed-csi",
+			PersistentVolumeReclaimPolicy: policy,
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: ebsProvisioner, VolumeHandle: "vol-" + name},
+			},
+		},
+		Status: corev1.PersistentVolumeStatus{Phase: corev1.VolumeBound},
+	}
+}
+
+func storageClass() *storagev1.StorageClass {
+	return &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "managed-csi"}, Provisioner: ebsProvisioner}
+}
+
+// deletingProvisioner deletes the volume of a claim when the claim is
+// deleted and its policy is Delete, as the EBS CSI driver does once the
+// volume is detached.
+func deletingProvisioner(clientset *fake.Clientset) {
+	clientset.PrependReactor("delete", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		name := action.(k8stesting.DeleteAction).GetName()
+		pv, err := clientset.Tracker().Get(corev1.SchemeGroupVersion.WithResource("persistentvolumes"), "", "pv-"+name)
+		if err == nil && pv.(*corev1.PersistentVolume).Spec.PersistentVolumeReclaimPolicy == corev1.PersistentVolumeReclaimDelete {
+			clientset.Tracker().Delete(corev1.SchemeGroupVersion.WithResource("persistentvolumes"), "", "pv-"+name)
+		}
+		return false, nil, nil
+	})
+}
+
+func TestDestroyStorageRefusesWithVolumes(t *testing.T) {
+	clientset := fake.NewSimpleClientset(storageClass(), claim("sonarqube", "data"), volume("data", corev1.PersistentVolumeReclaimDelete))
+
+	err := destroyStorage(context.Background(), clientset, "managed-csi", false, time.Second, time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "delete-volumes=true") {
+		t.Fatalf("got %v, want a refusal", err)
+	}
+	if _, err := clientset.StorageV1().StorageClasses().Get(context.Background(), "managed-csi", metav1.GetOptions{}); err != nil {
+		t.Errorf("Storage Class deleted: %v", err)
+	}
+}
+
+func TestDestroyStorageRefusesWithPods(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "sonarqube", Name: "sonarqube-postgresql-0"},
+		Spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+			Name:         "data",
+			VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}},
+		}}},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	clientset := fake.NewSimpleClientset(storageClass(), claim("sonarqube", "data"), volume("data", corev1.PersistentVolumeReclaimDelete), pod)
+
+	err := destroyStorage(context.Background(), clientset, "managed-csi", true, time.Second, time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "sonarqube/sonarqube-postgresql-0") {
+		t.Fatalf("got %v, want the pod using the claim", err)
+	}
+}
+
+func TestDestroyStorageDeletesVolumes(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		storageClass(),
+		claim("sonarqube", "data"), volume("data", corev1.PersistentVolumeReclaimDelete),
+		claim("sonarqube", "backup"), volume("backup", corev1.PersistentVolumeReclaimRetain),
+	)
+	deletingProvisioner(clientset)
+
+	err := destroyStorage(context.Background(), clientset, "managed-csi", true, time.Second, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pvcs, pvs, err := classVolumes(context.Background(), clientset, "managed-csi")
+	if err != nil || len(pvcs) != 0 || len(pvs) != 0 {
+		t.Errorf("got %d claims and %d volumes left, %v", len(pvcs), len(pvs), err)
+	}
+	_, err = clientset.StorageV1().StorageClasses().Get(context.Background(), "managed-csi", metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("Storage Class not deleted: %v", err)
+	}
+}
+
+func TestDestroyStorageWaitsForDetach(t *testing.T) {
+	source := "pv-data"
+	attachment := &storagev1.VolumeAttachment{
+		ObjectMeta: metav1.ObjectMeta{Name: "csi-data"},
+		Spec: storagev1.VolumeAttachmentSpec{
+			Attacher: ebsProvisioner,
+			NodeName: "node-1",
+			Source:   storagev1.VolumeAttachmentSource{PersistentVolumeName: &source},
+		},
+	}
+	clientset := fake.NewSimpleClientset(storageClass(), claim("sonarqube", "data"), volume("data", corev1.PersistentVolumeReclaimDelete), attachment)
+	deletingProvisioner(clientset)
+
+	err := destroyStorage(context.Background(), clientset, "managed-csi", true, 20*time.Millisecond, time.Millisecond)
+	if err == nil {
+		t.Fatal("no error with the volume still attached")
+	}
+	if _, err := clientset.StorageV1().StorageClasses().Get(context.Background(), "managed-csi", metav1.GetOptions{}); err != nil {
+		t.Errorf("Storage Class deleted before the volume was detached: %v", err)
+	}
+}
+
+func TestDestroyStorageWithoutVolumes(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+
+	// Deleting a Storage Class that is already gone is not an error
+	if err := destroyStorage(context.Background(), clientset, "managed-csi", false, time.Second, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+}
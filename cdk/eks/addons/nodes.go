@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// reconcileLabels returns the labels the reconcile-nodes lifecycle adds to
+// existing nodes: ReconcileNodeLabels, or the NodeLabels of the nodegroup.
+func reconcileLabels(AppConfig Configuration) map[string]string {
+	if len(AppConfig.ReconcileNodeLabels) > 0 {
+		return AppConfig.ReconcileNodeLabels
+	}
+	return AppConfig.NodeLabels
+}
+
+// reconcileNodeLabels adds labels to the nodes missing one of them, with a
+// merge patch that leaves their other labels alone and can't conflict with
+// the kubelet's own updates. It returns the patched nodes.
+func reconcileNodeLabels(ctx context.Context, clientset kubernetes.Interface, labels map[string]string) ([]string, error) {
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": labels},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var patched []string
+	for _, node := range nodes.Items {
+		if hasLabels(node.Labels, labels) {
+			continue
+		}
+		_, err := clientset.CoreV1().Nodes().Patch(ctx, node.Name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+		if err != nil {
+			return patched, err
+		}
+		patched = append(patched, node.Name)
+	}
+	sort.Strings(patched)
+	return patched, nil
+}
+
+func hasLabels(current, labels map[string]string) bool {
+	for key, value := range labels {
+		if current[key] != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func node(name string, labels map[string]string) *corev1.Node {
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func TestReconcileNodeLabels(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		node("node-1", map[string]string{"kubernetes.io/hostname": "node-1"}),
+		node("node-2", map[string]string{"node-role.kubernetes.io/worker": "worker", "role": "worker"}),
+		node("node-3", nil),
+	)
+	labels := map[string]string{"node-role.kubernetes.io/worker": "worker", "role": "worker"}
+
+	patched, err := reconcileNodeLabels(context.Background(), clientset, labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(patched, ","); got != "node-1,node-3" {
+		t.Errorf("got patched nodes %s, want node-1,node-3", got)
+	}
+
+	// Patches only, never a full Update that could conflict
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "update" {
+			t.Errorf("got an update of %v", action.(k8stesting.UpdateAction).GetObject())
+		}
+	}
+
+	current, err := clientset.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Labels["kubernetes.io/hostname"] != "node-1" || current.Labels["node-role.kubernetes.io/worker"] != "worker" {
+		t.Errorf("got labels %v", current.Labels)
+	}
+
+	// Labeled nodes are left alone
+	patched, err = reconcileNodeLabels(context.Background(), clientset, labels)
+	if err != nil || len(patched) != 0 {
+		t.Errorf("got %v, %v on the second run", patched, err)
+	}
+}
+
+func TestReconcileLabels(t *testing.T) {
+	AppConfig, _ := testConfig()
+	AppConfig.NodeLabels = map[string]string{"role": "worker"}
+	if got := reconcileLabels(AppConfig); got["role"] != "worker" {
+		t.Errorf("got %v, want the NodeLabels", got)
+	}
+
+	AppConfig.ReconcileNodeLabels = map[string]string{"node-role.kubernetes.io/worker": "worker"}
+	if got := reconcileLabels(AppConfig); len(got) != 1 || got["node-role.kubernetes.io/worker"] != "worker" {
+		t.Errorf("got %v, want the ReconcileNodeLabels", got)
+	}
+}
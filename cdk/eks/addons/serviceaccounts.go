@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+
+	"CDK/pkg/irsa"
+	"CDK/pkg/podidentity"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/constructs-go/constructs/v10"
+	"github.com/aws/jsii-runtime-go"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ServiceAccountRole describes an IAM role assumed by the pods of one
+// Kubernetes service account.
+type ServiceAccountRole struct {
+	Namespace      string
+	ServiceAccount string
+	// IAM role name, <cluster>-<namespace>-<service account> when empty
+	RoleName          string
+	ManagedPolicyArns []string
+	// IAM policy document added to the role
+	InlinePolicy map[string]interface{}
+}
+
+// serviceAccountRoleName returns the IAM role name of sa. Generated names
+// are cut to the 64 characters IAM allows.
+func serviceAccountRoleName(clusterName string, sa ServiceAccountRole) string {
+	if sa.RoleName != "" {
+		return sa.RoleName
+	}
+	name := clusterName + "-" + sa.Namespace + "-" + sa.ServiceAccount
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// identity creates the IAM roles of service accounts, with IRSA through the
+// cluster's OIDC provider, or with EKS Pod Identity when provider is nil.
+type identity struct {
+	clusterName string
+	provider    awsiam.IOpenIdConnectProvider
+	// agent is the Pod Identity agent addon the associations wait for
+	agent constructs.IConstruct
+}
+
+// serviceAccountRole is the role of one service account and the construct
+// holding it and its trust resources, e.g. for dependencies.
+type serviceAccountRole struct {
+	construct constructs.Construct
+	role      awsiam.Role
+}
+
+// newRole creates the role of namespace/serviceAccount under id. Both
+// modes lay the role out at id/Role, so switching mode updates a named
+// role in place instead of replacing it.
+func (i identity) newRole(scope constructs.Construct, id, namespace, serviceAccount string, roleName *string, managedPolicies []awsiam.IManagedPolicy, inlinePolicies map[string]awsiam.PolicyDocument) serviceAccountRole {
+	if i.provider != nil {
+		role := irsa.NewServiceAccountRole(scope, id, &irsa.ServiceAccountRoleProps{
+			OidcProvider:    i.provider,
+			Namespace:       namespace,
+			ServiceAccount:  serviceAccount,
+			RoleName:        roleName,
+			ManagedPolicies: managedPolicies,
+			InlinePolicies:  inlinePolicies,
+		})
+		return serviceAccountRole{construct: role.Construct, role: role.Role}
+	}
+
+	role := podidentity.NewServiceAccountRole(scope, id, &podidentity.ServiceAccountRoleProps{
+		ClusterName:     &i.clusterName,
+		Namespace:       namespace,
+		ServiceAccount:  serviceAccount,
+		RoleName:        roleName,
+		ManagedPolicies: managedPolicies,
+		InlinePolicies:  inlinePolicies,
+	})
+	if i.agent != nil {
+		role.Association.Node().AddDependency(i.agent)
+	}
+	return serviceAccountRole{construct: role.Construct, role: role.Role}
+}
+
+// newServiceAccountRoles creates one role per configured service account.
+func newServiceAccountRoles(stack awscdk.Stack, ident identity, roles []ServiceAccountRole) {
+	for _, sa := range roles {
+		// The Irsa prefix predates Pod Identity and is kept for both modes
+		id := "Irsa-" + sa.Namespace + "-" + sa.ServiceAccount
+		roleName := serviceAccountRoleName(ident.clusterName, sa)
+
+		var managedPolicies []awsiam.IManagedPolicy
+		for i, arn := range sa.ManagedPolicyArns {
+			managedPolicies = append(managedPolicies,
+				awsiam.ManagedPolicy_FromManagedPolicyArn(stack, jsii.String(fmt.Sprintf("%s-Policy%d", id, i)), jsii.String(arn)))
+		}
+		var inlinePolicies map[string]awsiam.PolicyDocument
+		if len(sa.InlinePolicy) > 0 {
+			inlinePolicies = map[string]awsiam.PolicyDocument{
+				sa.ServiceAccount: awsiam.PolicyDocument_FromJson(sa.InlinePolicy),
+			}
+		}
+
+		role := ident.newRole(stack, id, sa.Namespace, sa.ServiceAccount, &roleName, managedPolicies, inlinePolicies)
+
+		awscdk.NewCfnOutput(stack, jsii.String(id+"-RoleArn"), &awscdk.CfnOutputProps{
+			Value: role.role.RoleArn(),
+		})
+	}
+}
+
+// serviceAccountObjects returns the configured service accounts for the
+// cluster step. With IRSA they are annotated with their role, whose ARN is
+// derived from its name so it is known before the stack is deployed. Pod
+// Identity binds the role through its association and needs no annotation.
+func serviceAccountObjects(AppConfig Configuration, AppConfig1 ConfAuth) []*unstructured.Unstructured {
+	clusterName := eksClusterName(AppConfig, AppConfig1)
+
+	var objs []*unstructured.Unstructured
+	for _, sa := range AppConfig.ServiceAccountRoles {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("ServiceAccount")
+		obj.SetNamespace(sa.Namespace)
+		obj.SetName(sa.ServiceAccount)
+		if !AppConfig.PodIdentity {
+			roleArn := "arn:aws:iam::" + AppConfig1.Account + ":role/" + serviceAccountRoleName(clusterName, sa)
+			obj.SetAnnotations(map[string]string{irsa.RoleArnAnnotation: roleArn})
+		}
+		objs = append(objs, obj)
+	}
+	return objs
+}
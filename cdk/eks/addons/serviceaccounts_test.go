@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
+	"github.com/aws/jsii-runtime-go"
+)
+
+func testServiceAccountRoles() []ServiceAccountRole {
+	return []ServiceAccountRole{
+		{
+			Namespace:         "sonarqube",
+			ServiceAccount:    "reports",
+			ManagedPolicyArns: []string{"arn:aws:iam::aws:policy/AmazonSQSReadOnlyAccess"},
+			InlinePolicy: map[string]interface{}{
+				"Version": "2012-10-17",
+				"Statement": []interface{}{
+					map[string]interface{}{"Effect": "Allow", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::reports/*"},
+				},
+			},
+		},
+		{Namespace: "tools", ServiceAccount: "exporter", RoleName: "ExporterRole"},
+	}
+}
+
+func TestServiceAccountRoles(t *testing.T) {
+	// GIVEN
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.ServiceAccountRoles = testServiceAccountRoles()
+
+	// WHEN
+	stack := NewEksstackconfigStack(app, "EksStackConfig02", &EksstackconfigStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN
+	template := assertions.Template_FromStack(stack, nil)
+
+	template.HasResourceProperties(jsii.String("AWS::IAM::Role"), map[string]interface{}{
+		"RoleName":          "SonarAWSTuto02-sonarqube-reports",
+		"ManagedPolicyArns": []interface{}{"arn:aws:iam::aws:policy/AmazonSQSReadOnlyAccess"},
+		"Policies": []interface{}{
+			assertions.Match_ObjectLike(&map[string]interface{}{"PolicyName": "reports"}),
+		},
+	})
+	template.HasResourceProperties(jsii.String("AWS::IAM::Role"), map[string]interface{}{
+		"RoleName": "ExporterRole",
+	})
+	template.HasOutput(jsii.String("IrsasonarqubereportsRoleArn"), map[string]interface{}{})
+}
+
+func TestServiceAccountObjects(t *testing.T) {
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.ServiceAccountRoles = testServiceAccountRoles()
+
+	objs := serviceAccountObjects(AppConfig, AppConfig1)
+	if len(objs) != 2 {
+		t.Fatalf("got %d service accounts, want 2", len(objs))
+	}
+
+	want := map[string]string{
+		"sonarqube/reports": "arn:aws:iam::123456789012:role/SonarAWSTuto02-sonarqube-reports",
+		"tools/exporter":    "arn:aws:iam::123456789012:role/ExporterRole",
+	}
+	for _, obj := range objs {
+		key := obj.GetNamespace() + "/" + obj.GetName()
+		if got := obj.GetAnnotations()["eks.amazonaws.com/role-arn"]; got != want[key] {
+			t.Errorf("%s: got role %q, want %q", key, got, want[key])
+		}
+		// The apply engine copies the objects
+		obj.DeepCopy()
+	}
+}
+
+func TestServiceAccountRolesPodIdentity(t *testing.T) {
+	// GIVEN
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.ServiceAccountRoles = testServiceAccountRoles()
+	AppConfig.PodIdentity = true
+
+	// WHEN
+	stack := NewEksstackconfigStack(app, "EksStackConfig02", &EksstackconfigStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN
+	template := assertions.Template_FromStack(stack, nil)
+
+	// No OIDC provider import, no CfnJson conditions
+	if body, _ := json.Marshal(template.ToJSON()); strings.Contains(string(body), "OidcProviderArn") {
+		t.Errorf("the stack still imports the OIDC provider")
+	}
+	template.ResourceCountIs(jsii.String("Custom::AWSCDKCfnJson"), jsii.Number(0))
+
+	template.HasResourceProperties(jsii.String("AWS::EKS::Addon"), map[string]interface{}{
+		"AddonName": "eks-pod-identity-agent",
+	})
+	template.HasResourceProperties(jsii.String("AWS::EKS::Addon"), map[string]interface{}{
+		"AddonName":             "aws-ebs-csi-driver",
+		"ServiceAccountRoleArn": assertions.Match_Absent(),
+	})
+
+	// The EBS CSI role keeps its logical ID and name
+	template.HasResource(jsii.String("AWS::IAM::Role"), map[string]interface{}{
+		"Properties": assertions.Match_ObjectLike(&map[string]interface{}{
+			"RoleName": "SonarAWSTuto02CSIDriverRole",
+			"AssumeRolePolicyDocument": assertions.Match_ObjectLike(&map[string]interface{}{
+				"Statement": []interface{}{
+					assertions.Match_ObjectLike(&map[string]interface{}{
+						"Action":    []interface{}{"sts:AssumeRole", "sts:TagSession"},
+						"Principal": map[string]interface{}{"Service": "pods.eks.amazonaws.com"},
+					}),
+				},
+			}),
+		}),
+	})
+	if roles := template.FindResources(jsii.String("AWS::IAM::Role"), nil); (*roles)["SonarAWSTuto02CSIDriverRole"] == nil {
+		t.Errorf("no role with logical ID SonarAWSTuto02CSIDriverRole")
+	}
+
+	template.ResourceCountIs(jsii.String("AWS::EKS::PodIdentityAssociation"), jsii.Number(3))
+	for _, sa := range [][2]string{
+		{"kube-system", "ebs-csi-controller-sa"},
+		{"sonarqube", "reports"},
+		{"tools", "exporter"},
+	} {
+		template.HasResource(jsii.String("AWS::EKS::PodIdentityAssociation"), map[string]interface{}{
+			"Properties": map[string]interface{}{
+				"ClusterName":    "SonarAWSTuto02",
+				"Namespace":      sa[0],
+				"ServiceAccount": sa[1],
+				"RoleArn":        assertions.Match_AnyValue(),
+			},
+			"DependsOn": assertions.Match_ArrayWith(&[]interface{}{"PodIdentityAgentAddon"}),
+		})
+	}
+}
+
+func TestServiceAccountObjectsPodIdentity(t *testing.T) {
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.ServiceAccountRoles = testServiceAccountRoles()
+	AppConfig.PodIdentity = true
+
+	for _, obj := range serviceAccountObjects(AppConfig, AppConfig1) {
+		if annotations := obj.GetAnnotations(); len(annotations) != 0 {
+			t.Errorf("%s/%s: got annotations %v", obj.GetNamespace(), obj.GetName(), annotations)
+		}
+	}
+}
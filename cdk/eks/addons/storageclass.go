@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// defaultClassAnnotation marks the StorageClass used by claims that don't
+// name one.
+const defaultClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+
+const ebsProvisioner = "ebs.csi.aws.com"
+
+// storageDefaults returns AppConfig with the defaults of the Storage Class
+// set: gp3 volumes, deleted with their claim and bound to the node of their
+// first consumer.
+func storageDefaults(AppConfig Configuration) Configuration {
+	if AppConfig.StorageType == "" {
+		AppConfig.StorageType = "gp3"
+	}
+	if AppConfig.StorageReclaimPolicy == "" {
+		AppConfig.StorageReclaimPolicy = "Delete"
+	}
+	if AppConfig.StorageBindingMode == "" {
+		AppConfig.StorageBindingMode = string(storagev1.VolumeBindingWaitForFirstConsumer)
+	}
+	return AppConfig
+}
+
+// newStorageClass builds the EBS CSI StorageClass described by the Storage*
+// fields of config.json.
+func newStorageClass(AppConfig Configuration) (*storagev1.StorageClass, error) {
+	AppConfig = storageDefaults(AppConfig)
+	volumeType := AppConfig.StorageType
+	parameters := map[string]string{
+		"csi.storage.k8s.io/fstype": "ext4",
+		"type":                      volumeType,
+	}
+
+	switch volumeType {
+	case "gp3", "io1", "io2":
+		if AppConfig.StorageIops > 0 {
+			parameters["iops"] = strconv.Itoa(AppConfig.StorageIops)
+		} else if volumeType != "gp3" {
+			return nil, fmt.Errorf("StorageIops is required for %s volumes", volumeType)
+		}
+	case "gp2", "st1", "sc1":
+		if AppConfig.StorageIops > 0 {
+			return nil, fmt.Errorf("StorageIops can't be set for %s volumes", volumeType)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported StorageType %q", volumeType)
+	}
+	if AppConfig.StorageThroughput > 0 {
+		if volumeType != "gp3" {
+			return nil, fmt.Errorf("StorageThroughput is only supported by gp3 volumes")
+		}
+		parameters["throughput"] = strconv.Itoa(AppConfig.StorageThroughput)
+	}
+
+	if AppConfig.StorageKmsKeyArn != "" && !AppConfig.StorageEncrypted {
+		return nil, fmt.Errorf("StorageKmsKeyArn needs StorageEncrypted")
+	}
+	if AppConfig.StorageEncrypted {
+		parameters["encrypted"] = "true"
+		if AppConfig.StorageKmsKeyArn != "" {
+			parameters["kmsKeyId"] = AppConfig.StorageKmsKeyArn
+		}
+	}
+
+	reclaimPolicy := AppConfig.StorageReclaimPolicy
+	if reclaimPolicy != "Delete" && reclaimPolicy != "Retain" {
+		return nil, fmt.Errorf("unsupported StorageReclaimPolicy %q", reclaimPolicy)
+	}
+
+	bindingMode := AppConfig.StorageBindingMode
+	if bindingMode != string(storagev1.VolumeBindingWaitForFirstConsumer) && bindingMode != string(storagev1.VolumeBindingImmediate) {
+		return nil, fmt.Errorf("unsupported StorageBindingMode %q", bindingMode)
+	}
+
+	sc := &storagev1.StorageClass{
+		TypeMeta: metav1.TypeMeta{APIVersion: "storage.k8s.io/v1", Kind: "StorageClass"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: AppConfig.ScName,
+			Annotations: map[string]string{
+				defaultClassAnnotation: strconv.FormatBool(AppConfig.StorageDefault),
+			},
+		},
+		Provisioner:          ebsProvisioner,
+		Parameters:           parameters,
+		ReclaimPolicy:        (*corev1.PersistentVolumeReclaimPolicy)(&reclaimPolicy),
+		VolumeBindingMode:    (*storagev1.VolumeBindingMode)(&bindingMode),
+		AllowVolumeExpansion: &AppConfig.StorageAllowExpansion,
+	}
+	return sc, nil
+}
+
+// storageClassObject converts sc for kubeapply.
+func storageClassObject(sc *storagev1.StorageClass) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(sc)
+	if err != nil {
+		return nil, err
+	}
+	obj := &unstructured.Unstructured{Object: content}
+	unstructured.RemoveNestedField(obj.Object, "metadata", "creationTimestamp")
+	return obj, nil
+}
+
+// prepareStorageClass deletes the existing class named like sc when one of
+// its immutable fields differs, so that the apply recreates it. Bound
+// volumes only keep the class name, so they are not affected.
+func prepareStorageClass(ctx context.Context, clientset kubernetes.Interface, sc *storagev1.StorageClass) (bool, error) {
+	current, err := clientset.StorageV1().StorageClasses().Get(ctx, sc.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if sameImmutableFields(current, sc) {
+		return false, nil
+	}
+
+	// Only delete the version we compared, not one changed in between
+	err = clientset.StorageV1().StorageClasses().Delete(ctx, sc.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &current.UID},
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+func sameImmutableFields(current, desired *storagev1.StorageClass) bool {
+	return current.Provisioner == desired.Provisioner &&
+		equality.Semantic.DeepEqual(current.Parameters, desired.Parameters) &&
+		equality.Semantic.DeepEqual(current.ReclaimPolicy, desired.ReclaimPolicy) &&
+		equality.Semantic.DeepEqual(current.VolumeBindingMode, desired.VolumeBindingMode) &&
+		equality.Semantic.DeepEqual(current.MountOptions, desired.MountOptions) &&
+		equality.Semantic.DeepEqual(current.AllowedTopologies, desired.AllowedTopologies)
+}
+
+// undefaultOtherClasses removes the default mark from every class but name,
+// e.g. the gp2 class EKS creates, so that the cluster has a single default.
+func undefaultOtherClasses(ctx context.Context, clientset kubernetes.Interface, name string) ([]string, error) {
+	classes, err := clientset.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	patch := []byte(`{"metadata":{"annotations":{"` + defaultClassAnnotation + `":"false"}}}`)
+	var changed []string
+	for _, class := range classes.Items {
+		if class.Name == name || class.Annotations[defaultClassAnnotation] != "true" {
+			continue
+		}
+		_, err := clientset.StorageV1().StorageClasses().Patch(ctx, class.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil {
+			return changed, err
+		}
+		changed = append(changed, class.Name)
+	}
+	return changed, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNewStorageClass(t *testing.T) {
+	AppConfig, _ := testConfig()
+	AppConfig.StorageIops = 4000
+	AppConfig.StorageThroughput = 250
+	AppConfig.StorageEncrypted = true
+	AppConfig.StorageKmsKeyArn = "arn:aws:kms:eu-central-1:123456789012:key/abc"
+	AppConfig.StorageAllowExpansion = true
+	AppConfig.StorageDefault = true
+
+	sc, err := newStorageClass(AppConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"csi.storage.k8s.io/fstype": "ext4",
+		"type":                      "gp3",
+		"iops":                      "4000",
+		"throughput":                "250",
+		"encrypted":                 "true",
+		"kmsKeyId":                  "arn:aws:kms:eu-central-1:123456789012:key/abc",
+	}
+	for key, value := range want {
+		if sc.Parameters[key] != value {
+			t.Errorf("parameter %s: got %q, want %q", key, sc.Parameters[key], value)
+		}
+	}
+	if sc.Name != "managed-csi" || sc.Provisioner != "ebs.csi.aws.com" {
+		t.Errorf("got %s provisioned by %s", sc.Name, sc.Provisioner)
+	}
+	if *sc.ReclaimPolicy != "Delete" || *sc.VolumeBindingMode != storagev1.VolumeBindingWaitForFirstConsumer {
+		t.Errorf("got reclaim policy %s and binding mode %s", *sc.ReclaimPolicy, *sc.VolumeBindingMode)
+	}
+	if !*sc.AllowVolumeExpansion || sc.Annotations[defaultClassAnnotation] != "true" {
+		t.Errorf("expected a default class allowing expansion, got %v", sc)
+	}
+}
+
+func TestNewStorageClassInvalid(t *testing.T) {
+	tests := map[string]func(*Configuration){
+		"unknown type":     func(c *Configuration) { c.StorageType = "standard" },
+		"io2 without iops": func(c *Configuration) { c.StorageType = "io2" },
+		"gp2 with iops":    func(c *Configuration) { c.StorageType = "gp2"; c.StorageIops = 3000 },
+		"io2 throughput":   func(c *Configuration) { c.StorageType = "io2"; c.StorageIops = 3000; c.StorageThroughput = 125 },
+		"unencrypted key":  func(c *Configuration) { c.StorageKmsKeyArn = "arn:aws:kms:eu-central-1:123456789012:key/abc" },
+		"reclaim policy":   func(c *Configuration) { c.StorageReclaimPolicy = "Recycle" },
+		"volume bind mode": func(c *Configuration) { c.StorageBindingMode = "Later" },
+	}
+	for name, change := range tests {
+		t.Run(name, func(t *testing.T) {
+			AppConfig, _ := testConfig()
+			change(&AppConfig)
+			if _, err := newStorageClass(AppConfig); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestPrepareStorageClass(t *testing.T) {
+	ctx := context.Background()
+	AppConfig, _ := testConfig()
+	current, err := newStorageClass(AppConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Expansion and the default mark can be patched in place
+	AppConfig.StorageAllowExpansion = true
+	AppConfig.StorageDefault = true
+	desired, _ := newStorageClass(AppConfig)
+	clientset := fake.NewSimpleClientset(current.DeepCopy())
+	if recreated, err := prepareStorageClass(ctx, clientset, desired); err != nil || recreated {
+		t.Fatalf("got recreated=%v, err=%v for mutable changes", recreated, err)
+	}
+
+	// A new volume type needs a new class
+	AppConfig.StorageType = "io2"
+	AppConfig.StorageIops = 3000
+	desired, _ = newStorageClass(AppConfig)
+	if recreated, err := prepareStorageClass(ctx, clientset, desired); err != nil || !recreated {
+		t.Fatalf("got recreated=%v, err=%v for an immutable change", recreated, err)
+	}
+	_, err = clientset.StorageV1().StorageClasses().Get(ctx, "managed-csi", metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("storage class not deleted: %v", err)
+	}
+}
+
+func TestUndefaultOtherClasses(t *testing.T) {
+	ctx := context.Background()
+	class := func(name, isDefault string) *storagev1.StorageClass {
+		return &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{defaultClassAnnotation: isDefault},
+		}}
+	}
+	clientset := fake.NewSimpleClientset(class("gp2", "true"), class("managed-csi", "true"), class("slow", "false"))
+
+	changed, err := undefaultOtherClasses(ctx, clientset, "managed-csi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 || changed[0] != "gp2" {
+		t.Fatalf("got changed classes %v, want [gp2]", changed)
+	}
+
+	for name, want := range map[string]string{"gp2": "false", "managed-csi": "true", "slow": "false"} {
+		sc, err := clientset.StorageV1().StorageClasses().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sc.Annotations[defaultClassAnnotation]; got != want {
+			t.Errorf("%s: got default %q, want %q", name, got, want)
+		}
+	}
+}
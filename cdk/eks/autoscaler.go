@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+
+	"CDK/pkg/irsa"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseks"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsevents"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseventstargets"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awssqs"
+	"github.com/aws/constructs-go/constructs/v10"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// Autoscalers of the Autoscaler setting.
+const (
+	clusterAutoscaler = "cluster-autoscaler"
+	karpenter         = "karpenter"
+)
+
+// Helm chart versions installed when AutoscalerVersion is empty.
+const (
+	defaultClusterAutoscalerVersion = "9.29.3"
+	defaultKarpenterVersion         = "v0.32.1"
+)
+
+// addAutoscaler installs the autoscaler selected by config.json, if any.
+// clusterName is the plain name, usable in a condition key unlike the
+// cluster's token.
+func addAutoscaler(stack awscdk.Stack, cluster awseks.Cluster, clusterName string, vpc awsec2.IVpc, nodegroups []awseks.Nodegroup, AppConfig Configuration) error {
+	switch AppConfig.Autoscaler {
+	case "":
+		return nil
+	case clusterAutoscaler:
+		addClusterAutoscaler(stack, cluster, clusterName, nodegroups, AppConfig)
+		return nil
+	case karpenter:
+		addKarpenter(stack, cluster, clusterName, vpc, AppConfig)
+		return nil
+	}
+	return fmt.Errorf("unsupported Autoscaler %q, use %s or %s", AppConfig.Autoscaler, clusterAutoscaler, karpenter)
+}
+
+func chartVersion(AppConfig Configuration, defaultVersion string) *string {
+	if AppConfig.AutoscalerVersion != "" {
+		return jsii.String(AppConfig.AutoscalerVersion)
+	}
+	return jsii.String(defaultVersion)
+}
+
+/*--------------------------- Cluster Autoscaler ---------------------------------*/
+
+// addClusterAutoscaler installs cluster-autoscaler. It discovers the
+// Auto Scaling groups of the managed nodegroups by the tags EKS sets, and
+// scales them between the MinSize and MaxSize of their nodegroup.
+func addClusterAutoscaler(stack awscdk.Stack, cluster awseks.Cluster, clusterName string, nodegroups []awseks.Nodegroup, AppConfig Configuration) {
+	namespace, serviceAccount := "kube-system", "cluster-autoscaler"
+
+	role := irsa.NewServiceAccountRole(stack, "ClusterAutoscaler", &irsa.ServiceAccountRoleProps{
+		OidcProvider:   cluster.OpenIdConnectProvider(),
+		Namespace:      namespace,
+		ServiceAccount: serviceAccount,
+	})
+	role.Role.AddToPolicy(awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+		Actions: jsii.Strings(
+			"autoscaling:DescribeAutoScalingGroups",
+			"autoscaling:DescribeAutoScalingInstances",
+			"autoscaling:DescribeLaunchConfigurations",
+			"autoscaling:DescribeScalingActivities",
+			"autoscaling:DescribeTags",
+			"ec2:DescribeImages",
+			"ec2:DescribeInstanceTypes",
+			"ec2:DescribeLaunchTemplateVersions",
+			"ec2:GetInstanceTypesFromInstanceRequirements",
+			"eks:DescribeNodegroup",
+		),
+		Resources: jsii.Strings("*"),
+	}))
+	// Only the groups of this cluster's nodegroups can be resized
+	role.Role.AddToPolicy(awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+		Actions: jsii.Strings(
+			"autoscaling:SetDesiredCapacity",
+			"autoscaling:TerminateInstanceInAutoScalingGroup",
+		),
+		Resources: jsii.Strings("*"),
+		Conditions: &map[string]interface{}{
+			"StringEquals": map[string]interface{}{
+				"aws:ResourceTag/k8s.io/cluster-autoscaler/" + clusterName: "owned",
+			},
+		},
+	}))
+
+	cluster.AddHelmChart(jsii.String("ClusterAutoscaler"), &awseks.HelmChartOptions{
+		Chart:      jsii.String("cluster-autoscaler"),
+		Repository: jsii.String("https://kubernetes.github.io/autoscaler"),
+		Release:    jsii.String("cluster-autoscaler"),
+		Namespace:  jsii.String(namespace),
+		Version:    chartVersion(AppConfig, defaultClusterAutoscalerVersion),
+		Values: &map[string]interface{}{
+			"cloudProvider": "aws",
+			"awsRegion":     stack.Region(),
+			"autoDiscovery": map[string]interface{}{"clusterName": clusterName},
+			// The autoscaler minor version must match the cluster's
+			"image": map[string]interface{}{"tag": "v" + AppConfig.K8sVersion + ".0"},
+			"rbac": map[string]interface{}{
+				"serviceAccount": map[string]interface{}{
+					"name":        serviceAccount,
+					"annotations": map[string]interface{}{irsa.RoleArnAnnotation: role.Role.RoleArn()},
+				},
+			},
+			"extraArgs": map[string]interface{}{
+				"balance-similar-node-groups":   true,
+				"skip-nodes-with-system-pods":   false,
+				"skip-nodes-with-local-storage": false,
+			},
+		},
+	})
+
+	// The autoscaler owns the desired size: a deploy must not reset it
+	for _, nodegroup := range nodegroups {
+		nodegroup.Node().DefaultChild().(awseks.CfnNodegroup).AddPropertyDeletionOverride(jsii.String("ScalingConfig.DesiredSize"))
+	}
+}
+
+/*--------------------------- Karpenter ---------------------------------*/
+
+// addKarpenter installs Karpenter, with the role of the nodes it launches,
+// the queue of the EC2 interruption events it handles, and a default
+// NodePool launching nodes in the private subnets of the VPC.
+func addKarpenter(stack awscdk.Stack, cluster awseks.Cluster, clusterName string, vpc awsec2.IVpc, AppConfig Configuration) {
+	namespace, serviceAccount := "karpenter", "karpenter"
+	scope := constructs.NewConstruct(stack, jsii.String("Karpenter"))
+
+	// Role of the nodes, allowed to join the cluster
+	nodeRole := awsiam.NewRole(scope, jsii.String("NodeRole"), &awsiam.RoleProps{
+		AssumedBy: awsiam.NewServicePrincipal(jsii.String("ec2.amazonaws.com"), nil),
+		ManagedPolicies: &[]awsiam.IManagedPolicy{
+			awsiam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("AmazonEKSWorkerNodePolicy")),
+			awsiam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("AmazonEKS_CNI_Policy")),
+			awsiam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("AmazonEC2ContainerRegistryReadOnly")),
+			awsiam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("AmazonSSMManagedInstanceCore")),
+		},
+	})
+	cluster.AwsAuth().AddRoleMapping(nodeRole, &awseks.AwsAuthMapping{
+		Username: jsii.String("system:node:{{EC2PrivateDNSName}}"),
+		Groups:   jsii.Strings("system:bootstrappers", "system:nodes"),
+	})
+
+	// Spot interruptions, rebalance recommendations, instance state changes
+	// and scheduled maintenance let Karpenter drain nodes ahead of time
+	queue := awssqs.NewQueue(scope, jsii.String("InterruptionQueue"), &awssqs.QueueProps{
+		RetentionPeriod: awscdk.Duration_Minutes(jsii.Number(5)),
+		Encryption:      awssqs.QueueEncryption_SQS_MANAGED,
+	})
+	for id, pattern := range map[string]*awsevents.EventPattern{
+		"SpotInterruptionRule":    {Source: jsii.Strings("aws.ec2"), DetailType: jsii.Strings("EC2 Spot Instance Interruption Warning")},
+		"RebalanceRule":           {Source: jsii.Strings("aws.ec2"), DetailType: jsii.Strings("EC2 Instance Rebalance Recommendation")},
+		"InstanceStateChangeRule": {Source: jsii.Strings("aws.ec2"), DetailType: jsii.Strings("EC2 Instance State-change Notification")},
+		"ScheduledChangeRule":     {Source: jsii.Strings("aws.health"), DetailType: jsii.Strings("AWS Health Event")},
+	} {
+		awsevents.NewRule(scope, jsii.String(id), &awsevents.RuleProps{
+			EventPattern: pattern,
+			Targets:      &[]awsevents.IRuleTarget{awseventstargets.NewSqsQueue(queue, nil)},
+		})
+	}
+
+	controller := irsa.NewServiceAccountRole(scope, "Controller", &irsa.ServiceAccountRoleProps{
+		OidcProvider:   cluster.OpenIdConnectProvider(),
+		Namespace:      namespace,
+		ServiceAccount: serviceAccount,
+	})
+	controller.Role.AddToPolicy(awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+		Actions: jsii.Strings(
+			"ec2:CreateFleet",
+			"ec2:CreateLaunchTemplate",
+			"ec2:CreateTags",
+			"ec2:DeleteLaunchTemplate",
+			"ec2:RunInstances",
+			"ec2:TerminateInstances",
+			"ec2:DescribeAvailabilityZones",
+			"ec2:DescribeImages",
+			"ec2:DescribeInstances",
+			"ec2:DescribeInstanceTypeOfferings",
+			"ec2:DescribeInstanceTypes",
+			"ec2:DescribeLaunchTemplates",
+			"ec2:DescribeSecurityGroups",
+			"ec2:DescribeSpotPriceHistory",
+			"ec2:DescribeSubnets",
+			"pricing:GetProducts",
+			"ssm:GetParameter",
+		),
+		Resources: jsii.Strings("*"),
+	}))
+	// Karpenter creates the instance profile of the nodes from their role
+	controller.Role.AddToPolicy(awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+		Actions: jsii.Strings(
+			"iam:AddRoleToInstanceProfile",
+			"iam:CreateInstanceProfile",
+			"iam:DeleteInstanceProfile",
+			"iam:GetInstanceProfile",
+			"iam:RemoveRoleFromInstanceProfile",
+			"iam:TagInstanceProfile",
+		),
+		Resources: jsii.Strings("*"),
+	}))
+	nodeRole.GrantPassRole(controller.Role)
+	queue.GrantConsumeMessages(controller.Role)
+	controller.Role.AddToPolicy(awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+		Actions:   jsii.Strings("eks:DescribeCluster"),
+		Resources: jsii.Strings(*cluster.ClusterArn()),
+	}))
+
+	chart := cluster.AddHelmChart(jsii.String("KarpenterChart"), &awseks.HelmChartOptions{
+		Chart:           jsii.String("karpenter"),
+		Repository:      jsii.String("oci://public.ecr.aws/karpenter/karpenter"),
+		Release:         jsii.String("karpenter"),
+		Namespace:       jsii.String(namespace),
+		CreateNamespace: jsii.Bool(true),
+		Version:         chartVersion(AppConfig, defaultKarpenterVersion),
+		Values: &map[string]interface{}{
+			"settings": map[string]interface{}{
+				"clusterName":       clusterName,
+				"clusterEndpoint":   cluster.ClusterEndpoint(),
+				"interruptionQueue": queue.QueueName(),
+			},
+			"serviceAccount": map[string]interface{}{
+				"name":        serviceAccount,
+				"annotations": map[string]interface{}{irsa.RoleArnAnnotation: controller.Role.RoleArn()},
+			},
+		},
+	})
+
+	// The default node class and pool, applied once the chart has
+	// installed their CRDs
+	var subnets []interface{}
+	for _, subnet := range *vpc.PrivateSubnets() {
+		subnets = append(subnets, map[string]interface{}{"id": subnet.SubnetId()})
+	}
+	nodeClass := map[string]interface{}{
+		"apiVersion": "karpenter.k8s.aws/v1beta1",
+		"kind":       "EC2NodeClass",
+		"metadata":   map[string]interface{}{"name": "default"},
+		"spec": map[string]interface{}{
+			"amiFamily":           "AL2",
+			"role":                nodeRole.RoleName(),
+			"subnetSelectorTerms": subnets,
+			"securityGroupSelectorTerms": []interface{}{
+				map[string]interface{}{"id": cluster.ClusterSecurityGroupId()},
+			},
+		},
+	}
+	nodePool := map[string]interface{}{
+		"apiVersion": "karpenter.sh/v1beta1",
+		"kind":       "NodePool",
+		"metadata":   map[string]interface{}{"name": "default"},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"nodeClassRef": map[string]interface{}{"name": "default"},
+					"requirements": []interface{}{
+						map[string]interface{}{"key": "kubernetes.io/arch", "operator": "In", "values": []interface{}{"amd64"}},
+						map[string]interface{}{"key": "karpenter.sh/capacity-type", "operator": "In", "values": []interface{}{"on-demand", "spot"}},
+						map[string]interface{}{"key": "karpenter.k8s.aws/instance-category", "operator": "In", "values": []interface{}{"c", "m", "r"}},
+					},
+				},
+			},
+			"limits":     map[string]interface{}{"cpu": "64"},
+			"disruption": map[string]interface{}{"consolidationPolicy": "WhenUnderutilized"},
+		},
+	}
+	manifest := cluster.AddManifest(jsii.String("KarpenterDefaultNodePool"), &nodeClass, &nodePool)
+	manifest.Node().AddDependency(chart)
+}
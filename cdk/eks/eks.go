@@ -5,16 +5,13 @@ import (
 	"fmt"
 	"os"
 
+	"CDK/pkg/fargateprofile"
+
 	"github.com/aws/aws-cdk-go/awscdk/v2"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awseks"
-	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/aws/constructs-go/constructs/v10"
 	"github.com/aws/jsii-runtime-go"
-	kubectlv28 "github.com/cdklabs/awscdk-kubectl-go/kubectlv28/v2"
 )
 
 type EksStackProps struct {
@@ -30,17 +27,69 @@ type ConfAuth struct {
 }
 
 type Configuration struct {
-	ClusterName  string
-	VPCid        string
-	K8sVersion   string
-	Workernode   float64
-	EksAdminRole string
-	EBSRole      string
-	Instance     string
-	InstanceSize string
-	AddonVersion string
-	ScName       string
-	ScNamef      string
+	ClusterName string
+	VPCid       string
+	K8sVersion  string
+	// ARN of a kubectl layer for K8sVersion, instead of the bundled one
+	KubectlLayerArn string
+	Workernode      float64
+	EksAdminRole    string
+	EBSRole         string
+	Instance        string
+	InstanceSize    string
+	AddonVersion    string
+	ScName          string
+	// Storage Class settings, used by the addons stage
+	StorageType           string
+	StorageIops           int
+	StorageThroughput     int
+	StorageEncrypted      bool
+	StorageKmsKeyArn      string
+	StorageReclaimPolicy  string
+	StorageBindingMode    string
+	StorageAllowExpansion bool
+	StorageDefault        bool
+	ManifestDirs          []string
+	// Managed nodegroups; without them, one nodegroup of Workernode
+	// Instance/InstanceSize nodes with NodeLabels and NodeTaints
+	Nodegroups []Nodegroup
+	// Fargate profiles, for the namespaces that don't need the nodegroups
+	FargateProfiles []fargateprofile.Profile
+	// Maximum number of nodes of the default nodegroup, Workernode when 0
+	WorkernodeMax float64
+	// cluster-autoscaler or karpenter, and the version of its Helm chart
+	Autoscaler        string
+	AutoscalerVersion string
+	// Instance metadata hop limit of the nodes (1 or 2, 2 when 0), and the
+	// default type and KMS key of their volumes
+	NodeImdsHopLimit    float64
+	NodeVolumeType      string
+	NodeVolumeKmsKeyArn string
+	// Attach the security group of the VPC step, exported by its stack, to
+	// the nodes besides the cluster security group
+	AttachVpcSecurityGroup bool
+	// Labels and taints of the worker nodes, set by their nodegroup
+	NodeLabels map[string]string
+	NodeTaints []NodeTaint
+	// Name suffixes of the cluster service role and of the node role. The
+	// cluster role is the admin role when they have the same suffix
+	EksClusterRole string
+	EksNodeRole    string
+	// IAM principals, e.g. SSO roles, allowed to assume the admin role;
+	// AdminPrincipalArn is the single principal of earlier versions
+	AdminPrincipalArns []string
+	AdminPrincipalArn  string
+	// API endpoint: public, public-and-private or private, and the CIDRs
+	// allowed on the public endpoint, anywhere when empty
+	EndpointAccess    string
+	PublicAccessCidrs []string
+	// Control plane log types, and the retention of their log group in days
+	ClusterLogging          []string
+	ClusterLogRetentionDays float64
+	// Encryption of the Kubernetes Secrets, with a new KMS key or the key
+	// of SecretsKmsKeyArn
+	SecretsEncryption bool
+	SecretsKmsKeyArn  string
 }
 
 func GetConfig(configcrd ConfAuth, configjs Configuration) (ConfAuth, Configuration) {
@@ -48,7 +97,6 @@ func GetConfig(configcrd ConfAuth, configjs Configuration) (ConfAuth, Configurat
 	fconfig, err := os.ReadFile("config.json")
 	if err != nil {
 		panic("❌ Problem with the configuration file : config.json")
-		os.Exit(1)
 	}
 	if err := json.Unmarshal(fconfig, &configjs); err != nil {
 		fmt.Println("❌ Error unmarshaling JSON:", err)
@@ -58,7 +106,6 @@ func GetConfig(configcrd ConfAuth, configjs Configuration) (ConfAuth, Configurat
 	fconfig2, err := os.ReadFile("../config_crd.json")
 	if err != nil {
 		panic("❌ Problem with the configuration file : config_crd.json")
-		os.Exit(1)
 	}
 	if err := json.Unmarshal(fconfig2, &configcrd); err != nil {
 		fmt.Println("❌ Error unmarshaling JSON:", err)
@@ -76,117 +123,114 @@ func NewEksStack(scope constructs.Construct, id string, props *EksStackProps, Ap
 
 	// Set Variables
 	var clusterName = AppConfig.ClusterName + AppConfig1.Index
-	var AdmRole = clusterName + AppConfig.EksAdminRole
-
-	// ARN policies for Role eksadmin
-	var policyArn2 = "arn:aws:iam::aws:policy/AmazonEKSWorkerNodePolicy"
-	var policyArn3 = "arn:aws:iam::aws:policy/AmazonEKS_CNI_Policy"
-	var policyArn4 = "arn:aws:iam::aws:policy/ElasticLoadBalancingFullAccess"
-	var policyArn5 = "arn:aws:iam::aws:policy/AmazonEC2ContainerRegistryReadOnly"
-	var policyArn6 = "arn:aws:iam::aws:policy/CloudWatchAgentServerPolicy"
-	var policyArn7 = "arn:aws:iam::aws:policy/AmazonEC2FullAccess"
-	var policyArn8 = "arn:aws:iam::aws:policy/AmazonEKSVPCResourceController"
-	var policyArn9 = "arn:aws:iam::aws:policy/AmazonEKSClusterPolicy"
-
-	// Open AWS session
-	sess := session.Must(session.NewSession(&aws.Config{
-		Region: &AppConfig1.Region,
-	}))
-
-	//------------------------Get Sts Account --------------------------------------//
-	// Create an STS service client
-	svc := sts.New(sess)
-
-	// Create an STS API request to get caller identity
-	inputuser := &sts.GetCallerIdentityInput{}
-	resultuser, err := svc.GetCallerIdentity(inputuser)
-
-	if err != nil {
-		fmt.Println("Error:", err)
-		os.Exit(1)
-	}
-
-	// Access and print the caller's ARN
-	ArnPrincipal := *resultuser.Arn
-
-	//------------------------END Get Sts Account --------------------------------------//
 
 	// Get VPC and Set Variables for EC2 instance
 	PartVpc := awsec2.Vpc_FromLookup(stack, &AppConfig.VPCid, &awsec2.VpcLookupOptions{VpcId: &AppConfig.VPCid})
 
-	Instance := AppConfig.Instance
-	InstanceSZ := AppConfig.InstanceSize
-
-	// Define the trusted service principals dor EKS RoleAdmin
-	trustedService1 := awsiam.NewServicePrincipal(jsii.String("eks.amazonaws.com"), nil)
-	trustedService2 := awsiam.NewArnPrincipal(&ArnPrincipal)
-	trustedPrincipals := awsiam.NewCompositePrincipal(trustedService1, trustedService2)
-
-	// Define an IAM policy statement with multiple actions
-	myPolicyStatement := awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
-		Effect: awsiam.Effect_ALLOW,
-		Actions: &[]*string{
-			jsii.String("ec2:CreateVolume"),
-			jsii.String("ec2:DeleteVolume"),
-			jsii.String("ec2:DetachVolume"),
-			jsii.String("ec2:AttachVolume"),
-			jsii.String("ec2:DescribeInstances"),
-			jsii.String("ec2:CreateTags"),
-			jsii.String("ec2:DeleteTags"),
-			jsii.String("ec2:DescribeTags"),
-			jsii.String("ec2:DescribeVolumes"),
-		},
-		Resources: &[]*string{
-			jsii.String("*"),
-		},
-	})
+	// Cluster, admin and node roles
+	roles, err := newClusterRoles(stack, clusterName, AppConfig)
+	if err != nil {
+		panic("❌ Invalid role configuration: " + err.Error())
+	}
 
-	// Define IAM role for the EKS cluster.
-	eksAdminRole := awsiam.NewRole(stack, &AdmRole, &awsiam.RoleProps{
-		AssumedBy: trustedPrincipals,
-		RoleName:  &AdmRole,
-	})
+	// kubectl layer and load balancer controller matching K8sVersion
+	kubectlLayer, albController, err := clusterVersions(stack, AppConfig)
+	if err != nil {
+		panic("❌ Invalid K8sVersion: " + err.Error())
+	}
+
+	access, err := endpointAccess(AppConfig)
+	if err != nil {
+		panic("❌ Invalid endpoint configuration: " + err.Error())
+	}
 
-	eksAdminRole.AddManagedPolicy(awsiam.ManagedPolicy_FromManagedPolicyArn(stack, jsii.String("AmazonEKSWorkerNodePolicy"), &policyArn2))
-	eksAdminRole.AddManagedPolicy(awsiam.ManagedPolicy_FromManagedPolicyArn(stack, jsii.String("AmazonEKS_CNI_Policy"), &policyArn3))
-	eksAdminRole.AddManagedPolicy(awsiam.ManagedPolicy_FromManagedPolicyArn(stack, jsii.String("ElasticLoadBalancingFullAccess"), &policyArn4))
-	eksAdminRole.AddManagedPolicy(awsiam.ManagedPolicy_FromManagedPolicyArn(stack, jsii.String("AmazonEC2ContainerRegistryReadOnly"), &policyArn5))
-	eksAdminRole.AddManagedPolicy(awsiam.ManagedPolicy_FromManagedPolicyArn(stack, jsii.String("CloudWatchAgentServerPolicy"), &policyArn6))
-	eksAdminRole.AddManagedPolicy(awsiam.ManagedPolicy_FromManagedPolicyArn(stack, jsii.String("AmazonEC2FullAccess"), &policyArn7))
-	eksAdminRole.AddManagedPolicy(awsiam.ManagedPolicy_FromManagedPolicyArn(stack, jsii.String("AmazonEKSVPCResourceController"), &policyArn8))
-	eksAdminRole.AddManagedPolicy(awsiam.ManagedPolicy_FromManagedPolicyArn(stack, jsii.String("AmazonEKSClusterPolicy"), &policyArn9))
-	eksAdminRole.AddToPolicy(myPolicyStatement)
+	// Control plane logs and encryption of the Secrets
+	logging, err := clusterLogging(AppConfig)
+	if err != nil {
+		panic("❌ Invalid logging configuration: " + err.Error())
+	}
+	logGroup, err := addClusterLogGroup(stack, clusterName, AppConfig)
+	if err != nil {
+		panic("❌ Invalid logging configuration: " + err.Error())
+	}
+	secretsEncryptionKey, err := secretsKey(stack, clusterName, AppConfig)
+	if err != nil {
+		panic("❌ Invalid encryption configuration: " + err.Error())
+	}
 
 	// Create the EKS cluster.
 	eksCluster := awseks.NewCluster(stack, &clusterName, &awseks.ClusterProps{
-		ClusterName:             &clusterName,
-		Vpc:                     PartVpc,
-		Role:                    eksAdminRole,
-		MastersRole:             eksAdminRole,
-		Version:                 awseks.KubernetesVersion_Of(&AppConfig.K8sVersion),
-		KubectlLayer:            kubectlv28.NewKubectlV28Layer(stack, jsii.String("kubectl128layer")),
-		DefaultCapacity:         &AppConfig.Workernode,
-		DefaultCapacityInstance: awsec2.InstanceType_Of(awsec2.InstanceClass(Instance), awsec2.InstanceSize(InstanceSZ)),
-		DefaultCapacityType:     awseks.DefaultCapacityType_NODEGROUP,
-		EndpointAccess:          awseks.EndpointAccess_PUBLIC(),
-		OutputConfigCommand:     jsii.Bool(true),
+		ClusterName:  &clusterName,
+		Vpc:          PartVpc,
+		Role:         roles.cluster,
+		MastersRole:  roles.admin,
+		Version:      awseks.KubernetesVersion_Of(&AppConfig.K8sVersion),
+		KubectlLayer: kubectlLayer,
+		// The worker nodegroups are added below, with their labels and taints
+		DefaultCapacity:      jsii.Number(0),
+		EndpointAccess:       access,
+		ClusterLogging:       logging,
+		SecretsEncryptionKey: secretsEncryptionKey,
+		OutputConfigCommand:  jsii.Bool(true),
 		Tags: &map[string]*string{
 			"Env":                               jsii.String("Dev"),
 			"k8s.io/cluster-autoscaler/enabled": jsii.String("true"),
 		},
 		AlbController: &awseks.AlbControllerOptions{
-			Version: awseks.AlbControllerVersion_V2_5_1(),
+			Version: albController,
 		},
 	})
 
 	//Add Dependency : waiting The Adim Role created
-	eksCluster.Node().AddDependency(eksAdminRole)
+	eksCluster.Node().AddDependency(roles.cluster, roles.admin)
+	if logGroup != nil {
+		eksCluster.Node().AddDependency(logGroup)
+	}
+
+	// Without a public endpoint the tooling reaches the API through SSM
+	if AppConfig.EndpointAccess == endpointPrivate {
+		addApiAccessHost(stack, eksCluster, PartVpc)
+	}
+
+	// With security groups in their launch template, EKS no longer adds the
+	// cluster security group to the nodes
+	var nodeSecurityGroups *[]*string
+	if AppConfig.AttachVpcSecurityGroup {
+		vpcSecurityGroup := awscdk.Fn_ImportValue(jsii.String("VPCStack" + AppConfig1.Index + "-SecurityGroupId"))
+		nodeSecurityGroups = &[]*string{eksCluster.ClusterSecurityGroupId(), vpcSecurityGroup}
+
+		// The build project of the DevOps step runs in the VPC with this
+		// group, and reaches a private endpoint through it
+		if AppConfig.EndpointAccess == endpointPrivate || AppConfig.EndpointAccess == endpointPublicAndPrivate {
+			eksCluster.Connections().AllowFrom(awsec2.Peer_SecurityGroupId(vpcSecurityGroup, nil), awsec2.Port_Tcp(jsii.Number(443)), jsii.String("Kubernetes API from the VPC security group"))
+		}
+	}
+
+	nodegroups, err := addNodegroups(stack, eksCluster, roles.node, nodeSecurityGroups, AppConfig)
+	if err != nil {
+		panic("❌ Invalid nodegroup configuration: " + err.Error())
+	}
+
+	if err := addFargateProfiles(stack, eksCluster, AppConfig); err != nil {
+		panic("❌ Invalid Fargate configuration: " + err.Error())
+	}
+
+	// Cluster autoscaling
+	if err := addAutoscaler(stack, eksCluster, clusterName, PartVpc, nodegroups, AppConfig); err != nil {
+		panic("❌ Invalid autoscaler configuration: " + err.Error())
+	}
 
 	// Output the EKS cluster name.
 	awscdk.NewCfnOutput(stack, jsii.String("EksClusterName"), &awscdk.CfnOutputProps{
 		Value: eksCluster.ClusterName(),
 	})
 
+	// Output the OIDC provider ARN, imported by the addons stack for IRSA roles.
+	awscdk.NewCfnOutput(stack, jsii.String("EksOidcProviderArn"), &awscdk.CfnOutputProps{
+		Value:      eksCluster.OpenIdConnectProvider().OpenIdConnectProviderArn(),
+		ExportName: jsii.String(id + "-OidcProviderArn"),
+	})
+
 	return stack
 }
 
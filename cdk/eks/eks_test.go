@@ -1,26 +1,945 @@
 package main
 
-// import (
-// 	"testing"
-
-// 	"github.com/aws/aws-cdk-go/awscdk/v2"
-// 	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
-// 	"github.com/aws/jsii-runtime-go"
-// )
-
-// example tests. To run these tests, uncomment this file along with the
-// example resource in eks_test.go
-// func TestEksStack(t *testing.T) {
-// 	// GIVEN
-// 	app := awscdk.NewApp(nil)
-
-// 	// WHEN
-// 	stack := NewEksStack(app, "MyStack", nil)
-
-// 	// THEN
-// 	template := assertions.Template_FromStack(stack)
-
-// 	template.HasResourceProperties(jsii.String("AWS::SQS::Queue"), map[string]interface{}{
-// 		"VisibilityTimeout": 300,
-// 	})
-// }
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"CDK/pkg/fargateprofile"
+	"CDK/pkg/k8sversions"
+	"CDK/pkg/snapshot"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awslambda"
+	"github.com/aws/constructs-go/constructs/v10"
+	"github.com/aws/jsii-runtime-go"
+)
+
+func testConfig() (Configuration, ConfAuth) {
+	return Configuration{
+		ClusterName:  "SonarAWSTuto",
+		VPCid:        "vpc-0123456789abcdef0",
+		K8sVersion:   "1.28",
+		Workernode:   2,
+		EksAdminRole: "AdminRole",
+		// A new cluster, with its own service role
+		EksClusterRole: "ClusterRole",
+		Instance:       "C5",
+		InstanceSize:   "LARGE",
+		AdminPrincipalArns: []string{
+			"arn:aws:iam::123456789012:role/aws-reserved/sso.amazonaws.com/eu-central-1/AWSReservedSSO_AdministratorAccess_0123456789abcdef",
+		},
+	}, ConfAuth{
+		Region:  "eu-central-1",
+		Account: "123456789012",
+		Index:   "02",
+	}
+}
+
+// fixedKubectlLayer replaces the kubectl layer of version with an imported
+// one until the test ends, so that the snapshot doesn't change with the
+// layer asset.
+func fixedKubectlLayer(t *testing.T, version string) {
+	newLayer := kubectlLayers[version]
+	kubectlLayers[version] = func(scope constructs.Construct, id *string) awslambda.ILayerVersion {
+		return awslambda.LayerVersion_FromLayerVersionArn(scope, id, jsii.String("arn:aws:lambda:eu-central-1:123456789012:layer:kubectl:1"))
+	}
+	t.Cleanup(func() { kubectlLayers[version] = newLayer })
+}
+
+func TestEksStack(t *testing.T) {
+	// GIVEN
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := testConfig()
+	fixedKubectlLayer(t, "1.27")
+
+	// WHEN
+	stack := NewEksStack(app, "EksStack02", &EksStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN
+	template := assertions.Template_FromStack(stack, nil)
+
+	template.ResourceCountIs(jsii.String("Custom::AWSCDK-EKS-Cluster"), jsii.Number(1))
+	template.HasResourceProperties(jsii.String("Custom::AWSCDK-EKS-Cluster"), map[string]interface{}{
+		"Config": assertions.Match_ObjectLike(&map[string]interface{}{
+			"name":    "SonarAWSTuto02",
+			"version": "1.28",
+		}),
+	})
+
+	template.HasResourceProperties(jsii.String("AWS::EKS::Nodegroup"), map[string]interface{}{
+		"AmiType":       "AL2_x86_64",
+		"InstanceTypes": []interface{}{"c5.large"},
+		"ScalingConfig": map[string]interface{}{
+			"DesiredSize": 2,
+			"MaxSize":     2,
+			"MinSize":     2,
+		},
+	})
+
+	// The admin role is only trusted by the configured principals and has
+	// no AWS permissions beyond finding the cluster
+	template.HasResourceProperties(jsii.String("AWS::IAM::Role"), map[string]interface{}{
+		"RoleName": "SonarAWSTuto02AdminRole",
+		"AssumeRolePolicyDocument": map[string]interface{}{
+			"Statement": []interface{}{
+				map[string]interface{}{
+					"Action":    "sts:AssumeRole",
+					"Effect":    "Allow",
+					"Principal": map[string]interface{}{"AWS": AppConfig.AdminPrincipalArns[0]},
+				},
+			},
+			"Version": "2012-10-17",
+		},
+		"ManagedPolicyArns": assertions.Match_Absent(),
+	})
+
+	// The cluster role only has the cluster policies
+	template.HasResourceProperties(jsii.String("AWS::IAM::Role"), map[string]interface{}{
+		"RoleName": "SonarAWSTuto02ClusterRole",
+		"AssumeRolePolicyDocument": map[string]interface{}{
+			"Statement": []interface{}{
+				map[string]interface{}{
+					"Action":    "sts:AssumeRole",
+					"Effect":    "Allow",
+					"Principal": map[string]interface{}{"Service": "eks.amazonaws.com"},
+				},
+			},
+			"Version": "2012-10-17",
+		},
+		"ManagedPolicyArns": assertions.Match_ArrayWith(&[]interface{}{
+			assertions.Match_ObjectLike(&map[string]interface{}{"Fn::Join": assertions.Match_ArrayWith(&[]interface{}{
+				assertions.Match_ArrayWith(&[]interface{}{":iam::aws:policy/AmazonEKSClusterPolicy"}),
+			})}),
+		}),
+	})
+
+	// The nodes run with the node role
+	template.HasResourceProperties(jsii.String("AWS::EKS::Nodegroup"), map[string]interface{}{
+		"NodeRole": map[string]interface{}{
+			"Fn::GetAtt": assertions.Match_ArrayWith(&[]interface{}{assertions.Match_StringLikeRegexp(jsii.String("SonarAWSTuto02NodeRole"))}),
+		},
+	})
+
+	body, err := json.Marshal(template.FindResources(jsii.String("AWS::IAM::Role"), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, policy := range []string{"AmazonEC2FullAccess", "ElasticLoadBalancingFullAccess"} {
+		if strings.Contains(string(body), policy) {
+			t.Errorf("a role has %s", policy)
+		}
+	}
+
+	template.HasOutput(jsii.String("EksOidcProviderArn"), map[string]interface{}{
+		"Export": map[string]interface{}{"Name": "EksStack02-OidcProviderArn"},
+	})
+
+	snapshot.Match(t, "EksStack", template.ToJSON())
+}
+
+func TestEksStackSharedClusterRole(t *testing.T) {
+	// GIVEN
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := testConfig()
+	// The default, for the clusters created before the roles were split
+	AppConfig.EksClusterRole = ""
+	AppConfig.AdminPrincipalArns = nil
+	AppConfig.AdminPrincipalArn = "arn:aws:iam::123456789012:role/Operators"
+
+	// WHEN
+	stack := NewEksStack(app, "EksStack02", &EksStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN
+	template := assertions.Template_FromStack(stack, nil)
+
+	// The admin role of an existing cluster stays its service role, with the
+	// cluster policies only
+	template.ResourcePropertiesCountIs(jsii.String("AWS::IAM::Role"), map[string]interface{}{
+		"RoleName": "SonarAWSTuto02ClusterRole",
+	}, jsii.Number(0))
+	template.HasResourceProperties(jsii.String("AWS::IAM::Role"), map[string]interface{}{
+		"RoleName": "SonarAWSTuto02AdminRole",
+		"AssumeRolePolicyDocument": map[string]interface{}{
+			"Statement": []interface{}{
+				map[string]interface{}{
+					"Action":    "sts:AssumeRole",
+					"Effect":    "Allow",
+					"Principal": map[string]interface{}{"AWS": "arn:aws:iam::123456789012:role/Operators"},
+				},
+				map[string]interface{}{
+					"Action":    "sts:AssumeRole",
+					"Effect":    "Allow",
+					"Principal": map[string]interface{}{"Service": "eks.amazonaws.com"},
+				},
+			},
+			"Version": "2012-10-17",
+		},
+		"ManagedPolicyArns": assertions.Match_AnyValue(),
+	})
+	template.HasResourceProperties(jsii.String("Custom::AWSCDK-EKS-Cluster"), map[string]interface{}{
+		"Config": assertions.Match_ObjectLike(&map[string]interface{}{
+			"roleArn": map[string]interface{}{
+				"Fn::GetAtt": []interface{}{"SonarAWSTuto02AdminRole80709654", "Arn"},
+			},
+		}),
+	})
+}
+
+func TestEksStackDefaultAdminPrincipal(t *testing.T) {
+	// GIVEN
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.AdminPrincipalArns = nil
+
+	// WHEN
+	stack := NewEksStack(app, "EksStack02", &EksStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN the account trusts the admin role, as before the principals
+	template := assertions.Template_FromStack(stack, nil)
+	template.HasResourceProperties(jsii.String("AWS::IAM::Role"), map[string]interface{}{
+		"RoleName": "SonarAWSTuto02AdminRole",
+		"AssumeRolePolicyDocument": map[string]interface{}{
+			"Statement": []interface{}{
+				map[string]interface{}{
+					"Action": "sts:AssumeRole",
+					"Effect": "Allow",
+					"Principal": map[string]interface{}{"AWS": map[string]interface{}{
+						"Fn::Join": []interface{}{"", []interface{}{"arn:", map[string]interface{}{"Ref": "AWS::Partition"}, ":iam::123456789012:root"}},
+					}},
+				},
+			},
+			"Version": "2012-10-17",
+		},
+	})
+}
+
+func TestAdminPrincipalsInvalid(t *testing.T) {
+	AppConfig, _ := testConfig()
+	AppConfig.AdminPrincipalArns = []string{"Operators"}
+	if _, err := adminPrincipals(AppConfig); err == nil {
+		t.Errorf("no error for a name instead of an ARN")
+	}
+}
+
+func TestEksStackNodeLabelsAndTaints(t *testing.T) {
+	// GIVEN
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.NodeLabels = map[string]string{"role": "worker", "sonarsource.com/pool": "sonarqube"}
+	AppConfig.NodeTaints = []NodeTaint{{Key: "dedicated", Value: "sonarqube", Effect: "NoSchedule"}}
+
+	// WHEN
+	stack := NewEksStack(app, "EksStack02", &EksStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN
+	template := assertions.Template_FromStack(stack, nil)
+
+	template.ResourceCountIs(jsii.String("AWS::EKS::Nodegroup"), jsii.Number(1))
+	template.HasResourceProperties(jsii.String("AWS::EKS::Nodegroup"), map[string]interface{}{
+		"Labels": map[string]interface{}{"role": "worker", "sonarsource.com/pool": "sonarqube"},
+		"Taints": []interface{}{
+			map[string]interface{}{"Key": "dedicated", "Value": "sonarqube", "Effect": "NO_SCHEDULE"},
+		},
+	})
+}
+
+func TestNodeLabelsReservedPrefix(t *testing.T) {
+	for _, key := range []string{"node-role.kubernetes.io/worker", "kubernetes.io/role", "node.k8s.io/pool", "eks.amazonaws.com/nodegroup"} {
+		if _, err := nodeLabels(map[string]string{key: "worker"}); err == nil {
+			t.Errorf("%s: no error", key)
+		}
+	}
+	if _, err := nodeTaints([]NodeTaint{{Key: "dedicated", Effect: "NO_SCHEDULE"}}); err == nil {
+		t.Errorf("no error for an unsupported taint effect")
+	}
+}
+
+func TestEksStackNodegroups(t *testing.T) {
+	// GIVEN
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.Nodegroups = []Nodegroup{
+		{
+			Name:          "sonarqube",
+			InstanceTypes: []string{"m5.xlarge"},
+			AmiType:       "AL2023_x86_64_STANDARD",
+			MinSize:       2,
+			DesiredSize:   2,
+			MaxSize:       3,
+			DiskSize:      50,
+			Labels:        map[string]string{"workload": "sonarqube"},
+		},
+		{
+			Name:          "graviton",
+			InstanceTypes: []string{"m7g.large"},
+		},
+		{
+			Name:          "spot",
+			InstanceTypes: []string{"m7g.large", "m6g.large"},
+			CapacityType:  "SPOT",
+			AmiType:       "BOTTLEROCKET_ARM_64",
+			MaxSize:       4,
+			Taints:        []NodeTaint{{Key: "spot", Value: "true", Effect: "NoSchedule"}},
+			SubnetIds:     []string{"subnet-0123456789abcdef0"},
+		},
+	}
+
+	// WHEN
+	stack := NewEksStack(app, "EksStack02", &EksStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN
+	template := assertions.Template_FromStack(stack, nil)
+
+	template.ResourceCountIs(jsii.String("AWS::EKS::Nodegroup"), jsii.Number(3))
+	// The AMI type follows the architecture when not set, as the launch
+	// template stops CDK from inferring it
+	template.HasResourceProperties(jsii.String("AWS::EKS::Nodegroup"), map[string]interface{}{
+		"AmiType":       "AL2_ARM_64",
+		"InstanceTypes": []interface{}{"m7g.large"},
+	})
+	template.HasResourceProperties(jsii.String("AWS::EKS::Nodegroup"), map[string]interface{}{
+		"AmiType":       "AL2023_x86_64_STANDARD",
+		"InstanceTypes": []interface{}{"m5.xlarge"},
+		"DiskSize":      assertions.Match_Absent(),
+		"Labels":        map[string]interface{}{"workload": "sonarqube"},
+		"ScalingConfig": map[string]interface{}{"DesiredSize": 2, "MaxSize": 3, "MinSize": 2},
+		"CapacityType":  assertions.Match_Absent(),
+	})
+	template.HasResourceProperties(jsii.String("AWS::EKS::Nodegroup"), map[string]interface{}{
+		"AmiType":       "BOTTLEROCKET_ARM_64",
+		"CapacityType":  "SPOT",
+		"InstanceTypes": []interface{}{"m7g.large", "m6g.large"},
+		"ScalingConfig": map[string]interface{}{"DesiredSize": 2, "MaxSize": 4, "MinSize": 1},
+		"Subnets":       []interface{}{"subnet-0123456789abcdef0"},
+		"Taints": []interface{}{
+			map[string]interface{}{"Key": "spot", "Value": "true", "Effect": "NO_SCHEDULE"},
+		},
+	})
+
+	// The size goes to the root volume, or to the Bottlerocket data volume
+	template.ResourceCountIs(jsii.String("AWS::EC2::LaunchTemplate"), jsii.Number(3))
+	template.HasResourceProperties(jsii.String("AWS::EC2::LaunchTemplate"), map[string]interface{}{
+		"LaunchTemplateData": assertions.Match_ObjectLike(&map[string]interface{}{
+			"BlockDeviceMappings": []interface{}{
+				map[string]interface{}{
+					"DeviceName": "/dev/xvda",
+					"Ebs": map[string]interface{}{
+						"DeleteOnTermination": true,
+						"Encrypted":           true,
+						"VolumeSize":          50,
+						"VolumeType":          "gp3",
+					},
+				},
+			},
+		}),
+	})
+	template.HasResourceProperties(jsii.String("AWS::EC2::LaunchTemplate"), map[string]interface{}{
+		"LaunchTemplateData": assertions.Match_ObjectLike(&map[string]interface{}{
+			"BlockDeviceMappings": []interface{}{
+				assertions.Match_ObjectLike(&map[string]interface{}{"DeviceName": "/dev/xvda"}),
+				assertions.Match_ObjectLike(&map[string]interface{}{
+					"DeviceName": "/dev/xvdb",
+					"Ebs":        assertions.Match_ObjectLike(&map[string]interface{}{"VolumeSize": 20}),
+				}),
+			},
+		}),
+	})
+}
+
+func TestNodegroupOptionsInvalid(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("TestStack"), nil)
+
+	for _, ng := range []Nodegroup{
+		{InstanceTypes: []string{"c5.large"}},
+		{Name: "none"},
+		{Name: "capacity", InstanceTypes: []string{"c5.large"}, CapacityType: "RESERVED"},
+		{Name: "sizes", InstanceTypes: []string{"c5.large"}, MinSize: 3, MaxSize: 2},
+		{Name: "desired", InstanceTypes: []string{"c5.large"}, MinSize: 3, DesiredSize: 2},
+		{Name: "subnets", InstanceTypes: []string{"c5.large"}, SubnetType: "private", SubnetIds: []string{"subnet-1"}},
+		{Name: "labels", InstanceTypes: []string{"c5.large"}, Labels: map[string]string{"kubernetes.io/role": "worker"}},
+	} {
+		if _, err := nodegroupOptions(stack, ng); err == nil {
+			t.Errorf("%+v: no error", ng)
+		}
+	}
+}
+
+func TestNodegroupAmiType(t *testing.T) {
+	for _, test := range []struct {
+		ng      Nodegroup
+		amiType string
+	}{
+		{Nodegroup{InstanceTypes: []string{"c5.large"}}, "AL2_x86_64"},
+		{Nodegroup{InstanceTypes: []string{"m7g.large", "c6gn.xlarge"}}, "AL2_ARM_64"},
+		{Nodegroup{InstanceTypes: []string{"g5.xlarge"}}, "AL2_x86_64_GPU"},
+		{Nodegroup{InstanceTypes: []string{"m6g.large"}, AmiType: "AL2023_ARM_64_STANDARD"}, "AL2023_ARM_64_STANDARD"},
+	} {
+		amiType, err := nodegroupAmiType(test.ng)
+		if err != nil || amiType != test.amiType {
+			t.Errorf("%v: got %q, %v, want %q", test.ng.InstanceTypes, amiType, err, test.amiType)
+		}
+	}
+
+	for _, ng := range []Nodegroup{
+		{Name: "ami", InstanceTypes: []string{"c5.large"}, AmiType: "UBUNTU"},
+		{Name: "mixed", InstanceTypes: []string{"m5.large", "m6g.large"}},
+		{Name: "arm", InstanceTypes: []string{"m5.large"}, AmiType: "BOTTLEROCKET_ARM_64"},
+		{Name: "x86", InstanceTypes: []string{"m7g.large"}, AmiType: "AL2_x86_64"},
+	} {
+		if _, err := nodegroupAmiType(ng); err == nil {
+			t.Errorf("%+v: no error", ng)
+		}
+	}
+}
+
+func TestEksStackClusterAutoscaler(t *testing.T) {
+	// GIVEN
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.Autoscaler = "cluster-autoscaler"
+	AppConfig.WorkernodeMax = 5
+
+	// WHEN
+	stack := NewEksStack(app, "EksStack02", &EksStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN
+	template := assertions.Template_FromStack(stack, nil)
+
+	// The autoscaler scales between the nodegroup bounds and owns the
+	// desired size
+	template.HasResourceProperties(jsii.String("AWS::EKS::Nodegroup"), map[string]interface{}{
+		"ScalingConfig": map[string]interface{}{"MaxSize": 5, "MinSize": 2},
+	})
+
+	template.HasResourceProperties(jsii.String("Custom::AWSCDK-EKS-HelmChart"), map[string]interface{}{
+		"Chart":     "cluster-autoscaler",
+		"Namespace": "kube-system",
+		"Version":   "9.29.3",
+		"Values":    assertions.Match_AnyValue(),
+	})
+
+	template.HasResourceProperties(jsii.String("AWS::IAM::Policy"), map[string]interface{}{
+		"PolicyDocument": assertions.Match_ObjectLike(&map[string]interface{}{
+			"Statement": assertions.Match_ArrayWith(&[]interface{}{
+				map[string]interface{}{
+					"Action":   []interface{}{"autoscaling:SetDesiredCapacity", "autoscaling:TerminateInstanceInAutoScalingGroup"},
+					"Effect":   "Allow",
+					"Resource": "*",
+					"Condition": map[string]interface{}{
+						"StringEquals": map[string]interface{}{"aws:ResourceTag/k8s.io/cluster-autoscaler/SonarAWSTuto02": "owned"},
+					},
+				},
+			}),
+		}),
+	})
+}
+
+func TestEksStackKarpenter(t *testing.T) {
+	// GIVEN
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.Autoscaler = "karpenter"
+
+	// WHEN
+	stack := NewEksStack(app, "EksStack02", &EksStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN
+	template := assertions.Template_FromStack(stack, nil)
+
+	template.ResourceCountIs(jsii.String("AWS::SQS::Queue"), jsii.Number(1))
+	template.ResourceCountIs(jsii.String("AWS::Events::Rule"), jsii.Number(4))
+	template.HasResourceProperties(jsii.String("AWS::Events::Rule"), map[string]interface{}{
+		"EventPattern": map[string]interface{}{
+			"source":      []interface{}{"aws.ec2"},
+			"detail-type": []interface{}{"EC2 Spot Instance Interruption Warning"},
+		},
+	})
+
+	template.HasResourceProperties(jsii.String("Custom::AWSCDK-EKS-HelmChart"), map[string]interface{}{
+		"Chart":           "karpenter",
+		"Repository":      "oci://public.ecr.aws/karpenter/karpenter",
+		"Namespace":       "karpenter",
+		"CreateNamespace": true,
+		"Version":         "v0.32.1",
+	})
+
+	// The default node class and pool use the VPC private subnets
+	body, err := json.Marshal(template.FindResources(jsii.String("Custom::AWSCDK-EKS-KubernetesResource"), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`\"kind\":\"EC2NodeClass\"`, `\"kind\":\"NodePool\"`, `\"id\":\"p-12345\"`} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("no %s in the manifests", want)
+		}
+	}
+}
+
+func TestAddAutoscalerInvalid(t *testing.T) {
+	AppConfig, _ := testConfig()
+	AppConfig.Autoscaler = "keda"
+	if err := addAutoscaler(nil, nil, "SonarAWSTuto02", nil, nil, AppConfig); err == nil {
+		t.Errorf("no error for an unsupported autoscaler")
+	}
+}
+
+func TestEksStackPublicAccessCidrs(t *testing.T) {
+	// GIVEN
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.EndpointAccess = "public-and-private"
+	AppConfig.PublicAccessCidrs = []string{"203.0.113.0/24"}
+
+	// WHEN
+	stack := NewEksStack(app, "EksStack02", &EksStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN
+	template := assertions.Template_FromStack(stack, nil)
+	template.HasResourceProperties(jsii.String("Custom::AWSCDK-EKS-Cluster"), map[string]interface{}{
+		"Config": assertions.Match_ObjectLike(&map[string]interface{}{
+			"resourcesVpcConfig": assertions.Match_ObjectLike(&map[string]interface{}{
+				"endpointPublicAccess":  true,
+				"endpointPrivateAccess": true,
+				"publicAccessCidrs":     []interface{}{"203.0.113.0/24"},
+			}),
+		}),
+	})
+	template.ResourceCountIs(jsii.String("AWS::EC2::Instance"), jsii.Number(0))
+}
+
+func TestEksStackPrivateEndpoint(t *testing.T) {
+	// GIVEN
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.EndpointAccess = "private"
+
+	// WHEN
+	stack := NewEksStack(app, "EksStack02", &EksStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN
+	template := assertions.Template_FromStack(stack, nil)
+	template.HasResourceProperties(jsii.String("Custom::AWSCDK-EKS-Cluster"), map[string]interface{}{
+		"Config": assertions.Match_ObjectLike(&map[string]interface{}{
+			"resourcesVpcConfig": assertions.Match_ObjectLike(&map[string]interface{}{
+				"endpointPublicAccess":  false,
+				"endpointPrivateAccess": true,
+			}),
+		}),
+	})
+
+	// The access host can reach the API and is reachable with Session Manager
+	template.ResourceCountIs(jsii.String("AWS::EC2::Instance"), jsii.Number(1))
+	template.HasResourceProperties(jsii.String("AWS::EC2::SecurityGroupIngress"), map[string]interface{}{
+		"FromPort":   443,
+		"ToPort":     443,
+		"IpProtocol": "tcp",
+	})
+	template.HasOutput(jsii.String("EksApiTunnelCommand"), map[string]interface{}{})
+}
+
+func TestEndpointAccessInvalid(t *testing.T) {
+	for name, change := range map[string]func(*Configuration){
+		"unknown mode":     func(c *Configuration) { c.EndpointAccess = "internal" },
+		"bad cidr":         func(c *Configuration) { c.PublicAccessCidrs = []string{"203.0.113.0"} },
+		"private and cidr": func(c *Configuration) { c.EndpointAccess = "private"; c.PublicAccessCidrs = []string{"203.0.113.0/24"} },
+	} {
+		AppConfig, _ := testConfig()
+		change(&AppConfig)
+		if _, err := endpointAccess(AppConfig); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestClusterVersionsMatrix(t *testing.T) {
+	// Every supported version has a controller, and a kubectl layer at
+	// most one minor version away
+	minor := func(v string) int {
+		n, _ := strconv.Atoi(strings.TrimPrefix(v, "1."))
+		return n
+	}
+	for _, version := range k8sversions.Supported() {
+		release, _ := k8sversions.Lookup(version)
+		if d := minor(release.KubectlLayer) - minor(version); d < -1 || d > 1 {
+			t.Errorf("%s: kubectl layer %s", version, release.KubectlLayer)
+		}
+		if _, ok := albControllers[release.AlbController]; !ok {
+			t.Errorf("%s: no AWS Load Balancer Controller %s", version, release.AlbController)
+		}
+	}
+}
+
+func TestClusterVersionsKubectlLayerArn(t *testing.T) {
+	// 1.26 to 1.28 use the bundled layer, 1.24 needs KubectlLayerArn
+	AppConfig, _ := testConfig()
+	for _, version := range []string{"1.26", "1.27", "1.28"} {
+		AppConfig.K8sVersion = version
+		stack := awscdk.NewStack(awscdk.NewApp(nil), jsii.String("Stack"), nil)
+		if _, _, err := clusterVersions(stack, AppConfig); err != nil {
+			t.Errorf("%s: %v", version, err)
+		}
+	}
+	AppConfig.K8sVersion = "1.24"
+	stack := awscdk.NewStack(awscdk.NewApp(nil), jsii.String("Stack"), nil)
+	if _, _, err := clusterVersions(stack, AppConfig); err == nil || !strings.Contains(err.Error(), "KubectlLayerArn") {
+		t.Errorf("1.24 without KubectlLayerArn: %v", err)
+	}
+	AppConfig.KubectlLayerArn = "arn:aws:lambda:eu-central-1:123456789012:layer:kubectl124:1"
+	layer, _, err := clusterVersions(stack, AppConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if arn := *layer.LayerVersionArn(); arn != AppConfig.KubectlLayerArn {
+		t.Errorf("layer %s", arn)
+	}
+}
+
+func TestClusterVersionsUnsupported(t *testing.T) {
+	AppConfig, _ := testConfig()
+	AppConfig.K8sVersion = "1.30"
+	stack := awscdk.NewStack(awscdk.NewApp(nil), jsii.String("EksStack02"), nil)
+	_, _, err := clusterVersions(stack, AppConfig)
+	if err == nil || !strings.Contains(err.Error(), "1.28") {
+		t.Errorf("got %v, want the supported versions", err)
+	}
+}
+
+func TestEksStackLoggingAndEncryption(t *testing.T) {
+	// GIVEN
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.ClusterLogging = []string{"api", "audit", "authenticator"}
+	AppConfig.ClusterLogRetentionDays = 30
+	AppConfig.SecretsEncryption = true
+
+	// WHEN
+	stack := NewEksStack(app, "EksStack02", &EksStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN
+	template := assertions.Template_FromStack(stack, nil)
+
+	template.HasResourceProperties(jsii.String("Custom::AWSCDK-EKS-Cluster"), map[string]interface{}{
+		"Config": assertions.Match_ObjectLike(&map[string]interface{}{
+			"logging": map[string]interface{}{
+				"clusterLogging": []interface{}{
+					map[string]interface{}{"enabled": true, "types": []interface{}{"api", "audit", "authenticator"}},
+				},
+			},
+			"encryptionConfig": []interface{}{
+				map[string]interface{}{
+					"provider":  map[string]interface{}{"keyArn": map[string]interface{}{"Fn::GetAtt": []interface{}{"SecretsKey317DCF94", "Arn"}}},
+					"resources": []interface{}{"secrets"},
+				},
+			},
+		}),
+	})
+
+	template.HasResourceProperties(jsii.String("AWS::Logs::LogGroup"), map[string]interface{}{
+		"LogGroupName":    "/aws/eks/SonarAWSTuto02/cluster",
+		"RetentionInDays": 30,
+	})
+
+	// The key is rotated, and the cluster grants itself its use
+	template.HasResourceProperties(jsii.String("AWS::KMS::Key"), map[string]interface{}{
+		"EnableKeyRotation": true,
+	})
+	template.HasResourceProperties(jsii.String("AWS::IAM::Policy"), map[string]interface{}{
+		"PolicyDocument": assertions.Match_ObjectLike(&map[string]interface{}{
+			"Statement": assertions.Match_ArrayWith(&[]interface{}{
+				assertions.Match_ObjectLike(&map[string]interface{}{
+					"Action":   []interface{}{"kms:Encrypt", "kms:Decrypt", "kms:DescribeKey", "kms:CreateGrant"},
+					"Resource": map[string]interface{}{"Fn::GetAtt": []interface{}{"SecretsKey317DCF94", "Arn"}},
+				}),
+			}),
+		}),
+	})
+}
+
+func TestEksStackSecretsKmsKeyArn(t *testing.T) {
+	// GIVEN
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.SecretsKmsKeyArn = "arn:aws:kms:eu-central-1:123456789012:key/0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d"
+
+	// WHEN
+	stack := NewEksStack(app, "EksStack02", &EksStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN
+	template := assertions.Template_FromStack(stack, nil)
+	template.ResourceCountIs(jsii.String("AWS::KMS::Key"), jsii.Number(0))
+	template.HasResourceProperties(jsii.String("Custom::AWSCDK-EKS-Cluster"), map[string]interface{}{
+		"Config": assertions.Match_ObjectLike(&map[string]interface{}{
+			"encryptionConfig": []interface{}{
+				map[string]interface{}{
+					"provider":  map[string]interface{}{"keyArn": AppConfig.SecretsKmsKeyArn},
+					"resources": []interface{}{"secrets"},
+				},
+			},
+		}),
+	})
+}
+
+func TestLoggingAndEncryptionInvalid(t *testing.T) {
+	AppConfig, _ := testConfig()
+	AppConfig.ClusterLogging = []string{"kubelet"}
+	if _, err := clusterLogging(AppConfig); err == nil {
+		t.Errorf("no error for an unsupported log type")
+	}
+
+	AppConfig.ClusterLogging = []string{"audit"}
+	AppConfig.ClusterLogRetentionDays = 10
+	if _, err := addClusterLogGroup(nil, "SonarAWSTuto02", AppConfig); err == nil {
+		t.Errorf("no error for an unsupported retention")
+	}
+
+	AppConfig.SecretsKmsKeyArn = "alias/eks"
+	if _, err := secretsKey(nil, "SonarAWSTuto02", AppConfig); err == nil {
+		t.Errorf("no error for a key alias")
+	}
+}
+
+func TestEksStackFargateProfiles(t *testing.T) {
+	// GIVEN
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.FargateProfiles = []fargateprofile.Profile{
+		{Name: "sample-app", Selectors: []fargateprofile.Selector{{Namespace: "sonar-aws-javaapp-ns"}}},
+		{
+			Name:                "builds",
+			Selectors:           []fargateprofile.Selector{{Namespace: "builds", Labels: map[string]string{"runner": "fargate"}}},
+			SubnetIds:           []string{"subnet-0123456789abcdef0"},
+			PodExecutionRoleArn: "arn:aws:iam::123456789012:role/BuildsPodExecution",
+		},
+	}
+
+	// WHEN
+	stack := NewEksStack(app, "EksStack02", &EksStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN
+	template := assertions.Template_FromStack(stack, nil)
+
+	template.ResourceCountIs(jsii.String("Custom::AWSCDK-EKS-FargateProfile"), jsii.Number(2))
+	template.HasResourceProperties(jsii.String("Custom::AWSCDK-EKS-FargateProfile"), map[string]interface{}{
+		"Config": assertions.Match_ObjectLike(&map[string]interface{}{
+			"fargateProfileName":  "sample-app",
+			"selectors":           []interface{}{map[string]interface{}{"namespace": "sonar-aws-javaapp-ns"}},
+			"subnets":             []interface{}{"p-12345", "p-67890"},
+			"podExecutionRoleArn": assertions.Match_ObjectLike(&map[string]interface{}{"Fn::GetAtt": assertions.Match_AnyValue()}),
+		}),
+	})
+	template.HasResourceProperties(jsii.String("Custom::AWSCDK-EKS-FargateProfile"), map[string]interface{}{
+		"Config": assertions.Match_ObjectLike(&map[string]interface{}{
+			"fargateProfileName":  "builds",
+			"selectors":           []interface{}{map[string]interface{}{"namespace": "builds", "labels": map[string]interface{}{"runner": "fargate"}}},
+			"subnets":             []interface{}{"subnet-0123456789abcdef0"},
+			"podExecutionRoleArn": "arn:aws:iam::123456789012:role/BuildsPodExecution",
+		}),
+	})
+}
+
+func TestFargateProfileOptionsInvalid(t *testing.T) {
+	for name, profile := range map[string]fargateprofile.Profile{
+		"no name":      {Selectors: []fargateprofile.Selector{{Namespace: "apps"}}},
+		"no selector":  {Name: "apps"},
+		"no namespace": {Name: "apps", Selectors: []fargateprofile.Selector{{Labels: map[string]string{"app": "x"}}}},
+	} {
+		if _, err := fargateProfileOptions(nil, profile); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestEksStackLaunchTemplate(t *testing.T) {
+	// GIVEN
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.NodeImdsHopLimit = 1
+	AppConfig.NodeVolumeType = "gp2"
+	AppConfig.NodeVolumeKmsKeyArn = "arn:aws:kms:eu-central-1:123456789012:key/0123abcd-4567-89ef-0123-456789abcdef"
+	settings := "[settings.kubernetes]\nmax-pods = 58\n"
+	AppConfig.Nodegroups = []Nodegroup{
+		{
+			Name:                 "bottlerocket",
+			InstanceTypes:        []string{"m5.large"},
+			AmiType:              "BOTTLEROCKET_x86_64",
+			DiskSize:             40,
+			VolumeType:           "gp3",
+			BottlerocketSettings: settings,
+		},
+	}
+
+	// WHEN
+	stack := NewEksStack(app, "EksStack02", &EksStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN
+	template := assertions.Template_FromStack(stack, nil)
+
+	template.HasResourceProperties(jsii.String("AWS::EC2::LaunchTemplate"), map[string]interface{}{
+		"LaunchTemplateData": map[string]interface{}{
+			"MetadataOptions": map[string]interface{}{
+				"HttpEndpoint":            "enabled",
+				"HttpPutResponseHopLimit": 1,
+				"HttpTokens":              "required",
+			},
+			"BlockDeviceMappings": []interface{}{
+				map[string]interface{}{
+					"DeviceName": "/dev/xvda",
+					"Ebs": map[string]interface{}{
+						"DeleteOnTermination": true,
+						"Encrypted":           true,
+						"KmsKeyId":            AppConfig.NodeVolumeKmsKeyArn,
+						"VolumeType":          "gp3",
+					},
+				},
+				map[string]interface{}{
+					"DeviceName": "/dev/xvdb",
+					"Ebs": map[string]interface{}{
+						"DeleteOnTermination": true,
+						"Encrypted":           true,
+						"KmsKeyId":            AppConfig.NodeVolumeKmsKeyArn,
+						"VolumeSize":          40,
+						"VolumeType":          "gp3",
+					},
+				},
+			},
+			"UserData": map[string]interface{}{"Fn::Base64": settings},
+		},
+	})
+	template.HasResourceProperties(jsii.String("AWS::EKS::Nodegroup"), map[string]interface{}{
+		"AmiType": "BOTTLEROCKET_x86_64",
+		"LaunchTemplate": map[string]interface{}{
+			"Id":      map[string]interface{}{"Ref": assertions.Match_StringLikeRegexp(jsii.String("^bottlerocketLaunchTemplate"))},
+			"Version": assertions.Match_ObjectLike(&map[string]interface{}{"Fn::GetAtt": assertions.Match_AnyValue()}),
+		},
+		"DiskSize": assertions.Match_Absent(),
+	})
+}
+
+func TestEksStackVpcSecurityGroup(t *testing.T) {
+	// GIVEN
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.AttachVpcSecurityGroup = true
+
+	// WHEN
+	stack := NewEksStack(app, "EksStack02", &EksStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN
+	template := assertions.Template_FromStack(stack, nil)
+	template.HasResourceProperties(jsii.String("AWS::EC2::LaunchTemplate"), map[string]interface{}{
+		"LaunchTemplateData": assertions.Match_ObjectLike(&map[string]interface{}{
+			"SecurityGroupIds": []interface{}{
+				map[string]interface{}{"Fn::GetAtt": assertions.Match_ArrayWith(&[]interface{}{"ClusterSecurityGroupId"})},
+				map[string]interface{}{"Fn::ImportValue": "VPCStack02-SecurityGroupId"},
+			},
+		}),
+	})
+}
+
+func TestEksStackPrivateEndpointVpcSecurityGroup(t *testing.T) {
+	// GIVEN
+	app := awscdk.NewApp(nil)
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.EndpointAccess = "private"
+	AppConfig.AttachVpcSecurityGroup = true
+
+	// WHEN
+	stack := NewEksStack(app, "EksStack02", &EksStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+
+	// THEN
+	// The DevOps build project, in the VPC with its security group, can
+	// reach the API
+	template := assertions.Template_FromStack(stack, nil)
+	template.HasResourceProperties(jsii.String("AWS::EC2::SecurityGroupIngress"), map[string]interface{}{
+		"FromPort":              443,
+		"ToPort":                443,
+		"IpProtocol":            "tcp",
+		"SourceSecurityGroupId": map[string]interface{}{"Fn::ImportValue": "VPCStack02-SecurityGroupId"},
+	})
+}
+
+func TestNodeLaunchTemplateInvalid(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("TestStack"), nil)
+	AppConfig, _ := testConfig()
+	ng := Nodegroup{Name: "workers", InstanceTypes: []string{"c5.large"}}
+
+	for key, config := range map[string]func(*Configuration, *Nodegroup){
+		"hop limit":    func(c *Configuration, _ *Nodegroup) { c.NodeImdsHopLimit = 3 },
+		"volume type":  func(_ *Configuration, n *Nodegroup) { n.VolumeType = "io2" },
+		"default type": func(c *Configuration, _ *Nodegroup) { c.NodeVolumeType = "st1" },
+		"kms key":      func(c *Configuration, _ *Nodegroup) { c.NodeVolumeKmsKeyArn = "alias/nodes" },
+		"settings":     func(_ *Configuration, n *Nodegroup) { n.BottlerocketSettings = "[settings]" },
+	} {
+		c, n := AppConfig, ng
+		config(&c, &n)
+		n.Name = strings.ReplaceAll(key, " ", "")
+		if _, err := nodeLaunchTemplate(stack, n, nil, c); err == nil {
+			t.Errorf("%s: no error", key)
+		}
+	}
+}
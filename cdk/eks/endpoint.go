@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseks"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// Modes of the EndpointAccess setting.
+const (
+	endpointPublic           = "public"
+	endpointPublicAndPrivate = "public-and-private"
+	endpointPrivate          = "private"
+)
+
+// apiTunnelPort is the local port of the port-forward to the private
+// endpoint, as set in EKS_API_TUNNEL.
+const apiTunnelPort = "8443"
+
+// endpointAccess returns the API endpoint access of EndpointAccess, public
+// when empty. PublicAccessCidrs restricts the public endpoint.
+func endpointAccess(AppConfig Configuration) (awseks.EndpointAccess, error) {
+	for _, cidr := range AppConfig.PublicAccessCidrs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return nil, fmt.Errorf("PublicAccessCidrs: %w", err)
+		}
+	}
+
+	var access awseks.EndpointAccess
+	switch AppConfig.EndpointAccess {
+	case "", endpointPublic:
+		access = awseks.EndpointAccess_PUBLIC()
+	case endpointPublicAndPrivate:
+		access = awseks.EndpointAccess_PUBLIC_AND_PRIVATE()
+	case endpointPrivate:
+		if len(AppConfig.PublicAccessCidrs) > 0 {
+			return nil, fmt.Errorf("PublicAccessCidrs needs a public endpoint, not %s", endpointPrivate)
+		}
+		return awseks.EndpointAccess_PRIVATE(), nil
+	default:
+		return nil, fmt.Errorf("unsupported EndpointAccess %q, use %s, %s or %s", AppConfig.EndpointAccess, endpointPublic, endpointPublicAndPrivate, endpointPrivate)
+	}
+
+	if len(AppConfig.PublicAccessCidrs) > 0 {
+		access = access.OnlyFrom(*jsii.Strings(AppConfig.PublicAccessCidrs...)...)
+	}
+	return access, nil
+}
+
+// addApiAccessHost creates, for a private endpoint, an instance in the
+// private subnets reachable with Session Manager. The addons stage and
+// cmd/gitdep of devops reach the API through a port-forward to it, see the
+// EksApiTunnelCommand output. The resources applied by CDK need no such
+// path: the kubectl handler runs in the VPC when the endpoint is private.
+func addApiAccessHost(stack awscdk.Stack, cluster awseks.Cluster, vpc awsec2.IVpc) {
+	host := awsec2.NewInstance(stack, jsii.String("ApiAccessHost"), &awsec2.InstanceProps{
+		Vpc: vpc,
+		VpcSubnets: &awsec2.SubnetSelection{
+			SubnetType: awsec2.SubnetType_PRIVATE_WITH_EGRESS,
+		},
+		InstanceType:          awsec2.InstanceType_Of(awsec2.InstanceClass_T3, awsec2.InstanceSize_MICRO),
+		MachineImage:          awsec2.MachineImage_LatestAmazonLinux2023(nil),
+		SsmSessionPermissions: jsii.Bool(true),
+		RequireImdsv2:         jsii.Bool(true),
+	})
+	cluster.Connections().AllowFrom(host, awsec2.Port_Tcp(jsii.Number(443)), jsii.String("Kubernetes API from the access host"))
+
+	// The endpoint URL without its https:// scheme
+	endpointHost := awscdk.Fn_Select(jsii.Number(1), awscdk.Fn_Split(jsii.String("//"), cluster.ClusterEndpoint(), nil))
+
+	awscdk.NewCfnOutput(stack, jsii.String("EksApiAccessHostId"), &awscdk.CfnOutputProps{
+		Value: host.InstanceId(),
+	})
+	awscdk.NewCfnOutput(stack, jsii.String("EksApiTunnelCommand"), &awscdk.CfnOutputProps{
+		Value: awscdk.Fn_Join(jsii.String(""), &[]*string{
+			jsii.String("aws ssm start-session --target "), host.InstanceId(),
+			jsii.String(" --document-name AWS-StartPortForwardingSessionToRemoteHost --parameters host="), endpointHost,
+			jsii.String(",portNumber=443,localPortNumber=" + apiTunnelPort),
+		}),
+	})
+}
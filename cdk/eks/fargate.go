@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+
+	"CDK/pkg/fargateprofile"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseks"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/constructs-go/constructs/v10"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// EKS limits of a Fargate profile.
+const (
+	maxFargateSelectors = 5
+	maxFargateLabels    = 5
+)
+
+// fargateProfileOptions checks profile and converts it to CDK options.
+func fargateProfileOptions(scope constructs.Construct, profile fargateprofile.Profile) (*awseks.FargateProfileOptions, error) {
+	if profile.Name == "" {
+		return nil, fmt.Errorf("Fargate profile without a Name")
+	}
+	if len(profile.Selectors) == 0 || len(profile.Selectors) > maxFargateSelectors {
+		return nil, fmt.Errorf("Fargate profile %s: set 1 to %d Selectors", profile.Name, maxFargateSelectors)
+	}
+
+	var selectors []*awseks.Selector
+	for _, selector := range profile.Selectors {
+		if selector.Namespace == "" {
+			return nil, fmt.Errorf("Fargate profile %s: selector without a Namespace", profile.Name)
+		}
+		if len(selector.Labels) > maxFargateLabels {
+			return nil, fmt.Errorf("Fargate profile %s: at most %d Labels per selector", profile.Name, maxFargateLabels)
+		}
+		var labels *map[string]*string
+		if len(selector.Labels) > 0 {
+			labels = &map[string]*string{}
+			for key, value := range selector.Labels {
+				(*labels)[key] = jsii.String(value)
+			}
+		}
+		selectors = append(selectors, &awseks.Selector{
+			Namespace: jsii.String(selector.Namespace),
+			Labels:    labels,
+		})
+	}
+
+	options := &awseks.FargateProfileOptions{
+		FargateProfileName: jsii.String(profile.Name),
+		Selectors:          &selectors,
+	}
+	if len(profile.SubnetIds) > 0 {
+		var subnets []awsec2.ISubnet
+		for _, id := range profile.SubnetIds {
+			subnets = append(subnets, awsec2.Subnet_FromSubnetId(scope, jsii.String(profile.Name+"-"+id), jsii.String(id)))
+		}
+		options.SubnetSelection = &awsec2.SubnetSelection{Subnets: &subnets}
+	}
+	if profile.PodExecutionRoleArn != "" {
+		options.PodExecutionRole = awsiam.Role_FromRoleArn(scope, jsii.String(profile.Name+"PodExecutionRole"), &profile.PodExecutionRoleArn, &awsiam.FromRoleArnOptions{
+			Mutable: jsii.Bool(false),
+		})
+	}
+	return options, nil
+}
+
+// addFargateProfiles adds the FargateProfiles of config.json to the
+// cluster. CDK creates them one after the other, as EKS requires.
+func addFargateProfiles(scope constructs.Construct, cluster awseks.Cluster, AppConfig Configuration) error {
+	seen := make(map[string]bool)
+	for _, profile := range AppConfig.FargateProfiles {
+		if seen[profile.Name] {
+			return fmt.Errorf("Fargate profile %s is listed twice", profile.Name)
+		}
+		seen[profile.Name] = true
+
+		options, err := fargateProfileOptions(scope, profile)
+		if err != nil {
+			return err
+		}
+		// CDK only sets the subnets, the private ones by default, with a VPC
+		options.Vpc = cluster.Vpc()
+		cluster.AddFargateProfile(jsii.String("Fargate-"+profile.Name), options)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseks"
+	"github.com/aws/constructs-go/constructs/v10"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// Node launch template defaults.
+const (
+	// defaultDiskSize is the EKS default root volume size, in GiB
+	defaultDiskSize = 20
+	// defaultImdsHopLimit lets the pods reach the instance metadata; 1
+	// keeps it to the host network
+	defaultImdsHopLimit = 2
+	defaultVolumeType   = "gp3"
+)
+
+// nodeVolumeTypes are the EBS volume types of the node volumes.
+var nodeVolumeTypes = map[string]bool{"gp3": true, "gp2": true}
+
+func isBottlerocket(amiType string) bool {
+	return strings.HasPrefix(amiType, "BOTTLEROCKET_")
+}
+
+// nodeLaunchTemplate creates the launch template of the nodegroup ng: IMDSv2
+// only, encrypted volumes of DiskSize GiB and VolumeType, and for
+// Bottlerocket the TOML settings of BottlerocketSettings as user data. A
+// Bottlerocket node has an OS volume and a data volume for the containers,
+// the size applies to the latter. securityGroups, when not nil, replace the
+// security groups EKS gives the nodes.
+func nodeLaunchTemplate(scope constructs.Construct, ng Nodegroup, securityGroups *[]*string, AppConfig Configuration) (awsec2.CfnLaunchTemplate, error) {
+	hopLimit := AppConfig.NodeImdsHopLimit
+	if hopLimit == 0 {
+		hopLimit = defaultImdsHopLimit
+	}
+	if hopLimit != 1 && hopLimit != 2 {
+		return nil, fmt.Errorf("NodeImdsHopLimit must be 1 or 2, not %v", hopLimit)
+	}
+
+	volumeType := ng.VolumeType
+	if volumeType == "" {
+		volumeType = AppConfig.NodeVolumeType
+	}
+	if volumeType == "" {
+		volumeType = defaultVolumeType
+	}
+	if !nodeVolumeTypes[volumeType] {
+		return nil, fmt.Errorf("nodegroup %s: unsupported VolumeType %q, use gp3 or gp2", ng.Name, volumeType)
+	}
+
+	var kmsKeyID *string
+	if AppConfig.NodeVolumeKmsKeyArn != "" {
+		if !strings.HasPrefix(AppConfig.NodeVolumeKmsKeyArn, "arn:") || !strings.Contains(AppConfig.NodeVolumeKmsKeyArn, ":key/") {
+			return nil, fmt.Errorf("NodeVolumeKmsKeyArn %q is not the ARN of a KMS key", AppConfig.NodeVolumeKmsKeyArn)
+		}
+		kmsKeyID = jsii.String(AppConfig.NodeVolumeKmsKeyArn)
+	}
+
+	diskSize := ng.DiskSize
+	if diskSize == 0 {
+		diskSize = defaultDiskSize
+	}
+	volume := func(device string, size *float64) *awsec2.CfnLaunchTemplate_BlockDeviceMappingProperty {
+		return &awsec2.CfnLaunchTemplate_BlockDeviceMappingProperty{
+			DeviceName: jsii.String(device),
+			Ebs: &awsec2.CfnLaunchTemplate_EbsProperty{
+				Encrypted:           jsii.Bool(true),
+				KmsKeyId:            kmsKeyID,
+				VolumeSize:          size,
+				VolumeType:          jsii.String(volumeType),
+				DeleteOnTermination: jsii.Bool(true),
+			},
+		}
+	}
+
+	data := &awsec2.CfnLaunchTemplate_LaunchTemplateDataProperty{
+		MetadataOptions: &awsec2.CfnLaunchTemplate_MetadataOptionsProperty{
+			HttpEndpoint:            jsii.String("enabled"),
+			HttpTokens:              jsii.String("required"),
+			HttpPutResponseHopLimit: jsii.Number(hopLimit),
+		},
+		SecurityGroupIds: securityGroups,
+	}
+	if isBottlerocket(ng.AmiType) {
+		// The OS volume keeps the size of the AMI snapshot
+		data.BlockDeviceMappings = &[]interface{}{volume("/dev/xvda", nil), volume("/dev/xvdb", jsii.Number(diskSize))}
+		if ng.BottlerocketSettings != "" {
+			data.UserData = awscdk.Fn_Base64(jsii.String(ng.BottlerocketSettings))
+		}
+	} else {
+		if ng.BottlerocketSettings != "" {
+			return nil, fmt.Errorf("nodegroup %s: BottlerocketSettings needs a BOTTLEROCKET AmiType", ng.Name)
+		}
+		data.BlockDeviceMappings = &[]interface{}{volume("/dev/xvda", jsii.Number(diskSize))}
+	}
+
+	return awsec2.NewCfnLaunchTemplate(scope, jsii.String(ng.Name+"LaunchTemplate"), &awsec2.CfnLaunchTemplateProps{
+		LaunchTemplateData: data,
+	}), nil
+}
+
+// launchTemplateSpec points a nodegroup at the latest version of template.
+func launchTemplateSpec(template awsec2.CfnLaunchTemplate) *awseks.LaunchTemplateSpec {
+	return &awseks.LaunchTemplateSpec{
+		Id:      template.Ref(),
+		Version: template.AttrLatestVersionNumber(),
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseks"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awskms"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awslogs"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// defaultLogRetentionDays is the retention of the control plane logs when
+// ClusterLogRetentionDays is 0.
+const defaultLogRetentionDays = 90
+
+// logTypes are the control plane log types of the ClusterLogging setting.
+var logTypes = map[string]awseks.ClusterLoggingTypes{
+	"api":               awseks.ClusterLoggingTypes_API,
+	"audit":             awseks.ClusterLoggingTypes_AUDIT,
+	"authenticator":     awseks.ClusterLoggingTypes_AUTHENTICATOR,
+	"controllerManager": awseks.ClusterLoggingTypes_CONTROLLER_MANAGER,
+	"scheduler":         awseks.ClusterLoggingTypes_SCHEDULER,
+}
+
+// retentionDays are the retentions CloudWatch Logs accepts, in days.
+var retentionDays = map[float64]awslogs.RetentionDays{
+	1:    awslogs.RetentionDays_ONE_DAY,
+	3:    awslogs.RetentionDays_THREE_DAYS,
+	5:    awslogs.RetentionDays_FIVE_DAYS,
+	7:    awslogs.RetentionDays_ONE_WEEK,
+	14:   awslogs.RetentionDays_TWO_WEEKS,
+	30:   awslogs.RetentionDays_ONE_MONTH,
+	60:   awslogs.RetentionDays_TWO_MONTHS,
+	90:   awslogs.RetentionDays_THREE_MONTHS,
+	120:  awslogs.RetentionDays_FOUR_MONTHS,
+	150:  awslogs.RetentionDays_FIVE_MONTHS,
+	180:  awslogs.RetentionDays_SIX_MONTHS,
+	365:  awslogs.RetentionDays_ONE_YEAR,
+	400:  awslogs.RetentionDays_THIRTEEN_MONTHS,
+	545:  awslogs.RetentionDays_EIGHTEEN_MONTHS,
+	731:  awslogs.RetentionDays_TWO_YEARS,
+	1096: awslogs.RetentionDays_THREE_YEARS,
+	1827: awslogs.RetentionDays_FIVE_YEARS,
+	2192: awslogs.RetentionDays_SIX_YEARS,
+	2557: awslogs.RetentionDays_SEVEN_YEARS,
+	2922: awslogs.RetentionDays_EIGHT_YEARS,
+	3288: awslogs.RetentionDays_NINE_YEARS,
+	3653: awslogs.RetentionDays_TEN_YEARS,
+}
+
+// clusterLogging returns the control plane log types of ClusterLogging, nil
+// when logging is off.
+func clusterLogging(AppConfig Configuration) (*[]awseks.ClusterLoggingTypes, error) {
+	if len(AppConfig.ClusterLogging) == 0 {
+		return nil, nil
+	}
+	seen := make(map[string]bool)
+	var types []awseks.ClusterLoggingTypes
+	for _, name := range AppConfig.ClusterLogging {
+		logType, ok := logTypes[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported ClusterLogging type %q, use api, audit, authenticator, controllerManager or scheduler", name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			types = append(types, logType)
+		}
+	}
+	return &types, nil
+}
+
+// addClusterLogGroup creates the log group EKS writes the control plane
+// logs to, so that it has a retention and goes with the stack. It returns
+// nil when logging is off. The group must not exist yet, e.g. left by an
+// earlier cluster of the same name: its creation fails then.
+func addClusterLogGroup(stack awscdk.Stack, clusterName string, AppConfig Configuration) (awslogs.LogGroup, error) {
+	if len(AppConfig.ClusterLogging) == 0 {
+		return nil, nil
+	}
+	days := AppConfig.ClusterLogRetentionDays
+	if days == 0 {
+		days = defaultLogRetentionDays
+	}
+	retention, ok := retentionDays[days]
+	if !ok {
+		return nil, fmt.Errorf("unsupported ClusterLogRetentionDays %v, use one of %s", days, supportedRetentions())
+	}
+
+	// EKS writes to this name, it can't be changed
+	return awslogs.NewLogGroup(stack, jsii.String("ClusterLogGroup"), &awslogs.LogGroupProps{
+		LogGroupName:  jsii.String("/aws/eks/" + clusterName + "/cluster"),
+		Retention:     retention,
+		RemovalPolicy: awscdk.RemovalPolicy_DESTROY,
+	}), nil
+}
+
+func supportedRetentions() string {
+	var days []string
+	for _, day := range []float64{1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180, 365, 400, 545, 731, 1096, 1827, 2192, 2557, 2922, 3288, 3653} {
+		days = append(days, fmt.Sprint(day))
+	}
+	return strings.Join(days, ", ")
+}
+
+// secretsKey returns the KMS key encrypting the Kubernetes Secrets: the
+// key of SecretsKmsKeyArn, or a new one when SecretsEncryption is set, nil
+// otherwise. The cluster grants its role the use of the key; an existing
+// key must allow it in its own policy.
+func secretsKey(stack awscdk.Stack, clusterName string, AppConfig Configuration) (awskms.IKey, error) {
+	if AppConfig.SecretsKmsKeyArn != "" {
+		if !strings.HasPrefix(AppConfig.SecretsKmsKeyArn, "arn:") || !strings.Contains(AppConfig.SecretsKmsKeyArn, ":key/") {
+			return nil, fmt.Errorf("SecretsKmsKeyArn %q is not the ARN of a KMS key", AppConfig.SecretsKmsKeyArn)
+		}
+		return awskms.Key_FromKeyArn(stack, jsii.String("SecretsKey"), &AppConfig.SecretsKmsKeyArn), nil
+	}
+	if !AppConfig.SecretsEncryption {
+		return nil, nil
+	}
+
+	return awskms.NewKey(stack, jsii.String("SecretsKey"), &awskms.KeyProps{
+		Description:       jsii.String("Encryption of the Kubernetes Secrets of " + clusterName),
+		EnableKeyRotation: jsii.Bool(true),
+		PendingWindow:     awscdk.Duration_Days(jsii.Number(7)),
+		RemovalPolicy:     awscdk.RemovalPolicy_DESTROY,
+	}), nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseks"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/constructs-go/constructs/v10"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// NodeTaint is a Kubernetes taint set on the nodes of a nodegroup.
+type NodeTaint struct {
+	Key   string
+	Value string
+	// NoSchedule, PreferNoSchedule or NoExecute
+	Effect string
+}
+
+var taintEffects = map[string]awseks.TaintEffect{
+	"NoSchedule":       awseks.TaintEffect_NO_SCHEDULE,
+	"PreferNoSchedule": awseks.TaintEffect_PREFER_NO_SCHEDULE,
+	"NoExecute":        awseks.TaintEffect_NO_EXECUTE,
+}
+
+// reservedLabelPrefixes can't be set by the kubelet of a nodegroup, the
+// NodeRestriction admission plugin rejects them.
+var reservedLabelPrefixes = []string{"kubernetes.io/", "k8s.io/", "eks.amazonaws.com/"}
+
+// nodeLabels checks the labels a nodegroup sets on its nodes.
+func nodeLabels(labels map[string]string) (*map[string]*string, error) {
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	result := make(map[string]*string, len(labels))
+	for key, value := range labels {
+		for _, prefix := range reservedLabelPrefixes {
+			if strings.HasPrefix(key, prefix) || strings.Contains(key, "."+prefix) {
+				return nil, fmt.Errorf("node label %s uses the reserved %s prefix", key, prefix)
+			}
+		}
+		result[key] = jsii.String(value)
+	}
+	return &result, nil
+}
+
+// nodeTaints converts the taints of config.json, sorted by key.
+func nodeTaints(taints []NodeTaint) (*[]*awseks.TaintSpec, error) {
+	if len(taints) == 0 {
+		return nil, nil
+	}
+	var result []*awseks.TaintSpec
+	for _, taint := range taints {
+		effect, ok := taintEffects[taint.Effect]
+		if !ok {
+			return nil, fmt.Errorf("node taint %s: unsupported effect %q", taint.Key, taint.Effect)
+		}
+		if taint.Key == "" {
+			return nil, fmt.Errorf("node taint without a Key")
+		}
+		result = append(result, &awseks.TaintSpec{
+			Key:    jsii.String(taint.Key),
+			Value:  jsii.String(taint.Value),
+			Effect: effect,
+		})
+	}
+	sort.SliceStable(result, func(i, j int) bool { return *result[i].Key < *result[j].Key })
+	return &result, nil
+}
+
+// Nodegroup describes a managed nodegroup of the cluster.
+type Nodegroup struct {
+	// Name of the nodegroup in the stack, e.g. sonarqube or spot
+	Name string
+	// EC2 instance types, e.g. c5.large or m7g.large
+	InstanceTypes []string
+	// ON_DEMAND (default) or SPOT
+	CapacityType string
+	// EKS AMI type, e.g. AL2_x86_64, AL2023_ARM_64_STANDARD or
+	// BOTTLEROCKET_x86_64. When empty, the Amazon Linux 2 AMI of the
+	// architecture of the instance types, with the GPU drivers for GPU ones
+	AmiType string
+	// Number of nodes. When 0, CDK defaults to 1 minimum, 2 desired and a
+	// maximum of the desired size
+	MinSize     float64
+	DesiredSize float64
+	MaxSize     float64
+	// Size in GiB of the root volume, or of the data volume of
+	// Bottlerocket, 20 when 0, and its type: gp3 (default) or gp2
+	DiskSize   float64
+	VolumeType string
+	// Bottlerocket settings, in TOML, for a BOTTLEROCKET AmiType
+	BottlerocketSettings string
+	Labels               map[string]string
+	Taints               []NodeTaint
+	// private (default) or public subnets of the VPC, or the given subnets
+	SubnetType string
+	SubnetIds  []string
+}
+
+var capacityTypes = map[string]awseks.CapacityType{
+	"":          awseks.CapacityType_ON_DEMAND,
+	"ON_DEMAND": awseks.CapacityType_ON_DEMAND,
+	"SPOT":      awseks.CapacityType_SPOT,
+}
+
+// amiTypes are the AMI types of EKS managed nodegroups, as the EKS API
+// names them, and the architecture of their instances. The CDK enum lacks
+// the recent ones, so they are set on the CfnNodegroup.
+var amiTypes = map[string]awsec2.InstanceArchitecture{
+	"AL2_x86_64":             awsec2.InstanceArchitecture_X86_64,
+	"AL2_x86_64_GPU":         awsec2.InstanceArchitecture_X86_64,
+	"AL2_ARM_64":             awsec2.InstanceArchitecture_ARM_64,
+	"AL2023_x86_64_STANDARD": awsec2.InstanceArchitecture_X86_64,
+	"AL2023_ARM_64_STANDARD": awsec2.InstanceArchitecture_ARM_64,
+	"BOTTLEROCKET_x86_64":    awsec2.InstanceArchitecture_X86_64,
+	"BOTTLEROCKET_ARM_64":    awsec2.InstanceArchitecture_ARM_64,
+}
+
+// gpuInstanceFamilies are the instance families CDK gives the GPU AMI, see
+// nodegroupAmiType.
+var gpuInstanceFamilies = map[string]bool{
+	"p2": true, "p3": true, "p3dn": true, "p4d": true, "p4de": true,
+	"g3": true, "g3s": true, "g4dn": true, "g4ad": true, "g5": true, "g5g": true,
+	"inf1": true, "inf2": true,
+}
+
+// nodegroupAmiType returns the AmiType of ng, checked against the
+// architecture of its instance types, or the one they need.
+//
+// CDK only infers the AMI type of a nodegroup without a launch template,
+// and EKS otherwise defaults to AL2_x86_64. The inference is the same as
+// CDK's, so that the nodegroups created before the launch templates keep
+// their AMI type and aren't replaced.
+func nodegroupAmiType(ng Nodegroup) (string, error) {
+	var architecture awsec2.InstanceArchitecture
+	gpu := false
+	for _, instanceType := range ng.InstanceTypes {
+		arch := awsec2.NewInstanceType(jsii.String(instanceType)).Architecture()
+		if architecture != "" && arch != architecture {
+			return "", fmt.Errorf("nodegroup %s: InstanceTypes mix the %s and %s architectures", ng.Name, architecture, arch)
+		}
+		architecture = arch
+		gpu = gpu || gpuInstanceFamilies[strings.Split(instanceType, ".")[0]]
+	}
+
+	if ng.AmiType != "" {
+		amiArchitecture, ok := amiTypes[ng.AmiType]
+		if !ok {
+			return "", fmt.Errorf("nodegroup %s: unsupported AmiType %q", ng.Name, ng.AmiType)
+		}
+		if amiArchitecture != architecture {
+			return "", fmt.Errorf("nodegroup %s: AmiType %s doesn't run on %s instances", ng.Name, ng.AmiType, architecture)
+		}
+		return ng.AmiType, nil
+	}
+	switch {
+	case architecture == awsec2.InstanceArchitecture_ARM_64:
+		return "AL2_ARM_64", nil
+	case gpu:
+		return "AL2_x86_64_GPU", nil
+	}
+	return "AL2_x86_64", nil
+}
+
+// defaultNodegroup is the single nodegroup of configurations without a
+// Nodegroups list, from Workernode, WorkernodeMax, Instance, InstanceSize,
+// NodeLabels and NodeTaints.
+func defaultNodegroup(AppConfig Configuration) Nodegroup {
+	instanceType := awsec2.InstanceType_Of(awsec2.InstanceClass(AppConfig.Instance), awsec2.InstanceSize(AppConfig.InstanceSize))
+	return Nodegroup{
+		Name:          "DefaultCapacity",
+		InstanceTypes: []string{*instanceType.ToString()},
+		MinSize:       AppConfig.Workernode,
+		MaxSize:       AppConfig.WorkernodeMax,
+		Labels:        AppConfig.NodeLabels,
+		Taints:        AppConfig.NodeTaints,
+	}
+}
+
+// nodegroupOptions checks ng and converts it to CDK options.
+func nodegroupOptions(scope constructs.Construct, ng Nodegroup) (*awseks.NodegroupOptions, error) {
+	if ng.Name == "" {
+		return nil, fmt.Errorf("nodegroup without a Name")
+	}
+	if len(ng.InstanceTypes) == 0 {
+		return nil, fmt.Errorf("nodegroup %s: no InstanceTypes", ng.Name)
+	}
+	capacityType, ok := capacityTypes[ng.CapacityType]
+	if !ok {
+		return nil, fmt.Errorf("nodegroup %s: unsupported CapacityType %q", ng.Name, ng.CapacityType)
+	}
+	if ng.MaxSize > 0 && (ng.MinSize > ng.MaxSize || ng.DesiredSize > ng.MaxSize) {
+		return nil, fmt.Errorf("nodegroup %s: MinSize and DesiredSize must not exceed MaxSize", ng.Name)
+	}
+	if ng.DesiredSize > 0 && ng.MinSize > ng.DesiredSize {
+		return nil, fmt.Errorf("nodegroup %s: MinSize must not exceed DesiredSize", ng.Name)
+	}
+	labels, err := nodeLabels(ng.Labels)
+	if err != nil {
+		return nil, fmt.Errorf("nodegroup %s: %w", ng.Name, err)
+	}
+	taints, err := nodeTaints(ng.Taints)
+	if err != nil {
+		return nil, fmt.Errorf("nodegroup %s: %w", ng.Name, err)
+	}
+	subnets, err := nodegroupSubnets(scope, ng)
+	if err != nil {
+		return nil, err
+	}
+
+	var instanceTypes []awsec2.InstanceType
+	for _, instanceType := range ng.InstanceTypes {
+		instanceTypes = append(instanceTypes, awsec2.NewInstanceType(jsii.String(instanceType)))
+	}
+	options := &awseks.NodegroupOptions{
+		InstanceTypes: &instanceTypes,
+		MinSize:       sizeOrNil(ng.MinSize),
+		DesiredSize:   sizeOrNil(ng.DesiredSize),
+		MaxSize:       sizeOrNil(ng.MaxSize),
+		Labels:        labels,
+		Taints:        taints,
+		Subnets:       subnets,
+	}
+	// Keep the template of on-demand nodegroups as it was without the field
+	if ng.CapacityType != "" {
+		options.CapacityType = capacityType
+	}
+	return options, nil
+}
+
+func nodegroupSubnets(scope constructs.Construct, ng Nodegroup) (*awsec2.SubnetSelection, error) {
+	if len(ng.SubnetIds) > 0 {
+		if ng.SubnetType != "" {
+			return nil, fmt.Errorf("nodegroup %s: set SubnetType or SubnetIds, not both", ng.Name)
+		}
+		var subnets []awsec2.ISubnet
+		for _, id := range ng.SubnetIds {
+			subnets = append(subnets, awsec2.Subnet_FromSubnetId(scope, jsii.String(ng.Name+"-"+id), jsii.String(id)))
+		}
+		return &awsec2.SubnetSelection{Subnets: &subnets}, nil
+	}
+	switch ng.SubnetType {
+	case "":
+		return nil, nil
+	case "private":
+		return &awsec2.SubnetSelection{SubnetType: awsec2.SubnetType_PRIVATE_WITH_EGRESS}, nil
+	case "public":
+		return &awsec2.SubnetSelection{SubnetType: awsec2.SubnetType_PUBLIC}, nil
+	}
+	return nil, fmt.Errorf("nodegroup %s: unsupported SubnetType %q", ng.Name, ng.SubnetType)
+}
+
+func sizeOrNil(size float64) *float64 {
+	if size == 0 {
+		return nil
+	}
+	return &size
+}
+
+// addNodegroups adds the Nodegroups of config.json to the cluster, or the
+// default one without a list, with the instances of nodeRole started from
+// a hardened launch template, in securityGroups when not nil. The default
+// nodegroup keeps the ID of the cluster's former default capacity, but the
+// node role and the launch template still replace it on an existing
+// cluster.
+func addNodegroups(scope constructs.Construct, cluster awseks.Cluster, nodeRole awsiam.IRole, securityGroups *[]*string, AppConfig Configuration) ([]awseks.Nodegroup, error) {
+	nodegroups := AppConfig.Nodegroups
+	if len(nodegroups) == 0 {
+		nodegroups = []Nodegroup{defaultNodegroup(AppConfig)}
+	}
+
+	seen := make(map[string]bool)
+	var result []awseks.Nodegroup
+	for _, ng := range nodegroups {
+		if seen[ng.Name] {
+			return nil, fmt.Errorf("nodegroup %s is listed twice", ng.Name)
+		}
+		seen[ng.Name] = true
+
+		options, err := nodegroupOptions(scope, ng)
+		if err != nil {
+			return nil, err
+		}
+		amiType, err := nodegroupAmiType(ng)
+		if err != nil {
+			return nil, err
+		}
+		options.NodeRole = nodeRole
+		template, err := nodeLaunchTemplate(scope, ng, securityGroups, AppConfig)
+		if err != nil {
+			return nil, err
+		}
+		options.LaunchTemplateSpec = launchTemplateSpec(template)
+		nodegroup := cluster.AddNodegroupCapacity(jsii.String(ng.Name), options)
+		// Always set: with a launch template, CDK leaves it to EKS
+		nodegroup.Node().DefaultChild().(awseks.CfnNodegroup).SetAmiType(jsii.String(amiType))
+		result = append(result, nodegroup)
+	}
+	return result, nil
+}
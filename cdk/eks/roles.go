@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// Role name suffix used when EksNodeRole is empty. Without EksClusterRole,
+// the admin role stays the service role of the cluster.
+const defaultNodeRole = "NodeRole"
+
+// clusterRoles are the IAM roles of the cluster, each with only the
+// permissions its user needs.
+type clusterRoles struct {
+	// cluster is the service role EKS manages the cluster with
+	cluster awsiam.IRole
+	// admin is the role of the cluster administrators, mapped to
+	// system:masters
+	admin awsiam.IRole
+	// node is the role of the managed nodegroup instances
+	node awsiam.IRole
+}
+
+// adminPrincipals returns the principals allowed to assume the admin role:
+// AdminPrincipalArns and AdminPrincipalArn, or the account when both are
+// empty, so that any of its principals allowed to by their IAM policies can.
+func adminPrincipals(AppConfig Configuration) ([]awsiam.IPrincipal, error) {
+	arns := AppConfig.AdminPrincipalArns
+	if AppConfig.AdminPrincipalArn != "" {
+		arns = append([]string{AppConfig.AdminPrincipalArn}, arns...)
+	}
+	if len(arns) == 0 {
+		return []awsiam.IPrincipal{awsiam.NewAccountRootPrincipal()}, nil
+	}
+
+	var principals []awsiam.IPrincipal
+	for _, arn := range arns {
+		if !strings.HasPrefix(arn, "arn:") {
+			return nil, fmt.Errorf("AdminPrincipalArns: %q is not an ARN", arn)
+		}
+		principals = append(principals, awsiam.NewArnPrincipal(jsii.String(arn)))
+	}
+	return principals, nil
+}
+
+// newClusterRoles creates the cluster, admin and node roles.
+//
+// When EksClusterRole is empty or names the admin role, that single role
+// stays the service role of the cluster, as it was before the roles were
+// split: EKS can't change the role of an existing cluster. It then only
+// keeps the cluster policies on top of the admin permissions.
+func newClusterRoles(stack awscdk.Stack, clusterName string, AppConfig Configuration) (clusterRoles, error) {
+	principals, err := adminPrincipals(AppConfig)
+	if err != nil {
+		return clusterRoles{}, err
+	}
+
+	adminRoleName := clusterName + AppConfig.EksAdminRole
+	clusterRoleName := adminRoleName
+	if AppConfig.EksClusterRole != "" {
+		clusterRoleName = clusterName + AppConfig.EksClusterRole
+	}
+	nodeRoleName := clusterName + defaultNodeRole
+	if AppConfig.EksNodeRole != "" {
+		nodeRoleName = clusterName + AppConfig.EksNodeRole
+	}
+	if nodeRoleName == adminRoleName || nodeRoleName == clusterRoleName {
+		return clusterRoles{}, fmt.Errorf("EksNodeRole must differ from EksAdminRole and EksClusterRole")
+	}
+	shared := clusterRoleName == adminRoleName
+
+	eksService := awsiam.NewServicePrincipal(jsii.String("eks.amazonaws.com"), nil)
+	clusterPolicies := []awsiam.IManagedPolicy{
+		awsiam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("AmazonEKSClusterPolicy")),
+		awsiam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("AmazonEKSVPCResourceController")),
+	}
+
+	// The admins only need to find the cluster, Kubernetes RBAC does the rest
+	adminTrust := principals
+	var adminPolicies []awsiam.IManagedPolicy
+	if shared {
+		adminTrust = append(adminTrust, eksService)
+		adminPolicies = clusterPolicies
+	}
+	admin := awsiam.NewRole(stack, &adminRoleName, &awsiam.RoleProps{
+		AssumedBy:       awsiam.NewCompositePrincipal(adminTrust...),
+		RoleName:        &adminRoleName,
+		ManagedPolicies: &adminPolicies,
+	})
+	admin.AddToPolicy(awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+		Effect:  awsiam.Effect_ALLOW,
+		Actions: jsii.Strings("eks:DescribeCluster"),
+		Resources: jsii.Strings(*stack.FormatArn(&awscdk.ArnComponents{
+			Service:      jsii.String("eks"),
+			Resource:     jsii.String("cluster"),
+			ResourceName: &clusterName,
+		})),
+	}))
+
+	roles := clusterRoles{admin: admin, cluster: admin}
+	if !shared {
+		roles.cluster = awsiam.NewRole(stack, &clusterRoleName, &awsiam.RoleProps{
+			AssumedBy:       eksService,
+			RoleName:        &clusterRoleName,
+			ManagedPolicies: &clusterPolicies,
+		})
+	}
+
+	roles.node = awsiam.NewRole(stack, &nodeRoleName, &awsiam.RoleProps{
+		AssumedBy: awsiam.NewServicePrincipal(jsii.String("ec2.amazonaws.com"), nil),
+		RoleName:  &nodeRoleName,
+		ManagedPolicies: &[]awsiam.IManagedPolicy{
+			awsiam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("AmazonEKSWorkerNodePolicy")),
+			awsiam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("AmazonEKS_CNI_Policy")),
+			awsiam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("AmazonEC2ContainerRegistryReadOnly")),
+		},
+	})
+	return roles, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+)
+
+// Deployer deploys the CDK app of a folder.
+type Deployer interface {
+	// Deploy runs cdk deploy in dir, with the context values of context.
+	Deploy(dir string, context map[string]string) error
+}
+
+// cdkDeployer runs the cdk command line, showing its output.
+type cdkDeployer struct{}
+
+func (cdkDeployer) Deploy(dir string, context map[string]string) error {
+	args := []string{"deploy", "--require-approval", "never"}
+	keys := make([]string, 0, len(context))
+	for key := range context {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "--context", key+"="+context[key])
+	}
+
+	cmd := exec.Command("cdk", args...)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+var k8sVersionSetting = regexp.MustCompile(`("K8sVersion"\s*:\s*")[^"]*(")`)
+
+// setK8sVersion sets K8sVersion in the config.json at path, leaving the rest
+// of the file as it is.
+func setK8sVersion(path, version string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if matches := k8sVersionSetting.FindAll(content, -1); len(matches) != 1 {
+		return fmt.Errorf("%s must set K8sVersion once, found %d", path, len(matches))
+	}
+	updated := k8sVersionSetting.ReplaceAll(content, []byte("${1}"+version+"${2}"))
+	return os.WriteFile(path, updated, 0o644)
+}
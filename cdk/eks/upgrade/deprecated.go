@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// lastAppliedAnnotation holds the manifest of an object applied by kubectl.
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// removedAPI is an API version a Kubernetes release stops serving.
+type removedAPI struct {
+	// Removed is the group version and resource no longer served
+	Removed schema.GroupVersionResource
+	Kind    string
+	// RemovedIn is the Kubernetes version that removes it
+	RemovedIn string
+	// ListWith is a version the previous release serves the objects with,
+	// the removed one when the kind goes away altogether
+	ListWith schema.GroupVersionResource
+}
+
+func gvr(group, version, resource string) schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: group, Version: version, Resource: resource}
+}
+
+// removedAPIs are the removals of the Kubernetes deprecation guide, for the
+// versions of the compatibility matrix and the next ones.
+var removedAPIs = []removedAPI{
+	{gvr("batch", "v1beta1", "cronjobs"), "CronJob", "1.25", gvr("batch", "v1", "cronjobs")},
+	{gvr("discovery.k8s.io", "v1beta1", "endpointslices"), "EndpointSlice", "1.25", gvr("discovery.k8s.io", "v1", "endpointslices")},
+	{gvr("events.k8s.io", "v1beta1", "events"), "Event", "1.25", gvr("events.k8s.io", "v1", "events")},
+	{gvr("autoscaling", "v2beta1", "horizontalpodautoscalers"), "HorizontalPodAutoscaler", "1.25", gvr("autoscaling", "v2", "horizontalpodautoscalers")},
+	{gvr("policy", "v1beta1", "poddisruptionbudgets"), "PodDisruptionBudget", "1.25", gvr("policy", "v1", "poddisruptionbudgets")},
+	{gvr("policy", "v1beta1", "podsecuritypolicies"), "PodSecurityPolicy", "1.25", gvr("policy", "v1beta1", "podsecuritypolicies")},
+	{gvr("node.k8s.io", "v1beta1", "runtimeclasses"), "RuntimeClass", "1.25", gvr("node.k8s.io", "v1", "runtimeclasses")},
+	{gvr("flowcontrol.apiserver.k8s.io", "v1beta1", "flowschemas"), "FlowSchema", "1.26", gvr("flowcontrol.apiserver.k8s.io", "v1beta2", "flowschemas")},
+	{gvr("flowcontrol.apiserver.k8s.io", "v1beta1", "prioritylevelconfigurations"), "PriorityLevelConfiguration", "1.26", gvr("flowcontrol.apiserver.k8s.io", "v1beta2", "prioritylevelconfigurations")},
+	{gvr("autoscaling", "v2beta2", "horizontalpodautoscalers"), "HorizontalPodAutoscaler", "1.26", gvr("autoscaling", "v2", "horizontalpodautoscalers")},
+	{gvr("storage.k8s.io", "v1beta1", "csistoragecapacities"), "CSIStorageCapacity", "1.27", gvr("storage.k8s.io", "v1", "csistoragecapacities")},
+	{gvr("flowcontrol.apiserver.k8s.io", "v1beta2", "flowschemas"), "FlowSchema", "1.29", gvr("flowcontrol.apiserver.k8s.io", "v1beta3", "flowschemas")},
+	{gvr("flowcontrol.apiserver.k8s.io", "v1beta2", "prioritylevelconfigurations"), "PriorityLevelConfiguration", "1.29", gvr("flowcontrol.apiserver.k8s.io", "v1beta3", "prioritylevelconfigurations")},
+}
+
+// removedBetween returns the APIs removed after from, up to to.
+func removedBetween(from, to string) ([]removedAPI, error) {
+	fromMinor, err := minorVersion(from)
+	if err != nil {
+		return nil, err
+	}
+	toMinor, err := minorVersion(to)
+	if err != nil {
+		return nil, err
+	}
+	var apis []removedAPI
+	for _, api := range removedAPIs {
+		minor, err := minorVersion(api.RemovedIn)
+		if err != nil {
+			return nil, err
+		}
+		if minor > fromMinor && minor <= toMinor {
+			apis = append(apis, api)
+		}
+	}
+	return apis, nil
+}
+
+// scanDeprecated lists what still uses an API removed by the upgrade from
+// current to to: the objects last written with it, by kubectl or another
+// field manager such as Helm, and the clients the API server counted
+// requests of. Objects of a kind the upgrade removes are all reported.
+func scanDeprecated(ctx context.Context, clients Clients, current, to string) ([]string, error) {
+	apis, err := removedBetween(current, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []string
+	// The API server counts the requests to deprecated APIs since it started,
+	// read before the listing below adds to them. Without its metrics they
+	// can't be checked, which needs -force
+	if clients.Metrics != nil {
+		metrics, err := clients.Metrics(ctx)
+		if err != nil {
+			findings = append(findings, fmt.Sprintf("the requests to deprecated APIs can't be checked, the API server metrics are not readable: %v", err))
+		} else {
+			requests, err := deprecatedRequests(metrics, current, to)
+			if err != nil {
+				return nil, err
+			}
+			findings = append(findings, requests...)
+		}
+	}
+
+	for _, api := range apis {
+		removed := api.Removed.GroupVersion().String()
+		list, err := clients.Dynamic.Resource(api.ListWith).Namespace(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+		if apierrors.IsNotFound(err) {
+			// Not served, so nothing stored with it
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("listing the %s: %w", api.ListWith.Resource, err)
+		}
+		for i := range list.Items {
+			obj := &list.Items[i]
+			if api.ListWith == api.Removed || writtenWith(obj, removed) {
+				findings = append(findings, fmt.Sprintf("%s %s uses %s, removed in %s", api.Kind, objectName(obj), removed, api.RemovedIn))
+			}
+		}
+	}
+
+	return findings, nil
+}
+
+// writtenWith reports whether obj was last applied by kubectl, or written by
+// a field manager, with the API groupVersion.
+func writtenWith(obj *unstructured.Unstructured, groupVersion string) bool {
+	for _, entry := range obj.GetManagedFields() {
+		if entry.APIVersion == groupVersion {
+			return true
+		}
+	}
+	if applied := obj.GetAnnotations()[lastAppliedAnnotation]; applied != "" {
+		var manifest struct {
+			APIVersion string `json:"apiVersion"`
+		}
+		if json.Unmarshal([]byte(applied), &manifest) == nil && manifest.APIVersion == groupVersion {
+			return true
+		}
+	}
+	return false
+}
+
+func objectName(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() == "" {
+		return obj.GetName()
+	}
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
+
+// deprecatedRequestsMetric counts the requests to deprecated APIs, labeled
+// with the API and the release that removes it.
+const deprecatedRequestsMetric = "apiserver_requested_deprecated_apis"
+
+var metricLabel = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// deprecatedRequests returns the APIs removed after current, up to to, that
+// the Prometheus metrics of the API server saw requests of.
+func deprecatedRequests(metrics []byte, current, to string) ([]string, error) {
+	fromMinor, err := minorVersion(current)
+	if err != nil {
+		return nil, err
+	}
+	toMinor, err := minorVersion(to)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(metrics))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, deprecatedRequestsMetric+"{") {
+			continue
+		}
+		labels := make(map[string]string)
+		for _, match := range metricLabel.FindAllStringSubmatch(line[:strings.LastIndex(line, "}")+1], -1) {
+			labels[match[1]] = match[2]
+		}
+		minor, err := minorVersion(labels["removed_release"])
+		if err != nil || minor <= fromMinor || minor > toMinor {
+			continue
+		}
+		groupVersion := schema.GroupVersion{Group: labels["group"], Version: labels["version"]}.String()
+		seen[fmt.Sprintf("clients requested %s %s, removed in %s", groupVersion, labels["resource"], labels["removed_release"])] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	requests := make([]string, 0, len(seen))
+	for request := range seen {
+		requests = append(requests, request)
+	}
+	sort.Strings(requests)
+	return requests, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var unstructuredPSP = unstructured.Unstructured{Object: map[string]interface{}{
+	"apiVersion": "policy/v1beta1",
+	"kind":       "PodSecurityPolicy",
+	"metadata":   map[string]interface{}{"name": "restricted"},
+}}
+
+func cronJob(name, appliedWith string, managers ...string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "batch/v1",
+		"kind":       "CronJob",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "sonarqube"},
+	}}
+	if appliedWith != "" {
+		obj.SetAnnotations(map[string]string{lastAppliedAnnotation: `{"apiVersion":"` + appliedWith + `","kind":"CronJob"}`})
+	}
+	var fields []interface{}
+	for _, manager := range managers {
+		fields = append(fields, map[string]interface{}{"manager": "helm", "operation": "Update", "apiVersion": manager})
+	}
+	if fields != nil {
+		obj.Object["metadata"].(map[string]interface{})["managedFields"] = fields
+	}
+	return obj
+}
+
+func TestScanDeprecated(t *testing.T) {
+	clients := Clients{
+		Dynamic: dynamicClient(
+			cronJob("backup", "batch/v1beta1"),
+			cronJob("cleanup", "", "batch/v1", "batch/v1beta1"),
+			cronJob("report", "batch/v1", "batch/v1"),
+		),
+		Metrics: func(context.Context) ([]byte, error) {
+			return []byte(`# TYPE apiserver_requested_deprecated_apis gauge
+apiserver_requested_deprecated_apis{group="batch",removed_release="1.25",resource="cronjobs",subresource="",version="v1beta1"} 1
+apiserver_requested_deprecated_apis{group="autoscaling",removed_release="1.26",resource="horizontalpodautoscalers",subresource="",version="v2beta2"} 1
+`), nil
+		},
+	}
+
+	findings, err := scanDeprecated(context.Background(), clients, "1.24", "1.25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"clients requested batch/v1beta1 cronjobs, removed in 1.25",
+		"CronJob sonarqube/backup uses batch/v1beta1, removed in 1.25",
+		"CronJob sonarqube/cleanup uses batch/v1beta1, removed in 1.25",
+	}
+	if got := strings.Join(findings, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("got findings\n%s\nwant\n%s", got, strings.Join(want, "\n"))
+	}
+
+	// Nothing the 1.27 to 1.28 upgrade removes
+	findings, err = scanDeprecated(context.Background(), clients, "1.27", "1.28")
+	if err != nil || len(findings) != 0 {
+		t.Errorf("got %v, %v", findings, err)
+	}
+}
+
+func TestDeprecatedRequests(t *testing.T) {
+	metrics := []byte(`# HELP apiserver_requested_deprecated_apis [STABLE] Gauge of deprecated APIs that have been requested
+apiserver_requested_deprecated_apis{group="flowcontrol.apiserver.k8s.io",removed_release="1.29",resource="flowschemas",subresource="",version="v1beta2"} 1
+apiserver_requested_deprecated_apis{group="flowcontrol.apiserver.k8s.io",removed_release="1.29",resource="flowschemas",subresource="status",version="v1beta2"} 1
+apiserver_requested_deprecated_apis{group="",removed_release="",resource="componentstatuses",subresource="",version="v1"} 1
+apiserver_requested_deprecated_apis{group="storage.k8s.io",removed_release="1.27",resource="csistoragecapacities",subresource="",version="v1beta1"} 1
+apiserver_request_total{code="200",group="flowcontrol.apiserver.k8s.io",version="v1beta2"} 12
+`)
+	got, err := deprecatedRequests(metrics, "1.28", "1.29")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != "clients requested flowcontrol.apiserver.k8s.io/v1beta2 flowschemas, removed in 1.29" {
+		t.Errorf("got %v", got)
+	}
+
+	if _, err := deprecatedRequests(metrics, "1.28", "latest"); err == nil {
+		t.Error("got no error for an invalid version")
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eks"
+)
+
+// EKSAPI is the subset of the EKS client the upgrade uses. *eks.EKS
+// satisfies it.
+type EKSAPI interface {
+	DescribeCluster(*eks.DescribeClusterInput) (*eks.DescribeClusterOutput, error)
+	ListNodegroups(*eks.ListNodegroupsInput) (*eks.ListNodegroupsOutput, error)
+	DescribeNodegroup(*eks.DescribeNodegroupInput) (*eks.DescribeNodegroupOutput, error)
+	UpdateNodegroupVersion(*eks.UpdateNodegroupVersionInput) (*eks.UpdateNodegroupVersionOutput, error)
+	DescribeUpdate(*eks.DescribeUpdateInput) (*eks.DescribeUpdateOutput, error)
+	ListAddons(*eks.ListAddonsInput) (*eks.ListAddonsOutput, error)
+	DescribeAddon(*eks.DescribeAddonInput) (*eks.DescribeAddonOutput, error)
+}
+
+// minorVersion returns the minor version of a 1.x Kubernetes version.
+func minorVersion(version string) (int, error) {
+	parts := strings.Split(version, ".")
+	if len(parts) != 2 || parts[0] != "1" {
+		return 0, fmt.Errorf("invalid Kubernetes version %q, e.g. 1.28", version)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid Kubernetes version %q, e.g. 1.28", version)
+	}
+	return minor, nil
+}
+
+// targetVersion checks that to is current or the next minor version, the
+// only upgrade EKS allows. It is the next one when to is empty.
+func targetVersion(current, to string) (string, error) {
+	minor, err := minorVersion(current)
+	if err != nil {
+		return "", err
+	}
+	next := fmt.Sprintf("1.%d", minor+1)
+	if to == "" {
+		return next, nil
+	}
+	target, err := minorVersion(to)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case target < minor:
+		return "", fmt.Errorf("the cluster runs %s, EKS can't downgrade it to %s", current, to)
+	case target > minor+1:
+		return "", fmt.Errorf("the cluster runs %s: upgrade one minor version at a time, to %s first", current, next)
+	}
+	return to, nil
+}
+
+// checkStackSettings checks that deploying the EKS stack won't replace the
+// cluster or change its encryption, which the CDK refuses and would leave
+// the upgrade stuck: config.json must keep the service role and the
+// Secrets encryption of the cluster.
+func checkStackSettings(cluster *eks.Cluster, plan Plan) error {
+	if plan.ClusterRole != "" {
+		roleArn := aws.StringValue(cluster.RoleArn)
+		if name := roleArn[strings.LastIndex(roleArn, "/")+1:]; name != plan.ClusterRole {
+			return fmt.Errorf("cluster %s runs with role %s but config.json sets %s: set EksClusterRole to %s", plan.ClusterName, name, plan.ClusterRole, strings.TrimPrefix(name, plan.ClusterName))
+		}
+	}
+	encrypted := false
+	for _, config := range cluster.EncryptionConfig {
+		for _, resource := range config.Resources {
+			encrypted = encrypted || aws.StringValue(resource) == "secrets"
+		}
+	}
+	if encrypted != plan.SecretsEncryption {
+		return fmt.Errorf("the Secrets encryption of cluster %s can only be set when it is created: set SecretsEncryption to %t", plan.ClusterName, encrypted)
+	}
+	return nil
+}
+
+// listNodegroups describes the managed nodegroups of the cluster.
+func listNodegroups(api EKSAPI, clusterName string) ([]*eks.Nodegroup, error) {
+	var nodegroups []*eks.Nodegroup
+	input := &eks.ListNodegroupsInput{ClusterName: aws.String(clusterName)}
+	for {
+		out, err := api.ListNodegroups(input)
+		if err != nil {
+			return nil, fmt.Errorf("listing the nodegroups of %s: %w", clusterName, err)
+		}
+		for _, name := range out.Nodegroups {
+			described, err := api.DescribeNodegroup(&eks.DescribeNodegroupInput{
+				ClusterName:   aws.String(clusterName),
+				NodegroupName: name,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("describing nodegroup %s: %w", aws.StringValue(name), err)
+			}
+			nodegroups = append(nodegroups, described.Nodegroup)
+		}
+		if out.NextToken == nil {
+			return nodegroups, nil
+		}
+		input.NextToken = out.NextToken
+	}
+}
+
+// upgradeNodegroup moves the nodegroup name to version and waits for EKS to
+// have replaced its nodes. New nodes are started before the old ones are
+// drained, which respects the PodDisruptionBudgets.
+func upgradeNodegroup(ctx context.Context, api EKSAPI, plan Plan, name, version string) error {
+	out, err := api.UpdateNodegroupVersion(&eks.UpdateNodegroupVersionInput{
+		ClusterName:   aws.String(plan.ClusterName),
+		NodegroupName: aws.String(name),
+		Version:       aws.String(version),
+	})
+	if err != nil {
+		return fmt.Errorf("upgrading nodegroup %s: %w", name, err)
+	}
+
+	return waitFor(ctx, func(ctx context.Context) error {
+		update, err := api.DescribeUpdate(&eks.DescribeUpdateInput{
+			Name:          aws.String(plan.ClusterName),
+			NodegroupName: aws.String(name),
+			UpdateId:      out.Update.Id,
+		})
+		if err != nil {
+			return permanent(fmt.Errorf("describing the update of nodegroup %s: %w", name, err))
+		}
+		switch status := aws.StringValue(update.Update.Status); status {
+		case eks.UpdateStatusSuccessful:
+			return nil
+		case eks.UpdateStatusFailed, eks.UpdateStatusCancelled:
+			var reasons []string
+			for _, e := range update.Update.Errors {
+				reasons = append(reasons, aws.StringValue(e.ErrorMessage))
+			}
+			return permanent(fmt.Errorf("upgrade of nodegroup %s %s: %s", name, strings.ToLower(status), strings.Join(reasons, "; ")))
+		default:
+			return fmt.Errorf("upgrade of nodegroup %s is %s", name, strings.ToLower(status))
+		}
+	}, plan)
+}
+
+// addonsActive checks that the EKS addons of the cluster are all active.
+func addonsActive(api EKSAPI, clusterName string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		out, err := api.ListAddons(&eks.ListAddonsInput{ClusterName: aws.String(clusterName)})
+		if err != nil {
+			return permanent(fmt.Errorf("listing the addons of %s: %w", clusterName, err))
+		}
+		for _, name := range out.Addons {
+			addon, err := api.DescribeAddon(&eks.DescribeAddonInput{
+				ClusterName: aws.String(clusterName),
+				AddonName:   name,
+			})
+			if err != nil {
+				return permanent(fmt.Errorf("describing addon %s: %w", aws.StringValue(name), err))
+			}
+			switch status := aws.StringValue(addon.Addon.Status); status {
+			case eks.AddonStatusActive:
+			// DEGRADED may only last while the pods of the addon roll
+			case eks.AddonStatusCreateFailed, eks.AddonStatusUpdateFailed:
+				return permanent(fmt.Errorf("addon %s is %s", aws.StringValue(name), status))
+			default:
+				return fmt.Errorf("addon %s is %s", aws.StringValue(name), status)
+			}
+		}
+		return nil
+	}
+}
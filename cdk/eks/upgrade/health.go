@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// nodegroupLabel is set by EKS on the nodes of a managed nodegroup.
+const nodegroupLabel = "eks.amazonaws.com/nodegroup"
+
+// permanentError ends a wait early, when retrying can't help.
+type permanentError struct{ error }
+
+func permanent(err error) error {
+	return permanentError{err}
+}
+
+// waitFor polls check until it succeeds, fails for good or plan.Timeout
+// expires. It then returns the last failure of check.
+func waitFor(ctx context.Context, check func(context.Context) error, plan Plan) error {
+	interval := plan.PollInterval
+	if interval == 0 {
+		interval = 2 * time.Second
+	}
+
+	var last error
+	err := wait.PollUntilContextTimeout(ctx, interval, plan.Timeout, true, func(ctx context.Context) (bool, error) {
+		last = check(ctx)
+		var stop permanentError
+		if errors.As(last, &stop) {
+			return false, stop.error
+		}
+		return last == nil, nil
+	})
+	if err != nil && last != nil && !errors.As(last, new(permanentError)) {
+		return fmt.Errorf("%w, after waiting %s", last, plan.Timeout)
+	}
+	return err
+}
+
+// healthCheck checks that all the nodes are Ready and that the Deployments
+// and StatefulSets of namespaces are. Missing namespaces are skipped.
+func healthCheck(client kubernetes.Interface, namespaces []string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("listing the nodes: %w", err)
+		}
+		var notReady []string
+		for _, node := range nodes.Items {
+			if !nodeReady(node) {
+				notReady = append(notReady, node.Name)
+			}
+		}
+		if len(notReady) > 0 {
+			return fmt.Errorf("nodes not ready: %s", strings.Join(notReady, ", "))
+		}
+
+		for _, namespace := range namespaces {
+			_, err := client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("getting namespace %s: %w", namespace, err)
+			}
+			if err := workloadsReady(ctx, client, namespace); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func nodeReady(node corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// workloadsReady checks that the Deployments and StatefulSets of namespace
+// have all their replicas updated and ready.
+func workloadsReady(ctx context.Context, client kubernetes.Interface, namespace string) error {
+	var notReady []string
+
+	deployments, err := client.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("listing the Deployments of %s: %w", namespace, err)
+	}
+	for _, d := range deployments.Items {
+		if !deploymentReady(d) {
+			notReady = append(notReady, "Deployment "+namespace+"/"+d.Name)
+		}
+	}
+
+	statefulSets, err := client.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("listing the StatefulSets of %s: %w", namespace, err)
+	}
+	for _, s := range statefulSets.Items {
+		if !statefulSetReady(s) {
+			notReady = append(notReady, "StatefulSet "+namespace+"/"+s.Name)
+		}
+	}
+
+	if len(notReady) > 0 {
+		return fmt.Errorf("not ready: %s", strings.Join(notReady, ", "))
+	}
+	return nil
+}
+
+func desiredReplicas(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
+
+func deploymentReady(d appsv1.Deployment) bool {
+	replicas := desiredReplicas(d.Spec.Replicas)
+	return d.Status.ObservedGeneration >= d.Generation &&
+		d.Status.UpdatedReplicas >= replicas &&
+		d.Status.AvailableReplicas >= replicas
+}
+
+func statefulSetReady(s appsv1.StatefulSet) bool {
+	replicas := desiredReplicas(s.Spec.Replicas)
+	return s.Status.ObservedGeneration >= s.Generation &&
+		s.Status.UpdatedReplicas >= replicas &&
+		s.Status.ReadyReplicas >= replicas
+}
+
+// nodegroupReady checks that the nodes of the nodegroup name all run the
+// kubelet of version and are Ready.
+func nodegroupReady(client kubernetes.Interface, name, version string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{
+			LabelSelector: nodegroupLabel + "=" + name,
+		})
+		if err != nil {
+			return fmt.Errorf("listing the nodes of nodegroup %s: %w", name, err)
+		}
+		if len(nodes.Items) == 0 {
+			return fmt.Errorf("nodegroup %s has no nodes", name)
+		}
+		for _, node := range nodes.Items {
+			if kubelet := node.Status.NodeInfo.KubeletVersion; !strings.HasPrefix(kubelet, "v"+version+".") {
+				return fmt.Errorf("node %s of nodegroup %s runs %s, not %s", node.Name, name, kubelet, version)
+			}
+			if !nodeReady(node) {
+				return fmt.Errorf("node %s of nodegroup %s is not ready", node.Name, name)
+			}
+		}
+		return nil
+	}
+}
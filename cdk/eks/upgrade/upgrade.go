@@ -0,0 +1,372 @@
+// upgrade moves the EKS cluster of the tutorial to the next Kubernetes
+// version: the control plane through the EKS stack, then the addons through
+// the addons step, then the nodegroups, one at a time. It runs from the
+// upgrade folder: go run . [-to 1.28] [-dry-run] [-force]
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"CDK/pkg/clusterimport"
+	"CDK/pkg/k8sversions"
+	"CDK/pkg/kubetunnel"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+type ConfAuth struct {
+	Region     string
+	Account    string
+	SSOProfile string
+	Index      string
+	AWSsecret  string
+}
+
+type Configuration struct {
+	ClusterName string
+	K8sVersion  string
+	// Namespaces whose Deployments and StatefulSets must stay ready between
+	// the phases, e.g. SonarQube and the sample application
+	UpgradeCheckNamespaces []string
+	// Settings the EKS stack can't change on an existing cluster
+	EksAdminRole      string
+	EksClusterRole    string
+	SecretsEncryption bool
+	SecretsKmsKeyArn  string
+	// An imported cluster is not upgraded by the tutorial
+	Cluster clusterimport.Settings
+}
+
+// Files and folders of the upgrade, relative to the upgrade folder.
+const (
+	configFile = "../config.json"
+	eksDir     = ".."
+	addonsDir  = "../addons"
+)
+
+// defaultCheckNamespaces are checked when UpgradeCheckNamespaces is empty:
+// SonarQube and the sample application of the DevOps step.
+var defaultCheckNamespaces = []string{"sonarqube", "sonar-aws-javaapp-ns"}
+
+// Clients groups the external systems the upgrade talks to.
+type Clients struct {
+	EKS        EKSAPI
+	Kubernetes kubernetes.Interface
+	Dynamic    dynamic.Interface
+	// Metrics returns the metrics of the API server, for its count of the
+	// requests to deprecated APIs
+	Metrics  func(ctx context.Context) ([]byte, error)
+	Deployer Deployer
+}
+
+// Plan describes one upgrade run.
+type Plan struct {
+	ClusterName string
+	// ConfigVersion is the K8sVersion of config.json
+	ConfigVersion string
+	// To is the target version. When empty, it is the version of the
+	// cluster if a nodegroup is behind, resuming an interrupted upgrade, and
+	// the next minor version otherwise.
+	To string
+	// ConfigFile is rewritten with the target K8sVersion; EksDir and
+	// AddonsDir are deployed with cdk
+	ConfigFile string
+	EksDir     string
+	AddonsDir  string
+	// ClusterRole is the service role name and SecretsEncryption the Secrets
+	// encryption the EKS stack deploys, which must be those of the cluster
+	ClusterRole       string
+	SecretsEncryption bool
+	// CheckNamespaces must be healthy before and after each phase
+	CheckNamespaces []string
+	// DryRun stops after the checks; Force upgrades despite objects using
+	// APIs the target version removes
+	DryRun bool
+	Force  bool
+	// Timeout bounds each wait, PollInterval spaces the checks
+	Timeout      time.Duration
+	PollInterval time.Duration
+}
+
+// Run upgrades the cluster of plan, phase by phase. progress, when not nil,
+// is called after each completed step and with each warning. A phase
+// already done, e.g. by an interrupted run, is skipped.
+func Run(ctx context.Context, plan Plan, clients Clients, progress func(string)) error {
+	report := func(prefix, format string, args ...interface{}) {
+		if progress != nil {
+			progress(prefix + fmt.Sprintf(format, args...))
+		}
+	}
+	step := func(format string, args ...interface{}) { report("✅ ", format, args...) }
+	warn := func(format string, args ...interface{}) { report("⚠️  ", format, args...) }
+
+	/*---------------------------- Checks ---------------------------------*/
+	cluster, err := describeCluster(clients.EKS, plan.ClusterName)
+	if err != nil {
+		return err
+	}
+	if err := checkStackSettings(cluster, plan); err != nil {
+		return err
+	}
+	current := aws.StringValue(cluster.Version)
+	nodegroups, err := listNodegroups(clients.EKS, plan.ClusterName)
+	if err != nil {
+		return err
+	}
+	target := plan.To
+	if target == "" && nodegroupsBehind(nodegroups, current) {
+		// Resume the upgrade to the version of the control plane
+		target = current
+	}
+	to, err := targetVersion(current, target)
+	if err != nil {
+		return err
+	}
+	if _, err := k8sversions.Lookup(to); err != nil {
+		return err
+	}
+	if plan.ConfigVersion != current && plan.ConfigVersion != to {
+		return fmt.Errorf("K8sVersion of config.json is %s but the cluster runs %s: set it to %s", plan.ConfigVersion, current, current)
+	}
+
+	if current != to {
+		// The nodes can't be more than one minor version behind
+		for _, ng := range nodegroups {
+			if version := aws.StringValue(ng.Version); version != current {
+				return fmt.Errorf("nodegroup %s runs %s: upgrade it to %s first, with -to %s", aws.StringValue(ng.NodegroupName), version, current, current)
+			}
+		}
+	}
+
+	health := healthCheck(clients.Kubernetes, plan.CheckNamespaces)
+	if err := health(ctx); err != nil {
+		return fmt.Errorf("the cluster is not healthy, fix it before upgrading: %w", err)
+	}
+	step("Cluster %s runs %s and is healthy, upgrading to %s", plan.ClusterName, current, to)
+
+	if current != to {
+		findings, err := scanDeprecated(ctx, clients, current, to)
+		if err != nil {
+			return err
+		}
+		for _, finding := range findings {
+			warn("%s", finding)
+		}
+		switch {
+		case len(findings) == 0:
+			step("No use of the APIs removed in %s left", to)
+		case !plan.Force:
+			return fmt.Errorf("%d objects or clients use APIs removed in %s: migrate them, or run again with -force", len(findings), to)
+		}
+	}
+
+	if plan.DryRun {
+		step("Dry run: the control plane, the addons and %d nodegroups would be upgraded to %s", len(nodegroups), to)
+		return nil
+	}
+
+	/*------------------------- Control plane -----------------------------*/
+	if current != to {
+		// The EKS stack also moves to the kubectl layer and load balancer
+		// controller of the new version
+		if err := setK8sVersion(plan.ConfigFile, to); err != nil {
+			return err
+		}
+		if err := clients.Deployer.Deploy(plan.EksDir, nil); err != nil {
+			return fmt.Errorf("deploying the EKS stack: %w", err)
+		}
+		cluster, err := describeCluster(clients.EKS, plan.ClusterName)
+		if err != nil {
+			return err
+		}
+		if version := aws.StringValue(cluster.Version); version != to {
+			return fmt.Errorf("the EKS stack is deployed but the cluster runs %s, not %s", version, to)
+		}
+		if err := waitFor(ctx, health, plan); err != nil {
+			return fmt.Errorf("after the control plane upgrade: %w", err)
+		}
+		step("Control plane upgraded to %s", to)
+	} else if plan.ConfigVersion != to {
+		if err := setK8sVersion(plan.ConfigFile, to); err != nil {
+			return err
+		}
+	}
+
+	/*----------------------------- Addons --------------------------------*/
+	// The addons step resolves the versions compatible with the new
+	// K8sVersion again
+	err = clients.Deployer.Deploy(plan.AddonsDir, map[string]string{
+		"lifecycle":     "deploy",
+		"update-addons": "true",
+	})
+	if err != nil {
+		return fmt.Errorf("deploying the addons: %w", err)
+	}
+	if err := waitFor(ctx, addonsActive(clients.EKS, plan.ClusterName), plan); err != nil {
+		return err
+	}
+	if err := waitFor(ctx, health, plan); err != nil {
+		return fmt.Errorf("after the addons upgrade: %w", err)
+	}
+	step("Addons upgraded")
+
+	/*--------------------------- Nodegroups ------------------------------*/
+	for _, ng := range nodegroups {
+		name := aws.StringValue(ng.NodegroupName)
+		if aws.StringValue(ng.Version) == to {
+			continue
+		}
+		if err := upgradeNodegroup(ctx, clients.EKS, plan, name, to); err != nil {
+			return err
+		}
+		// A nodegroup scaled to zero has no node to check
+		if ng.ScalingConfig != nil && aws.Int64Value(ng.ScalingConfig.DesiredSize) > 0 {
+			if err := waitFor(ctx, nodegroupReady(clients.Kubernetes, name, to), plan); err != nil {
+				return err
+			}
+		}
+		if err := waitFor(ctx, health, plan); err != nil {
+			return fmt.Errorf("after the upgrade of nodegroup %s: %w", name, err)
+		}
+		step("Nodegroup %s upgraded", name)
+	}
+
+	step("Cluster %s upgraded to %s", plan.ClusterName, to)
+	return nil
+}
+
+// nodegroupsBehind reports whether a nodegroup doesn't run version yet.
+func nodegroupsBehind(nodegroups []*eks.Nodegroup, version string) bool {
+	for _, ng := range nodegroups {
+		if aws.StringValue(ng.Version) != version {
+			return true
+		}
+	}
+	return false
+}
+
+func describeCluster(api EKSAPI, name string) (*eks.Cluster, error) {
+	out, err := api.DescribeCluster(&eks.DescribeClusterInput{Name: aws.String(name)})
+	if err != nil {
+		return nil, fmt.Errorf("describing cluster %s: %w", name, err)
+	}
+	if status := aws.StringValue(out.Cluster.Status); status != eks.ClusterStatusActive {
+		return nil, fmt.Errorf("cluster %s is %s, not %s", name, status, eks.ClusterStatusActive)
+	}
+	return out.Cluster, nil
+}
+
+func GetConfig(configcrd ConfAuth, configjs Configuration) (ConfAuth, Configuration) {
+
+	fconfig, err := os.ReadFile(configFile)
+	if err != nil {
+		panic("❌ Problem with the configuration file : config.json")
+	}
+	if err := json.Unmarshal(fconfig, &configjs); err != nil {
+		fmt.Println("❌ Error unmarshaling JSON:", err)
+		os.Exit(1)
+	}
+
+	fconfig2, err := os.ReadFile("../../config_crd.json")
+	if err != nil {
+		panic("❌ Problem with the configuration file : config_crd.json")
+	}
+	if err := json.Unmarshal(fconfig2, &configcrd); err != nil {
+		fmt.Println("❌ Error unmarshaling JSON:", err)
+		os.Exit(1)
+	}
+	return configcrd, configjs
+}
+
+// Load Kubeconfig and create the kubernetes clients
+func kubeClients() (*kubernetes.Clientset, *dynamic.DynamicClient) {
+	kubeconfigPath := filepath.Join(os.Getenv("HOME"), ".kube", "config")
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	}
+	if err != nil {
+		log.Fatalf("❌ Failed to load kubeconfig: %v", err)
+	}
+	// A private endpoint is reached through the port-forward of EKS_API_TUNNEL
+	if err := kubetunnel.FromEnv(config); err != nil {
+		log.Fatalf("❌ Invalid API tunnel: %v", err)
+	}
+
+	dd, err := dynamic.NewForConfig(config)
+	if err != nil {
+		log.Fatalf("❌ Failed to create a dynamic client: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Fatalf("❌ Failed to create a ClientSet: %v. Exiting.", err)
+	}
+	return clientset, dd
+}
+
+func main() {
+	to := flag.String("to", "", "target Kubernetes version, the next minor version of the cluster by default, or its version to resume an upgrade")
+	dryRun := flag.Bool("dry-run", false, "only run the checks")
+	force := flag.Bool("force", false, "upgrade despite objects using APIs removed by the target version")
+	timeout := flag.Duration("timeout", 30*time.Minute, "how long to wait for each phase to settle")
+	flag.Parse()
+
+	var configcrd ConfAuth
+	var config1 Configuration
+	var AppConfig1, AppConfig = GetConfig(configcrd, config1)
+	if AppConfig.Cluster.Import != nil {
+		log.Fatalf("❌ %s is an imported cluster: upgrade it with the tooling that created it", AppConfig.Cluster.Import.ClusterName())
+	}
+	namespaces := AppConfig.UpgradeCheckNamespaces
+	if len(namespaces) == 0 {
+		namespaces = defaultCheckNamespaces
+	}
+
+	clusterRole := AppConfig.EksClusterRole
+	if clusterRole == "" {
+		clusterRole = AppConfig.EksAdminRole
+	}
+
+	clientset, dd := kubeClients()
+	err := Run(context.Background(), Plan{
+		ClusterName:       AppConfig.ClusterName + AppConfig1.Index,
+		ConfigVersion:     AppConfig.K8sVersion,
+		To:                *to,
+		ConfigFile:        configFile,
+		ClusterRole:       AppConfig.ClusterName + AppConfig1.Index + clusterRole,
+		SecretsEncryption: AppConfig.SecretsEncryption || AppConfig.SecretsKmsKeyArn != "",
+		EksDir:            eksDir,
+		AddonsDir:         addonsDir,
+		CheckNamespaces:   namespaces,
+		DryRun:            *dryRun,
+		Force:             *force,
+		Timeout:           *timeout,
+		PollInterval:      15 * time.Second,
+	}, Clients{
+		EKS:        eks.New(session.Must(session.NewSession()), aws.NewConfig().WithRegion(AppConfig1.Region)),
+		Kubernetes: clientset,
+		Dynamic:    dd,
+		Metrics: func(ctx context.Context) ([]byte, error) {
+			return clientset.Discovery().RESTClient().Get().AbsPath("/metrics").DoRaw(ctx)
+		},
+		Deployer: cdkDeployer{},
+	}, func(message string) {
+		fmt.Println(message)
+	})
+	if err != nil {
+		log.Fatalf("❌ %v\n", err)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eks"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeEKS is a cluster whose nodegroup upgrades complete at once, moving
+// the kubelet of their nodes.
+type fakeEKS struct {
+	cluster    *eks.Cluster
+	nodegroups []*eks.Nodegroup
+	addons     map[string]string
+	nodes      kubernetes.Interface
+	upgraded   []string
+}
+
+func (f *fakeEKS) DescribeCluster(*eks.DescribeClusterInput) (*eks.DescribeClusterOutput, error) {
+	return &eks.DescribeClusterOutput{Cluster: f.cluster}, nil
+}
+
+func (f *fakeEKS) ListNodegroups(*eks.ListNodegroupsInput) (*eks.ListNodegroupsOutput, error) {
+	out := &eks.ListNodegroupsOutput{}
+	for _, ng := range f.nodegroups {
+		out.Nodegroups = append(out.Nodegroups, ng.NodegroupName)
+	}
+	return out, nil
+}
+
+func (f *fakeEKS) DescribeNodegroup(in *eks.DescribeNodegroupInput) (*eks.DescribeNodegroupOutput, error) {
+	for _, ng := range f.nodegroups {
+		if aws.StringValue(ng.NodegroupName) == aws.StringValue(in.NodegroupName) {
+			return &eks.DescribeNodegroupOutput{Nodegroup: ng}, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+func (f *fakeEKS) UpdateNodegroupVersion(in *eks.UpdateNodegroupVersionInput) (*eks.UpdateNodegroupVersionOutput, error) {
+	name := aws.StringValue(in.NodegroupName)
+	f.upgraded = append(f.upgraded, name)
+	nodes, err := f.nodes.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{LabelSelector: nodegroupLabel + "=" + name})
+	if err != nil {
+		return nil, err
+	}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		node.Status.NodeInfo.KubeletVersion = "v" + aws.StringValue(in.Version) + ".4-eks-1"
+		if _, err := f.nodes.CoreV1().Nodes().Update(context.Background(), node, metav1.UpdateOptions{}); err != nil {
+			return nil, err
+		}
+	}
+	return &eks.UpdateNodegroupVersionOutput{Update: &eks.Update{Id: aws.String("update-" + name)}}, nil
+}
+
+func (f *fakeEKS) DescribeUpdate(*eks.DescribeUpdateInput) (*eks.DescribeUpdateOutput, error) {
+	return &eks.DescribeUpdateOutput{Update: &eks.Update{Status: aws.String(eks.UpdateStatusSuccessful)}}, nil
+}
+
+func (f *fakeEKS) ListAddons(*eks.ListAddonsInput) (*eks.ListAddonsOutput, error) {
+	out := &eks.ListAddonsOutput{}
+	for name := range f.addons {
+		out.Addons = append(out.Addons, aws.String(name))
+	}
+	return out, nil
+}
+
+func (f *fakeEKS) DescribeAddon(in *eks.DescribeAddonInput) (*eks.DescribeAddonOutput, error) {
+	return &eks.DescribeAddonOutput{Addon: &eks.Addon{
+		AddonName: in.AddonName,
+		Status:    aws.String(f.addons[aws.StringValue(in.AddonName)]),
+	}}, nil
+}
+
+// fakeDeployer moves the cluster to the K8sVersion of the config file when
+// the EKS stack is deployed.
+type fakeDeployer struct {
+	eks        *fakeEKS
+	configFile string
+	deployed   []string
+}
+
+var configVersion = regexp.MustCompile(`"K8sVersion"\s*:\s*"([^"]*)"`)
+
+func (d *fakeDeployer) Deploy(dir string, _ map[string]string) error {
+	d.deployed = append(d.deployed, dir)
+	if dir == "eks" {
+		content, err := os.ReadFile(d.configFile)
+		if err != nil {
+			return err
+		}
+		d.eks.cluster.Version = aws.String(string(configVersion.FindSubmatch(content)[1]))
+	}
+	return nil
+}
+
+func readyNode(name, nodegroup, kubelet string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{nodegroupLabel: nodegroup}},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			NodeInfo:   corev1.NodeSystemInfo{KubeletVersion: kubelet},
+		},
+	}
+}
+
+func dynamicClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	listKinds := make(map[schema.GroupVersionResource]string)
+	for _, api := range removedAPIs {
+		listKinds[api.ListWith] = api.Kind + "List"
+	}
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objects...)
+}
+
+// testUpgrade sets up a healthy cluster of version with two nodegroups, the
+// second one scaled to zero.
+func testUpgrade(t *testing.T, version string) (Plan, Clients, *fakeEKS, *fakeDeployer) {
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configFile, []byte("{\n  \"ClusterName\": \"sonar\",\n  \"K8sVersion\": \""+version+"\",\n  \"NodeType\": \"t3.large\"\n}\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kubelet := "v" + version + ".9-eks-1"
+	clientset := fake.NewSimpleClientset(
+		readyNode("node-1", "ng-1", kubelet),
+		readyNode("node-2", "ng-1", kubelet),
+	)
+	api := &fakeEKS{
+		cluster: &eks.Cluster{
+			Name:    aws.String("sonar1"),
+			Status:  aws.String(eks.ClusterStatusActive),
+			Version: aws.String(version),
+			RoleArn: aws.String("arn:aws:iam::123456789012:role/sonar1AdminRole"),
+		},
+		nodegroups: []*eks.Nodegroup{
+			{NodegroupName: aws.String("ng-1"), Version: aws.String(version), ScalingConfig: &eks.NodegroupScalingConfig{DesiredSize: aws.Int64(2)}},
+			{NodegroupName: aws.String("ng-2"), Version: aws.String(version), ScalingConfig: &eks.NodegroupScalingConfig{DesiredSize: aws.Int64(0)}},
+		},
+		addons: map[string]string{"vpc-cni": eks.AddonStatusActive, "coredns": eks.AddonStatusActive},
+		nodes:  clientset,
+	}
+	deployer := &fakeDeployer{eks: api, configFile: configFile}
+
+	plan := Plan{
+		ClusterName:     "sonar1",
+		ConfigVersion:   version,
+		ConfigFile:      configFile,
+		ClusterRole:     "sonar1AdminRole",
+		EksDir:          "eks",
+		AddonsDir:       "addons",
+		CheckNamespaces: defaultCheckNamespaces,
+		Timeout:         time.Second,
+		PollInterval:    time.Millisecond,
+	}
+	clients := Clients{
+		EKS:        api,
+		Kubernetes: clientset,
+		Dynamic:    dynamicClient(),
+		Metrics: func(context.Context) ([]byte, error) {
+			return nil, nil
+		},
+		Deployer: deployer,
+	}
+	return plan, clients, api, deployer
+}
+
+func TestRun(t *testing.T) {
+	plan, clients, api, deployer := testUpgrade(t, "1.27")
+
+	var messages []string
+	err := Run(context.Background(), plan, clients, func(message string) {
+		messages = append(messages, message)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := aws.StringValue(api.cluster.Version); got != "1.28" {
+		t.Errorf("got cluster version %s, want 1.28", got)
+	}
+	if got := strings.Join(deployer.deployed, ","); got != "eks,addons" {
+		t.Errorf("got deployments %s, want eks,addons", got)
+	}
+	if got := strings.Join(api.upgraded, ","); got != "ng-1,ng-2" {
+		t.Errorf("got nodegroup upgrades %s, want ng-1,ng-2", got)
+	}
+	content, err := os.ReadFile(plan.ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), `"K8sVersion": "1.28",`) || !strings.Contains(string(content), `"NodeType": "t3.large"`) {
+		t.Errorf("got config file %s", content)
+	}
+	if last := messages[len(messages)-1]; last != "✅ Cluster sonar1 upgraded to 1.28" {
+		t.Errorf("got last message %q", last)
+	}
+}
+
+func TestRunResume(t *testing.T) {
+	// Interrupted after the control plane upgrade
+	plan, clients, api, deployer := testUpgrade(t, "1.27")
+	api.cluster.Version = aws.String("1.28")
+	if err := setK8sVersion(plan.ConfigFile, "1.28"); err != nil {
+		t.Fatal(err)
+	}
+	plan.ConfigVersion = "1.28"
+
+	if err := Run(context.Background(), plan, clients, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(deployer.deployed, ","); got != "addons" {
+		t.Errorf("got deployments %s, want addons only", got)
+	}
+	if got := strings.Join(api.upgraded, ","); got != "ng-1,ng-2" {
+		t.Errorf("got nodegroup upgrades %s, want ng-1,ng-2", got)
+	}
+}
+
+func TestRunDeprecatedAPIs(t *testing.T) {
+	plan, clients, api, deployer := testUpgrade(t, "1.24")
+	clients.Dynamic = dynamicClient(&unstructuredPSP)
+
+	err := Run(context.Background(), plan, clients, nil)
+	if err == nil || !strings.Contains(err.Error(), "-force") {
+		t.Fatalf("got %v, want the upgrade stopped", err)
+	}
+	if len(deployer.deployed) != 0 || aws.StringValue(api.cluster.Version) != "1.24" {
+		t.Errorf("got deployments %v, cluster version %s", deployer.deployed, aws.StringValue(api.cluster.Version))
+	}
+
+	// -dry-run -force reports them but goes on
+	plan.Force, plan.DryRun = true, true
+	var messages []string
+	err = Run(context.Background(), plan, clients, func(message string) {
+		messages = append(messages, message)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(messages, "\n"); !strings.Contains(got, "⚠️  PodSecurityPolicy restricted uses policy/v1beta1, removed in 1.25") {
+		t.Errorf("got messages %s", got)
+	}
+	if len(deployer.deployed) != 0 {
+		t.Errorf("got deployments %v on a dry run", deployer.deployed)
+	}
+}
+
+func TestRunChecks(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Plan, *fakeEKS)
+		want   string
+	}{
+		{"jump", func(p *Plan, _ *fakeEKS) { p.To = "1.28" }, "one minor version at a time"},
+		{"config", func(p *Plan, _ *fakeEKS) { p.ConfigVersion = "1.25" }, "K8sVersion of config.json is 1.25"},
+		{"nodegroup behind", func(p *Plan, f *fakeEKS) { p.To, f.nodegroups[1].Version = "1.27", aws.String("1.25") }, "nodegroup ng-2 runs 1.25"},
+		{"cluster role", func(p *Plan, _ *fakeEKS) { p.ClusterRole = "sonar1ClusterRole" }, "set EksClusterRole to AdminRole"},
+		{"encryption", func(p *Plan, _ *fakeEKS) { p.SecretsEncryption = true }, "set SecretsEncryption to false"},
+		{"not active", func(_ *Plan, f *fakeEKS) { f.cluster.Status = aws.String(eks.ClusterStatusUpdating) }, "is UPDATING"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, clients, api, _ := testUpgrade(t, "1.26")
+			tt.modify(&plan, api)
+			if err := Run(context.Background(), plan, clients, nil); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunUnhealthy(t *testing.T) {
+	plan, clients, _, deployer := testUpgrade(t, "1.27")
+	node, err := clients.Kubernetes.CoreV1().Nodes().Get(context.Background(), "node-2", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.Status.Conditions[0].Status = corev1.ConditionFalse
+	if _, err := clients.Kubernetes.CoreV1().Nodes().Update(context.Background(), node, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	err = Run(context.Background(), plan, clients, nil)
+	if err == nil || !strings.Contains(err.Error(), "nodes not ready: node-2") {
+		t.Errorf("got %v", err)
+	}
+	if len(deployer.deployed) != 0 {
+		t.Errorf("got deployments %v", deployer.deployed)
+	}
+}
+
+func TestTargetVersion(t *testing.T) {
+	tests := []struct {
+		current, to, want string
+		wantErr           bool
+	}{
+		{"1.27", "", "1.28", false},
+		{"1.27", "1.28", "1.28", false},
+		{"1.28", "1.28", "1.28", false},
+		{"1.27", "1.26", "", true},
+		{"1.26", "1.28", "", true},
+		{"1.27", "1.28.1", "", true},
+		{"2.0", "", "", true},
+	}
+	for _, tt := range tests {
+		got, err := targetVersion(tt.current, tt.to)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("targetVersion(%q, %q) = %q, %v", tt.current, tt.to, got, err)
+		}
+	}
+}
+
+func TestSetK8sVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"K8sVersion": "1.27", "NodeVolumeType": "gp3"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := setK8sVersion(path, "1.28"); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(path)
+	if got := string(content); got != `{"K8sVersion": "1.28", "NodeVolumeType": "gp3"}` {
+		t.Errorf("got %s", got)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"ClusterName": "sonar"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := setK8sVersion(path, "1.28"); err == nil {
+		t.Error("got no error without K8sVersion")
+	}
+}
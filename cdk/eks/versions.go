@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+
+	"CDK/pkg/k8sversions"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseks"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awslambda"
+	"github.com/aws/constructs-go/constructs/v10"
+	"github.com/aws/jsii-runtime-go"
+	kubectlv27 "github.com/cdklabs/awscdk-kubectl-go/kubectlv27/v2"
+)
+
+// kubectlLayers creates the kubectl layers the stack bundles, by
+// KubectlLayer version of the matrix. The other versions need the
+// KubectlLayerArn of a layer published beforehand.
+var kubectlLayers = map[string]func(scope constructs.Construct, id *string) awslambda.ILayerVersion{
+	"1.27": func(scope constructs.Construct, id *string) awslambda.ILayerVersion {
+		return kubectlv27.NewKubectlV27Layer(scope, id)
+	},
+}
+
+// albControllers are the AWS Load Balancer Controller versions of the
+// matrix, among those the CDK has the IAM policy of.
+var albControllers = map[string]func() awseks.AlbControllerVersion{
+	"v2.5.1": awseks.AlbControllerVersion_V2_5_1,
+}
+
+// clusterVersions returns the kubectl layer and the AWS Load Balancer
+// Controller version of the cluster, for the K8sVersion of AppConfig. The
+// layer is the one of KubectlLayerArn when set.
+func clusterVersions(scope constructs.Construct, AppConfig Configuration) (awslambda.ILayerVersion, awseks.AlbControllerVersion, error) {
+	release, err := k8sversions.Lookup(AppConfig.K8sVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	newLayer, ok := kubectlLayers[release.KubectlLayer]
+	if AppConfig.KubectlLayerArn != "" {
+		newLayer = func(scope constructs.Construct, id *string) awslambda.ILayerVersion {
+			return awslambda.LayerVersion_FromLayerVersionArn(scope, id, &AppConfig.KubectlLayerArn)
+		}
+	} else if !ok {
+		return nil, nil, fmt.Errorf("no kubectl layer %s bundled for Kubernetes %s: set KubectlLayerArn to a kubectl %s layer", release.KubectlLayer, release.Kubernetes, release.KubectlLayer)
+	}
+	albController, ok := albControllers[release.AlbController]
+	if !ok {
+		return nil, nil, fmt.Errorf("no AWS Load Balancer Controller %s for Kubernetes %s", release.AlbController, release.Kubernetes)
+	}
+	// The layer ID keeps its kubectl1<minor>layer form, e.g. kubectl127layer
+	id := "kubectl1" + release.KubectlLayer[len("1."):] + "layer"
+	return newLayer(scope, jsii.String(id)), albController(), nil
+}
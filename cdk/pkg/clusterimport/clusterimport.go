@@ -0,0 +1,208 @@
+// Package clusterimport describes an EKS cluster created outside of the
+// tutorial, so that the addons and DevOps steps can work with it instead of
+// the cluster of the EKS stack.
+//
+// The Cluster.Import block of config.json gives what those steps would
+// otherwise derive from ClusterName, Index and EksAdminRole. Check makes
+// sure the cluster is reachable and uses the access model they rely on:
+// the aws-auth ConfigMap, with the kubectl role in system:masters.
+package clusterimport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"gopkg.in/yaml.v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	awsAuthNamespace = "kube-system"
+	awsAuthName      = "aws-auth"
+	mastersGroup     = "system:masters"
+)
+
+// Settings is the Cluster block of config.json.
+type Settings struct {
+	// Import, when set, is the existing cluster the later steps use
+	Import *Import
+}
+
+// Import is an existing EKS cluster.
+type Import struct {
+	// Cluster is the name or the ARN of the cluster.
+	Cluster string
+	// OidcProviderArn is the IAM OIDC provider of the cluster, for the IRSA
+	// roles. Not needed with EKS Pod Identity.
+	OidcProviderArn string
+	// KubectlRoleArn is the role mapped to system:masters in aws-auth, that
+	// the build role assumes to deploy the sample application.
+	KubectlRoleArn string
+	// SecurityGroupId is a security group of the cluster, e.g. its cluster
+	// security group. Optional.
+	SecurityGroupId string
+}
+
+// ClusterName returns the name of the cluster, also when Cluster is an ARN.
+func (i Import) ClusterName() string {
+	if strings.HasPrefix(i.Cluster, "arn:") {
+		return i.Cluster[strings.LastIndex(i.Cluster, "/")+1:]
+	}
+	return i.Cluster
+}
+
+// KubectlRoleName returns the name of the kubectl role.
+func (i Import) KubectlRoleName() string {
+	return i.KubectlRoleArn[strings.LastIndex(i.KubectlRoleArn, "/")+1:]
+}
+
+// Validate checks the settings without calling AWS. needOidc requires the
+// OIDC provider, for the IRSA roles.
+func (i Import) Validate(needOidc bool) error {
+	if i.Cluster == "" {
+		return errors.New("Cluster must be the name or the ARN of the cluster")
+	}
+	if strings.HasPrefix(i.Cluster, "arn:") && !strings.Contains(i.Cluster, ":cluster/") {
+		return fmt.Errorf("Cluster %q is not the ARN of an EKS cluster", i.Cluster)
+	}
+	if i.OidcProviderArn == "" {
+		if needOidc {
+			return errors.New("OidcProviderArn is required for the IRSA roles, unless PodIdentity is set")
+		}
+	} else if !strings.HasPrefix(i.OidcProviderArn, "arn:") || !strings.Contains(i.OidcProviderArn, ":oidc-provider/") {
+		return fmt.Errorf("OidcProviderArn %q is not the ARN of an IAM OIDC provider", i.OidcProviderArn)
+	}
+	// aws-auth and the buildspec name the role without its path
+	if !strings.HasPrefix(i.KubectlRoleArn, "arn:") || !strings.Contains(i.KubectlRoleArn, ":role/") {
+		return fmt.Errorf("KubectlRoleArn %q is not the ARN of an IAM role", i.KubectlRoleArn)
+	}
+	if strings.Count(i.KubectlRoleArn[strings.Index(i.KubectlRoleArn, ":role/"):], "/") > 1 {
+		return fmt.Errorf("KubectlRoleArn %q has a path, aws-auth only matches roles without one", i.KubectlRoleArn)
+	}
+	if i.SecurityGroupId != "" && !strings.HasPrefix(i.SecurityGroupId, "sg-") {
+		return fmt.Errorf("SecurityGroupId %q is not a security group ID", i.SecurityGroupId)
+	}
+	return nil
+}
+
+// EKSAPI is the subset of the EKS client used to describe the cluster.
+// *eks.EKS satisfies it.
+type EKSAPI interface {
+	DescribeCluster(*eks.DescribeClusterInput) (*eks.DescribeClusterOutput, error)
+}
+
+// Check makes sure the imported cluster exists and is active, matches the
+// OIDC provider and security group of i, is the cluster client talks to,
+// and maps the kubectl role to system:masters in aws-auth. host is the API
+// endpoint of client, e.g. the TLS server name of a tunnel; it is not
+// compared when empty.
+func Check(ctx context.Context, i Import, api EKSAPI, client kubernetes.Interface, host string) error {
+	name := i.ClusterName()
+	out, err := api.DescribeCluster(&eks.DescribeClusterInput{Name: aws.String(name)})
+	if err != nil {
+		return fmt.Errorf("describing cluster %s: %w", name, err)
+	}
+	cluster := out.Cluster
+	if status := aws.StringValue(cluster.Status); status != eks.ClusterStatusActive {
+		return fmt.Errorf("cluster %s is %s, not %s", name, status, eks.ClusterStatusActive)
+	}
+
+	if i.OidcProviderArn != "" {
+		var issuer string
+		if cluster.Identity != nil && cluster.Identity.Oidc != nil {
+			issuer = strings.TrimPrefix(aws.StringValue(cluster.Identity.Oidc.Issuer), "https://")
+		}
+		provider := i.OidcProviderArn[strings.Index(i.OidcProviderArn, ":oidc-provider/")+len(":oidc-provider/"):]
+		if issuer == "" || provider != issuer {
+			return fmt.Errorf("OidcProviderArn %s is not the provider of cluster %s, whose issuer is %q", i.OidcProviderArn, name, issuer)
+		}
+	}
+
+	if i.SecurityGroupId != "" && !hasSecurityGroup(cluster, i.SecurityGroupId) {
+		return fmt.Errorf("security group %s is not attached to cluster %s", i.SecurityGroupId, name)
+	}
+
+	if host != "" {
+		endpoint, err := url.Parse(aws.StringValue(cluster.Endpoint))
+		if err != nil {
+			return fmt.Errorf("parsing the endpoint of cluster %s: %w", name, err)
+		}
+		if !strings.EqualFold(endpoint.Hostname(), host) {
+			return fmt.Errorf("the kubeconfig points to %s, not to cluster %s: run aws eks update-kubeconfig --name %s", host, name, name)
+		}
+	}
+
+	if _, err := client.Discovery().ServerVersion(); err != nil {
+		return fmt.Errorf("cluster %s is not reachable: %w", name, err)
+	}
+	return checkAwsAuth(ctx, client, i.KubectlRoleArn)
+}
+
+// Host returns the API endpoint host name config talks to, the TLS server
+// name when it goes through a tunnel.
+func Host(config *rest.Config) string {
+	if config.TLSClientConfig.ServerName != "" {
+		return config.TLSClientConfig.ServerName
+	}
+	endpoint, err := url.Parse(config.Host)
+	if err != nil {
+		return ""
+	}
+	return endpoint.Hostname()
+}
+
+func hasSecurityGroup(cluster *eks.Cluster, id string) bool {
+	config := cluster.ResourcesVpcConfig
+	if config == nil {
+		return false
+	}
+	if aws.StringValue(config.ClusterSecurityGroupId) == id {
+		return true
+	}
+	for _, group := range config.SecurityGroupIds {
+		if aws.StringValue(group) == id {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAwsAuth makes sure aws-auth maps roleArn to system:masters. The DevOps
+// step adds the build role to it, so a cluster managed by access entries
+// only can't be used.
+func checkAwsAuth(ctx context.Context, client kubernetes.Interface, roleArn string) error {
+	configMap, err := client.CoreV1().ConfigMaps(awsAuthNamespace).Get(ctx, awsAuthName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("%s/%s not found: the cluster must authenticate IAM roles with the aws-auth ConfigMap", awsAuthNamespace, awsAuthName)
+	}
+	if err != nil {
+		return fmt.Errorf("getting %s/%s: %w", awsAuthNamespace, awsAuthName, err)
+	}
+
+	var entries []struct {
+		RoleARN string   `yaml:"rolearn"`
+		Groups  []string `yaml:"groups"`
+	}
+	if err := yaml.Unmarshal([]byte(configMap.Data["mapRoles"]), &entries); err != nil {
+		return fmt.Errorf("parsing the mapRoles of %s/%s: %w", awsAuthNamespace, awsAuthName, err)
+	}
+	for _, entry := range entries {
+		if entry.RoleARN != roleArn {
+			continue
+		}
+		for _, group := range entry.Groups {
+			if group == mastersGroup {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("KubectlRoleArn %s is not mapped to %s in %s/%s", roleArn, mastersGroup, awsAuthNamespace, awsAuthName)
+}
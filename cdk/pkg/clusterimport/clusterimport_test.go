@@ -0,0 +1,201 @@
+package clusterimport
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/eks"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	clusterArn      = "arn:aws:eks:eu-central-1:123456789012:cluster/platform"
+	issuer          = "oidc.eks.eu-central-1.amazonaws.com/id/0123456789ABCDEF"
+	oidcProviderArn = "arn:aws:iam::123456789012:oidc-provider/" + issuer
+	kubectlRoleArn  = "arn:aws:iam::123456789012:role/PlatformAdmin"
+	endpointHost    = "0123456789ABCDEF.gr7.eu-central-1.eks.amazonaws.com"
+)
+
+func testImport() Import {
+	return Import{
+		Cluster:         clusterArn,
+		OidcProviderArn: oidcProviderArn,
+		KubectlRoleArn:  kubectlRoleArn,
+		SecurityGroupId: "sg-0123456789abcdef0",
+	}
+}
+
+// fakeEKS describes a single cluster.
+type fakeEKS struct {
+	cluster *eks.Cluster
+}
+
+func (f *fakeEKS) DescribeCluster(in *eks.DescribeClusterInput) (*eks.DescribeClusterOutput, error) {
+	if f.cluster == nil || aws.StringValue(in.Name) != aws.StringValue(f.cluster.Name) {
+		return nil, awserr.New(eks.ErrCodeResourceNotFoundException, "No cluster found", nil)
+	}
+	return &eks.DescribeClusterOutput{Cluster: f.cluster}, nil
+}
+
+func activeCluster() *eks.Cluster {
+	return &eks.Cluster{
+		Name:     aws.String("platform"),
+		Status:   aws.String(eks.ClusterStatusActive),
+		Endpoint: aws.String("https://" + endpointHost),
+		Identity: &eks.Identity{Oidc: &eks.OIDC{Issuer: aws.String("https://" + issuer)}},
+		ResourcesVpcConfig: &eks.VpcConfigResponse{
+			ClusterSecurityGroupId: aws.String("sg-0123456789abcdef0"),
+		},
+	}
+}
+
+func awsAuth(mapRoles string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: awsAuthNamespace, Name: awsAuthName},
+		Data:       map[string]string{"mapRoles": mapRoles},
+	}
+}
+
+const mastersMapRoles = `- rolearn: arn:aws:iam::123456789012:role/NodeInstanceRole
+  username: system:node:{{EC2PrivateDNSName}}
+  groups:
+    - system:bootstrappers
+    - system:nodes
+- rolearn: arn:aws:iam::123456789012:role/PlatformAdmin
+  username: admin
+  groups:
+    - system:masters
+`
+
+func TestNames(t *testing.T) {
+	i := testImport()
+	if got := i.ClusterName(); got != "platform" {
+		t.Errorf("ClusterName() = %q", got)
+	}
+	i.Cluster = "platform"
+	if got := i.ClusterName(); got != "platform" {
+		t.Errorf("ClusterName() = %q", got)
+	}
+	if got := i.KubectlRoleName(); got != "PlatformAdmin" {
+		t.Errorf("KubectlRoleName() = %q", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := testImport().Validate(true); err != nil {
+		t.Fatal(err)
+	}
+	podIdentity := testImport()
+	podIdentity.OidcProviderArn = ""
+	if err := podIdentity.Validate(false); err != nil {
+		t.Errorf("no OIDC provider with Pod Identity: %v", err)
+	}
+
+	for key, change := range map[string]func(*Import){
+		"no cluster":      func(i *Import) { i.Cluster = "" },
+		"cluster arn":     func(i *Import) { i.Cluster = "arn:aws:eks:eu-central-1:123456789012:nodegroup/platform" },
+		"no oidc":         func(i *Import) { i.OidcProviderArn = "" },
+		"oidc arn":        func(i *Import) { i.OidcProviderArn = issuer },
+		"no kubectl role": func(i *Import) { i.KubectlRoleArn = "" },
+		"role path":       func(i *Import) { i.KubectlRoleArn = "arn:aws:iam::123456789012:role/admins/PlatformAdmin" },
+		"security group":  func(i *Import) { i.SecurityGroupId = "0123456789abcdef0" },
+	} {
+		i := testImport()
+		change(&i)
+		if err := i.Validate(true); err == nil {
+			t.Errorf("%s: no error", key)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	client := fake.NewSimpleClientset(awsAuth(mastersMapRoles))
+	if err := Check(context.Background(), testImport(), &fakeEKS{activeCluster()}, client, endpointHost); err != nil {
+		t.Fatal(err)
+	}
+	// The host is only compared when known
+	if err := Check(context.Background(), testImport(), &fakeEKS{activeCluster()}, client, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckFails(t *testing.T) {
+	for key, test := range map[string]struct {
+		cluster  func(*eks.Cluster)
+		mapRoles string
+		host     string
+		want     string
+	}{
+		"missing": {
+			cluster: func(c *eks.Cluster) { c.Name = aws.String("other") },
+			want:    "describing cluster platform",
+		},
+		"creating": {
+			cluster: func(c *eks.Cluster) { c.Status = aws.String(eks.ClusterStatusCreating) },
+			want:    "is CREATING",
+		},
+		"oidc": {
+			cluster: func(c *eks.Cluster) {
+				c.Identity.Oidc.Issuer = aws.String("https://oidc.eks.eu-central-1.amazonaws.com/id/OTHER")
+			},
+			want: "is not the provider",
+		},
+		"security group": {
+			cluster: func(c *eks.Cluster) { c.ResourcesVpcConfig.ClusterSecurityGroupId = aws.String("sg-other") },
+			want:    "not attached",
+		},
+		"kubeconfig": {
+			host: "FEDCBA9876543210.gr7.eu-central-1.eks.amazonaws.com",
+			want: "update-kubeconfig",
+		},
+		"not mapped": {
+			mapRoles: "- rolearn: arn:aws:iam::123456789012:role/PlatformAdmin\n  username: viewer\n  groups:\n    - viewers\n",
+			want:     "not mapped to system:masters",
+		},
+	} {
+		cluster := activeCluster()
+		if test.cluster != nil {
+			test.cluster(cluster)
+		}
+		mapRoles := mastersMapRoles
+		if test.mapRoles != "" {
+			mapRoles = test.mapRoles
+		}
+		host := endpointHost
+		if test.host != "" {
+			host = test.host
+		}
+		client := fake.NewSimpleClientset(awsAuth(mapRoles))
+
+		err := Check(context.Background(), testImport(), &fakeEKS{cluster}, client, host)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: error %v, want %q", key, err, test.want)
+		}
+	}
+}
+
+func TestCheckWithoutAwsAuth(t *testing.T) {
+	err := Check(context.Background(), testImport(), &fakeEKS{activeCluster()}, fake.NewSimpleClientset(), endpointHost)
+	if err == nil || !strings.Contains(err.Error(), "aws-auth ConfigMap") {
+		t.Errorf("error %v, want the aws-auth access model", err)
+	}
+}
+
+func TestHost(t *testing.T) {
+	config := &rest.Config{Host: "https://" + endpointHost}
+	if got := Host(config); got != endpointHost {
+		t.Errorf("Host() = %q", got)
+	}
+	// Through a tunnel, the endpoint is the TLS server name
+	config = &rest.Config{Host: "https://localhost:8443"}
+	config.TLSClientConfig.ServerName = endpointHost
+	if got := Host(config); got != endpointHost {
+		t.Errorf("Host() through a tunnel = %q", got)
+	}
+}
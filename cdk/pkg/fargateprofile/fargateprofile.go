@@ -0,0 +1,65 @@
+// Package fargateprofile describes the Fargate profiles of the EKS stack.
+//
+// The EKS stack creates them from config.json, and the addons stage reads
+// the same settings to find the pods they move to Fargate.
+package fargateprofile
+
+// Selector selects the pods a Fargate profile runs: those of Namespace with
+// all of Labels.
+type Selector struct {
+	Namespace string
+	Labels    map[string]string
+}
+
+// Matches reports whether the selector takes the pods of namespace with
+// labels.
+func (s Selector) Matches(namespace string, labels map[string]string) bool {
+	if s.Namespace != namespace {
+		return false
+	}
+	for key, value := range s.Labels {
+		if got, ok := labels[key]; !ok || got != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Profile runs the pods of its selectors on Fargate rather than on the
+// nodegroups.
+type Profile struct {
+	// Name of the profile, e.g. sample-app
+	Name      string
+	Selectors []Selector
+	// Private subnets of the pods, those of the VPC when empty
+	SubnetIds []string
+	// Role the Fargate infrastructure pulls images and writes logs with,
+	// created with the profile when empty
+	PodExecutionRoleArn string
+}
+
+// Selects reports whether one of profiles takes the pods of namespace with
+// labels.
+func Selects(profiles []Profile, namespace string, labels map[string]string) bool {
+	for _, profile := range profiles {
+		for _, selector := range profile.Selectors {
+			if selector.Matches(namespace, labels) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SelectsNamespace reports whether one of profiles takes all the pods of
+// namespace, with a selector without Labels.
+func SelectsNamespace(profiles []Profile, namespace string) bool {
+	for _, profile := range profiles {
+		for _, selector := range profile.Selectors {
+			if selector.Namespace == namespace && len(selector.Labels) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
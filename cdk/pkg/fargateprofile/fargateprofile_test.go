@@ -0,0 +1,40 @@
+package fargateprofile
+
+import "testing"
+
+func TestSelects(t *testing.T) {
+	profiles := []Profile{
+		{Name: "sample-app", Selectors: []Selector{{Namespace: "sonar-aws-javaapp-ns"}}},
+		{Name: "builds", Selectors: []Selector{{Namespace: "builds", Labels: map[string]string{"runner": "fargate"}}}},
+	}
+	tests := []struct {
+		namespace string
+		labels    map[string]string
+		want      bool
+	}{
+		{"sonar-aws-javaapp-ns", nil, true},
+		{"sonar-aws-javaapp-ns", map[string]string{"app": "web"}, true},
+		{"builds", map[string]string{"runner": "fargate", "app": "job"}, true},
+		{"builds", map[string]string{"runner": "nodes"}, false},
+		{"builds", nil, false},
+		{"sonarqube", nil, false},
+	}
+	for _, tt := range tests {
+		if got := Selects(profiles, tt.namespace, tt.labels); got != tt.want {
+			t.Errorf("%s %v: got %v, want %v", tt.namespace, tt.labels, got, tt.want)
+		}
+	}
+}
+
+func TestSelectsNamespace(t *testing.T) {
+	profiles := []Profile{
+		{Name: "sample-app", Selectors: []Selector{{Namespace: "sonar-aws-javaapp-ns"}}},
+		{Name: "builds", Selectors: []Selector{{Namespace: "builds", Labels: map[string]string{"runner": "fargate"}}}},
+	}
+	if !SelectsNamespace(profiles, "sonar-aws-javaapp-ns") {
+		t.Error("sonar-aws-javaapp-ns not selected")
+	}
+	if SelectsNamespace(profiles, "builds") {
+		t.Error("builds selected without its labels")
+	}
+}
@@ -0,0 +1,3 @@
+module CDK/pkg/fargateprofile
+
+go 1.21.1
@@ -0,0 +1,116 @@
+// Package irsa builds IAM roles for Kubernetes service accounts (IRSA).
+//
+// The role trusts the cluster's OIDC provider for one namespace and service
+// account. The provider may be imported from a token, e.g. an
+// Fn::ImportValue, so synth never calls AWS.
+package irsa
+
+import (
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseks"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/constructs-go/constructs/v10"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// RoleArnAnnotation is the ServiceAccount annotation naming the IAM role
+// its pods assume.
+const RoleArnAnnotation = "eks.amazonaws.com/role-arn"
+
+// ServiceAccountRoleProps configures a ServiceAccountRole.
+type ServiceAccountRoleProps struct {
+	// OidcProvider is the IAM OIDC provider of the cluster.
+	OidcProvider awsiam.IOpenIdConnectProvider
+	// Namespace and ServiceAccount name the Kubernetes service account
+	// allowed to assume the role.
+	Namespace      string
+	ServiceAccount string
+
+	// RoleName is the IAM role name. CloudFormation generates one when nil.
+	RoleName *string
+	// ManagedPolicies are attached to the role.
+	ManagedPolicies []awsiam.IManagedPolicy
+	// InlinePolicies are added to the role, by policy name.
+	InlinePolicies map[string]awsiam.PolicyDocument
+
+	// Cluster, when set, gets the ServiceAccount, annotated with the role
+	// ARN, through its kubectl provider.
+	Cluster awseks.ICluster
+}
+
+// ServiceAccountRole is an IAM role assumable by one Kubernetes service
+// account through the cluster's OIDC provider.
+type ServiceAccountRole struct {
+	// Construct scopes the role and its resources, e.g. for dependencies.
+	Construct constructs.Construct
+	Role      awsiam.Role
+	// Manifest is the annotated ServiceAccount, nil without a Cluster.
+	Manifest awseks.KubernetesManifest
+}
+
+// NewServiceAccountRole creates the role and, with a Cluster, the
+// annotated ServiceAccount.
+func NewServiceAccountRole(scope constructs.Construct, id string, props *ServiceAccountRoleProps) *ServiceAccountRole {
+	construct := constructs.NewConstruct(scope, &id)
+
+	// The issuer is only known at deploy time, and CloudFormation can't use
+	// a token as a JSON key, so the conditions are resolved by CfnJson
+	issuer := *props.OidcProvider.OpenIdConnectProviderIssuer()
+	conditions := awscdk.NewCfnJson(construct, jsii.String("Conditions"), &awscdk.CfnJsonProps{
+		Value: map[string]interface{}{
+			issuer + ":aud": "sts.amazonaws.com",
+			issuer + ":sub": Subject(props.Namespace, props.ServiceAccount),
+		},
+	})
+
+	role := awsiam.NewRole(construct, jsii.String("Role"), &awsiam.RoleProps{
+		RoleName: props.RoleName,
+		AssumedBy: awsiam.NewOpenIdConnectPrincipal(props.OidcProvider, &map[string]interface{}{
+			"StringEquals": conditions,
+		}),
+		ManagedPolicies: &props.ManagedPolicies,
+		InlinePolicies:  InlinePolicies(props.InlinePolicies),
+	})
+
+	sa := &ServiceAccountRole{Construct: construct, Role: role}
+	if props.Cluster != nil {
+		sa.Manifest = awseks.NewKubernetesManifest(construct, jsii.String("ServiceAccount"), &awseks.KubernetesManifestProps{
+			Cluster:   props.Cluster,
+			Overwrite: jsii.Bool(true),
+			Manifest: &[]*map[string]interface{}{
+				ServiceAccountManifest(props.Namespace, props.ServiceAccount, role.RoleArn()),
+			},
+		})
+	}
+	return sa
+}
+
+// Subject is the OIDC subject of a Kubernetes service account.
+func Subject(namespace, serviceAccount string) string {
+	return "system:serviceaccount:" + namespace + ":" + serviceAccount
+}
+
+// ServiceAccountManifest returns a ServiceAccount annotated with roleArn.
+func ServiceAccountManifest(namespace, serviceAccount string, roleArn *string) *map[string]interface{} {
+	return &map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ServiceAccount",
+		"metadata": map[string]interface{}{
+			"name":      serviceAccount,
+			"namespace": namespace,
+			"annotations": map[string]interface{}{
+				RoleArnAnnotation: roleArn,
+			},
+		},
+	}
+}
+
+// InlinePolicies returns policies for awsiam.RoleProps, nil when there are
+// none so that the role has no empty Policies property. The Pod Identity
+// roles use it too.
+func InlinePolicies(policies map[string]awsiam.PolicyDocument) *map[string]awsiam.PolicyDocument {
+	if len(policies) == 0 {
+		return nil
+	}
+	return &policies
+}
@@ -0,0 +1,117 @@
+package irsa
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseks"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/jsii-runtime-go"
+)
+
+func newTestStack() awscdk.Stack {
+	app := awscdk.NewApp(nil)
+	return awscdk.NewStack(app, jsii.String("TestStack"), &awscdk.StackProps{
+		Env: &awscdk.Environment{Account: jsii.String("123456789012"), Region: jsii.String("eu-central-1")},
+	})
+}
+
+func importedProvider(stack awscdk.Stack) awsiam.IOpenIdConnectProvider {
+	return awsiam.OpenIdConnectProvider_FromOpenIdConnectProviderArn(stack, jsii.String("Oidc"),
+		awscdk.Fn_ImportValue(jsii.String("EksStack02-OidcProviderArn")))
+}
+
+func TestServiceAccountRole(t *testing.T) {
+	stack := newTestStack()
+
+	NewServiceAccountRole(stack, "Reports", &ServiceAccountRoleProps{
+		OidcProvider:   importedProvider(stack),
+		Namespace:      "sonarqube",
+		ServiceAccount: "reports",
+		RoleName:       jsii.String("SonarReports"),
+		ManagedPolicies: []awsiam.IManagedPolicy{
+			awsiam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("AmazonSQSReadOnlyAccess")),
+		},
+		InlinePolicies: map[string]awsiam.PolicyDocument{
+			"Reports": awsiam.NewPolicyDocument(&awsiam.PolicyDocumentProps{
+				Statements: &[]awsiam.PolicyStatement{
+					awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+						Actions:   jsii.Strings("s3:GetObject"),
+						Resources: jsii.Strings("arn:aws:s3:::reports/*"),
+					}),
+				},
+			}),
+		},
+	})
+
+	template := assertions.Template_FromStack(stack, nil)
+	template.ResourceCountIs(jsii.String("Custom::AWSCDK-EKS-KubernetesResource"), jsii.Number(0))
+
+	template.HasResourceProperties(jsii.String("AWS::IAM::Role"), map[string]interface{}{
+		"RoleName": "SonarReports",
+		"AssumeRolePolicyDocument": map[string]interface{}{
+			"Statement": []interface{}{
+				map[string]interface{}{
+					"Action": "sts:AssumeRoleWithWebIdentity",
+					"Effect": "Allow",
+					"Principal": map[string]interface{}{
+						"Federated": map[string]interface{}{"Fn::ImportValue": "EksStack02-OidcProviderArn"},
+					},
+					"Condition": map[string]interface{}{
+						"StringEquals": map[string]interface{}{
+							"Fn::GetAtt": assertions.Match_AnyValue(),
+						},
+					},
+				},
+			},
+			"Version": "2012-10-17",
+		},
+		"Policies": []interface{}{
+			map[string]interface{}{
+				"PolicyName": "Reports",
+				"PolicyDocument": assertions.Match_ObjectLike(&map[string]interface{}{
+					"Statement": []interface{}{
+						map[string]interface{}{
+							"Action":   "s3:GetObject",
+							"Effect":   "Allow",
+							"Resource": "arn:aws:s3:::reports/*",
+						},
+					},
+				}),
+			},
+		},
+	})
+
+	// The conditions, resolved by CfnJson, name the service account
+	body, err := json.Marshal(template.FindResources(jsii.String("Custom::AWSCDKCfnJson"), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), ":sub\\\":\\\"system:serviceaccount:sonarqube:reports") {
+		t.Errorf("no condition on system:serviceaccount:sonarqube:reports in %s", body)
+	}
+}
+
+func TestServiceAccountRoleAnnotatesServiceAccount(t *testing.T) {
+	stack := newTestStack()
+	cluster := awseks.Cluster_FromClusterAttributes(stack, jsii.String("Cluster"), &awseks.ClusterAttributes{
+		ClusterName:    jsii.String("SonarAWSTuto02"),
+		KubectlRoleArn: jsii.String("arn:aws:iam::123456789012:role/SonarAWSTuto02AdminRole"),
+	})
+
+	NewServiceAccountRole(stack, "Reports", &ServiceAccountRoleProps{
+		OidcProvider:   importedProvider(stack),
+		Namespace:      "sonarqube",
+		ServiceAccount: "reports",
+		Cluster:        cluster,
+	})
+
+	template := assertions.Template_FromStack(stack, nil)
+	template.HasResourceProperties(jsii.String("Custom::AWSCDK-EKS-KubernetesResource"), map[string]interface{}{
+		"Overwrite": true,
+		"Manifest":  assertions.Match_AnyValue(),
+	})
+}
@@ -0,0 +1,5 @@
+module CDK/pkg/k8sversions
+
+go 1.21.1
+
+require github.com/Masterminds/semver/v3 v3.2.1
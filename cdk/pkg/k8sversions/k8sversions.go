@@ -0,0 +1,114 @@
+// Package k8sversions is the compatibility matrix of the Kubernetes versions
+// the stacks support.
+//
+// Each supported version maps to the kubectl layer the EKS stack installs
+// for its Helm and manifest handlers, the AWS Load Balancer Controller it
+// deploys and the default versions of the EKS addons. The kubectl layer is
+// named by its Kubernetes version; the EKS stack maps it to its package.
+// kubectl works with an API server one minor version older or newer, so
+// one layer serves up to three versions.
+package k8sversions
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// Release is what a stack installs on a cluster of one Kubernetes version.
+type Release struct {
+	// Kubernetes minor version, e.g. 1.28
+	Kubernetes string
+	// Version of the kubectl layer, at most one minor version away from
+	// Kubernetes
+	KubectlLayer string
+	// AWS Load Balancer Controller version, e.g. v2.5.1. It must be one
+	// the CDK knows the IAM policy of
+	AlbController string
+	// Default version of each EKS addon, by addon name
+	Addons map[string]string
+}
+
+// releases lists the supported versions. A newer Kubernetes version needs a
+// newer AWS Load Balancer Controller than v2.5.1, and so a newer CDK.
+var releases = map[string]Release{
+	"1.24": {
+		Kubernetes:    "1.24",
+		KubectlLayer:  "1.24",
+		AlbController: "v2.5.1",
+		Addons: map[string]string{
+			"vpc-cni":            "v1.15.1-eksbuild.1",
+			"coredns":            "v1.9.3-eksbuild.7",
+			"kube-proxy":         "v1.24.17-eksbuild.2",
+			"aws-ebs-csi-driver": "v1.25.0-eksbuild.1",
+		},
+	},
+	"1.25": {
+		Kubernetes:    "1.25",
+		KubectlLayer:  "1.25",
+		AlbController: "v2.5.1",
+		Addons: map[string]string{
+			"vpc-cni":            "v1.15.1-eksbuild.1",
+			"coredns":            "v1.9.3-eksbuild.7",
+			"kube-proxy":         "v1.25.14-eksbuild.2",
+			"aws-ebs-csi-driver": "v1.25.0-eksbuild.1",
+		},
+	},
+	"1.26": {
+		Kubernetes:    "1.26",
+		KubectlLayer:  "1.27",
+		AlbController: "v2.5.1",
+		Addons: map[string]string{
+			"vpc-cni":            "v1.15.1-eksbuild.1",
+			"coredns":            "v1.9.3-eksbuild.7",
+			"kube-proxy":         "v1.26.9-eksbuild.2",
+			"aws-ebs-csi-driver": "v1.25.0-eksbuild.1",
+		},
+	},
+	"1.27": {
+		Kubernetes:    "1.27",
+		KubectlLayer:  "1.27",
+		AlbController: "v2.5.1",
+		Addons: map[string]string{
+			"vpc-cni":            "v1.15.1-eksbuild.1",
+			"coredns":            "v1.10.1-eksbuild.4",
+			"kube-proxy":         "v1.27.6-eksbuild.2",
+			"aws-ebs-csi-driver": "v1.25.0-eksbuild.1",
+		},
+	},
+	"1.28": {
+		Kubernetes:    "1.28",
+		KubectlLayer:  "1.27",
+		AlbController: "v2.5.1",
+		Addons: map[string]string{
+			"vpc-cni":            "v1.15.1-eksbuild.1",
+			"coredns":            "v1.10.1-eksbuild.4",
+			"kube-proxy":         "v1.28.2-eksbuild.2",
+			"aws-ebs-csi-driver": "v1.25.0-eksbuild.1",
+		},
+	},
+}
+
+// Lookup returns the release of the Kubernetes version k8sVersion, or an
+// error listing the supported versions.
+func Lookup(k8sVersion string) (Release, error) {
+	release, ok := releases[k8sVersion]
+	if !ok {
+		return Release{}, fmt.Errorf("unsupported Kubernetes version %q, supported versions: %s", k8sVersion, strings.Join(Supported(), ", "))
+	}
+	return release, nil
+}
+
+// Supported returns the supported Kubernetes versions, oldest first.
+func Supported() []string {
+	versions := make([]string, 0, len(releases))
+	for version := range releases {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return semver.MustParse(versions[i]).LessThan(semver.MustParse(versions[j]))
+	})
+	return versions
+}
@@ -0,0 +1,49 @@
+package k8sversions
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	release, err := Lookup("1.28")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if release.KubectlLayer != "1.27" || release.AlbController != "v2.5.1" {
+		t.Errorf("got %+v", release)
+	}
+	if release.Addons["kube-proxy"] != "v1.28.2-eksbuild.2" {
+		t.Errorf("kube-proxy %s", release.Addons["kube-proxy"])
+	}
+}
+
+func TestLookupUnsupported(t *testing.T) {
+	_, err := Lookup("1.30")
+	if err == nil {
+		t.Fatal("no error for 1.30")
+	}
+	if !strings.Contains(err.Error(), "1.24, 1.25, 1.26, 1.27, 1.28") {
+		t.Errorf("supported versions not listed: %v", err)
+	}
+}
+
+func TestSupported(t *testing.T) {
+	want := []string{"1.24", "1.25", "1.26", "1.27", "1.28"}
+	if got := Supported(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestReleases(t *testing.T) {
+	for version, release := range releases {
+		if release.Kubernetes != version {
+			t.Errorf("%s: Kubernetes %s", version, release.Kubernetes)
+		}
+		// kube-proxy must match the minor version of the cluster
+		if proxy := release.Addons["kube-proxy"]; !strings.HasPrefix(proxy, "v"+version+".") {
+			t.Errorf("%s: kube-proxy %s", version, proxy)
+		}
+	}
+}
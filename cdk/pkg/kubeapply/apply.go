@@ -0,0 +1,376 @@
+// Package kubeapply applies Kubernetes manifests with server-side apply.
+//
+// Applying the same manifests twice is a no-op: objects are created when
+// missing, patched when they differ and left alone otherwise. When an
+// inventory is configured, the applied objects are recorded in a ConfigMap so
+// that a later run can prune the ones that were dropped from the manifests.
+//
+// Objects are applied by kind priority (CRDs, Namespaces, RBAC, config, then
+// workloads) whatever their order in the manifests, and Apply can wait for
+// Deployments, StatefulSets and PersistentVolumeClaims to become ready.
+package kubeapply
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// Action is what Apply did to one object.
+type Action string
+
+const (
+	Created   Action = "created"
+	Updated   Action = "updated"
+	Unchanged Action = "unchanged"
+	Pruned    Action = "pruned"
+)
+
+// ObjectRef identifies an object in the cluster. Version is the one it was
+// applied with, only used to map it to its resource: the same object can be
+// read and written through every version its resource serves.
+type ObjectRef struct {
+	Group     string `json:"group,omitempty"`
+	Version   string `json:"version"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// RefOf returns the reference of obj.
+func RefOf(obj *unstructured.Unstructured) ObjectRef {
+	gvk := obj.GroupVersionKind()
+	return ObjectRef{
+		Group:     gvk.Group,
+		Version:   gvk.Version,
+		Kind:      gvk.Kind,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+	}
+}
+
+func (r ObjectRef) GroupVersionKind() schema.GroupVersionKind {
+	return schema.GroupVersionKind{Group: r.Group, Version: r.Version, Kind: r.Kind}
+}
+
+// objectKey is an ObjectRef without its version, so that an object moved to
+// another version of its kind is still the same object.
+type objectKey struct {
+	GroupKind schema.GroupKind
+	Namespace string
+	Name      string
+}
+
+func (r ObjectRef) key() objectKey {
+	return objectKey{
+		GroupKind: schema.GroupKind{Group: r.Group, Kind: r.Kind},
+		Namespace: r.Namespace,
+		Name:      r.Name,
+	}
+}
+
+func (r ObjectRef) String() string {
+	if r.Namespace == "" {
+		return r.Kind + "/" + r.Name
+	}
+	return r.Kind + "/" + r.Namespace + "/" + r.Name
+}
+
+// Result reports what Apply did to one object.
+type Result struct {
+	Object ObjectRef
+	Action Action
+}
+
+// Applier applies objects with server-side apply.
+type Applier struct {
+	Client dynamic.Interface
+	Mapper meta.RESTMapper
+	// FieldManager owns the applied fields. It is required.
+	FieldManager string
+	// Force takes over fields owned by another manager instead of failing
+	// with a conflict.
+	Force bool
+	// DefaultNamespace is used for namespaced objects without one.
+	// Defaults to "default".
+	DefaultNamespace string
+
+	// Inventory names the ConfigMap recording the applied objects. No
+	// inventory is kept when empty.
+	Inventory string
+	// InventoryNamespace holds the inventory ConfigMap. Defaults to
+	// "kube-system".
+	InventoryNamespace string
+	// Prune deletes the objects of the previous inventory that are not part
+	// of the applied set. It needs an Inventory.
+	Prune bool
+
+	// Concurrency limits the objects of one wave applied at the same time.
+	// Defaults to 4.
+	Concurrency int
+	// WaitTimeout, when set, makes Apply wait for the applied objects to be
+	// Ready. CRDs are always waited for, one minute when it is not set.
+	WaitTimeout time.Duration
+	// PollInterval is the readiness polling interval. Defaults to 2s.
+	PollInterval time.Duration
+}
+
+// Apply applies objs and, with Prune, removes the objects applied by a
+// previous run that are no longer in objs. Objects are applied in waves of
+// increasing Priority, each wave concurrently. It returns one result per
+// object handled before the first failing wave. On a failure, the objects
+// applied so far are still added to the inventory, without pruning.
+func (a *Applier) Apply(ctx context.Context, objs []*unstructured.Unstructured) ([]Result, error) {
+	if a.FieldManager == "" {
+		return nil, errors.New("kubeapply: FieldManager is required")
+	}
+	if a.Prune && a.Inventory == "" {
+		return nil, errors.New("kubeapply: Prune needs an Inventory")
+	}
+
+	results, applied, applyErr := a.applyWaves(ctx, objs)
+	if a.Inventory == "" {
+		return results, applyErr
+	}
+
+	previous, err := a.readInventory(ctx)
+	if err != nil {
+		return results, errors.Join(applyErr, err)
+	}
+
+	keep := make([]ObjectRef, 0, len(applied))
+	for _, obj := range applied {
+		keep = append(keep, RefOf(obj))
+	}
+	if a.Prune && applyErr == nil {
+		pruned, err := a.prune(ctx, previous, keep)
+		results = append(results, pruned...)
+		if err != nil {
+			return results, err
+		}
+	} else {
+		// Without pruning, objects dropped from the manifests stay in the
+		// inventory so that a later pruning run still removes them. After
+		// a failure, so do the objects of the waves not applied.
+		keep = union(previous, keep)
+	}
+
+	if err := a.writeInventory(ctx, keep); err != nil {
+		return results, errors.Join(applyErr, err)
+	}
+	return results, applyErr
+}
+
+// applyWaves applies objs wave by wave and, with a WaitTimeout, waits for
+// them to be Ready. It returns the results and applied objects up to the
+// first error.
+func (a *Applier) applyWaves(ctx context.Context, objs []*unstructured.Unstructured) ([]Result, []*unstructured.Unstructured, error) {
+	var results []Result
+	var applied []*unstructured.Unstructured
+	for _, wave := range Waves(objs) {
+		waveResults, waveApplied, err := a.applyWave(ctx, wave)
+		results = append(results, waveResults...)
+		applied = append(applied, waveApplied...)
+		if err != nil {
+			return results, applied, err
+		}
+
+		// New CRDs must be established, and discovery refreshed, before
+		// the custom resources of the later waves can be mapped
+		if isCRD(wave[0]) {
+			if err := a.waitReady(ctx, waveApplied, a.crdTimeout()); err != nil {
+				return results, applied, err
+			}
+			if mapper, ok := a.Mapper.(interface{ Reset() }); ok {
+				mapper.Reset()
+			}
+		}
+	}
+
+	if a.WaitTimeout > 0 {
+		if err := a.waitReady(ctx, applied, a.WaitTimeout); err != nil {
+			return results, applied, err
+		}
+	}
+	return results, applied, nil
+}
+
+// applyWave applies objects of the same priority concurrently. It returns
+// the results and applied objects of the ones that succeeded.
+func (a *Applier) applyWave(ctx context.Context, wave []*unstructured.Unstructured) ([]Result, []*unstructured.Unstructured, error) {
+	results := make([]Result, len(wave))
+	applied := make([]*unstructured.Unstructured, len(wave))
+	errs := make([]error, len(wave))
+
+	var wg sync.WaitGroup
+	limit := make(chan struct{}, a.concurrency())
+	for i, obj := range wave {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(i int, obj *unstructured.Unstructured) {
+			defer wg.Done()
+			defer func() { <-limit }()
+			results[i], applied[i], errs[i] = a.applyObject(ctx, obj)
+		}(i, obj)
+	}
+	wg.Wait()
+
+	var okResults []Result
+	var okApplied []*unstructured.Unstructured
+	var failed []error
+	for i, obj := range wave {
+		if errs[i] != nil {
+			failed = append(failed, fmt.Errorf("applying %s: %w", RefOf(obj), errs[i]))
+			continue
+		}
+		okResults = append(okResults, results[i])
+		okApplied = append(okApplied, applied[i])
+	}
+	return okResults, okApplied, errors.Join(failed...)
+}
+
+// applyObject applies a copy of obj and returns it with its namespace
+// resolved.
+func (a *Applier) applyObject(ctx context.Context, obj *unstructured.Unstructured) (Result, *unstructured.Unstructured, error) {
+	obj = obj.DeepCopy()
+	client, err := a.resourceClient(obj)
+	if err != nil {
+		return Result{}, nil, err
+	}
+	result := Result{Object: RefOf(obj)}
+
+	before, err := client.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return result, nil, err
+	}
+	exists := err == nil
+
+	after, err := client.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{
+		FieldManager: a.FieldManager,
+		Force:        a.Force,
+	})
+	if err != nil {
+		return result, nil, err
+	}
+
+	switch {
+	case !exists:
+		result.Action = Created
+	case before.GetResourceVersion() == after.GetResourceVersion():
+		result.Action = Unchanged
+	default:
+		result.Action = Updated
+	}
+	return result, obj, nil
+}
+
+// resourceClient maps obj to its resource. Namespaced objects without a
+// namespace get the default one; cluster scoped objects lose theirs.
+func (a *Applier) resourceClient(obj *unstructured.Unstructured) (dynamic.ResourceInterface, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := a.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		if obj.GetNamespace() == "" {
+			obj.SetNamespace(a.defaultNamespace())
+		}
+		return a.Client.Resource(mapping.Resource).Namespace(obj.GetNamespace()), nil
+	}
+	obj.SetNamespace("")
+	return a.Client.Resource(mapping.Resource), nil
+}
+
+// prune deletes the objects of previous missing from applied, whatever
+// version they were applied with. Objects, or whole resource types, that are
+// already gone are skipped.
+func (a *Applier) prune(ctx context.Context, previous, applied []ObjectRef) ([]Result, error) {
+	current := make(map[objectKey]bool, len(applied))
+	for _, ref := range applied {
+		current[ref.key()] = true
+	}
+
+	var results []Result
+	for _, ref := range previous {
+		if current[ref.key()] {
+			continue
+		}
+
+		// The recorded version may no longer be served
+		gvk := ref.GroupVersionKind()
+		mapping, err := a.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if meta.IsNoMatchError(err) {
+			mapping, err = a.Mapper.RESTMapping(gvk.GroupKind())
+		}
+		if meta.IsNoMatchError(err) {
+			continue
+		}
+		if err != nil {
+			return results, fmt.Errorf("pruning %s: %w", ref, err)
+		}
+
+		var client dynamic.ResourceInterface = a.Client.Resource(mapping.Resource)
+		if ref.Namespace != "" {
+			client = a.Client.Resource(mapping.Resource).Namespace(ref.Namespace)
+		}
+
+		propagation := metav1.DeletePropagationBackground
+		err = client.Delete(ctx, ref.Name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return results, fmt.Errorf("pruning %s: %w", ref, err)
+		}
+		results = append(results, Result{Object: ref, Action: Pruned})
+	}
+	return results, nil
+}
+
+func (a *Applier) concurrency() int {
+	if a.Concurrency < 1 {
+		return 4
+	}
+	return a.Concurrency
+}
+
+func (a *Applier) crdTimeout() time.Duration {
+	if a.WaitTimeout == 0 {
+		return time.Minute
+	}
+	return a.WaitTimeout
+}
+
+func (a *Applier) defaultNamespace() string {
+	if a.DefaultNamespace == "" {
+		return "default"
+	}
+	return a.DefaultNamespace
+}
+
+// union returns the objects of a and b, with the version of b for the
+// objects in both.
+func union(a, b []ObjectRef) []ObjectRef {
+	index := make(map[objectKey]int, len(a)+len(b))
+	var out []ObjectRef
+	for _, ref := range append(append([]ObjectRef{}, a...), b...) {
+		if i, ok := index[ref.key()]; ok {
+			out[i] = ref
+			continue
+		}
+		index[ref.key()] = len(out)
+		out = append(out, ref)
+	}
+	return out
+}
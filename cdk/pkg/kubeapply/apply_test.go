@@ -0,0 +1,328 @@
+package kubeapply
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var (
+	storageClasses = schema.GroupVersionResource{Group: "storage.k8s.io", Version: "v1", Resource: "storageclasses"}
+	deployments    = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+)
+
+const manifests = `
+apiVersion: storage.k8s.io/v1
+kind: StorageClass
+metadata:
+  name: managed-csi
+provisioner: ebs.csi.aws.com
+parameters:
+  type: gp2
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 1
+---
+`
+
+func testMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Group: "storage.k8s.io", Version: "v1", Kind: "StorageClass"}, meta.RESTScopeRoot)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	return mapper
+}
+
+// newFakeClient returns a fake dynamic client whose apply patches behave
+// like the API server: missing objects are created, and the resource
+// version only changes when the applied fields change something.
+func newFakeClient() *fake.FakeDynamicClient {
+	client := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	tracker := client.Tracker()
+
+	client.PrependReactor("patch", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patch := action.(clienttesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		applied := &unstructured.Unstructured{}
+		if err := applied.UnmarshalJSON(patch.GetPatch()); err != nil {
+			return true, nil, err
+		}
+
+		gvr, namespace := action.GetResource(), action.GetNamespace()
+		existing, err := tracker.Get(gvr, namespace, patch.GetName())
+		if apierrors.IsNotFound(err) {
+			applied.SetResourceVersion("1")
+			return true, applied, tracker.Create(gvr, applied, namespace)
+		}
+		if err != nil {
+			return true, nil, err
+		}
+
+		current := existing.(*unstructured.Unstructured)
+		merged := current.DeepCopy()
+		for key, value := range applied.Object {
+			if key != "metadata" {
+				merged.Object[key] = value
+			}
+		}
+		if equality.Semantic.DeepEqual(merged.Object, current.Object) {
+			return true, current, nil
+		}
+		version, _ := strconv.Atoi(current.GetResourceVersion())
+		merged.SetResourceVersion(strconv.Itoa(version + 1))
+		return true, merged, tracker.Update(gvr, merged, namespace)
+	})
+	return client
+}
+
+func decode(t *testing.T, manifest string) []*unstructured.Unstructured {
+	t.Helper()
+	objs, err := Decode([]byte(manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return objs
+}
+
+func actions(results []Result) map[string]Action {
+	out := make(map[string]Action, len(results))
+	for _, result := range results {
+		out[result.Object.String()] = result.Action
+	}
+	return out
+}
+
+func assertActions(t *testing.T, results []Result, want map[string]Action) {
+	t.Helper()
+	got := actions(results)
+	if len(got) != len(want) {
+		t.Fatalf("got results %v, want %v", got, want)
+	}
+	for ref, action := range want {
+		if got[ref] != action {
+			t.Errorf("%s: got %q, want %q", ref, got[ref], action)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	objs := decode(t, manifests)
+	if len(objs) != 2 {
+		t.Fatalf("got %d objects, want 2", len(objs))
+	}
+	if objs[0].GetKind() != "StorageClass" || objs[1].GetName() != "web" {
+		t.Errorf("unexpected objects: %v, %v", RefOf(objs[0]), RefOf(objs[1]))
+	}
+
+	if _, err := Decode([]byte("metadata:\n  name: orphan\n")); err == nil {
+		t.Error("expected an error for an object without kind")
+	}
+}
+
+func TestApplyIsIdempotent(t *testing.T) {
+	client := newFakeClient()
+	applier := &Applier{Client: client, Mapper: testMapper(), FieldManager: "test"}
+	ctx := context.Background()
+
+	results, err := applier.Apply(ctx, decode(t, manifests))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertActions(t, results, map[string]Action{
+		"StorageClass/managed-csi": Created,
+		"Deployment/default/web":   Created,
+	})
+
+	results, err = applier.Apply(ctx, decode(t, manifests))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertActions(t, results, map[string]Action{
+		"StorageClass/managed-csi": Unchanged,
+		"Deployment/default/web":   Unchanged,
+	})
+}
+
+func TestApplyUpdatesChangedObjects(t *testing.T) {
+	client := newFakeClient()
+	applier := &Applier{Client: client, Mapper: testMapper(), FieldManager: "test"}
+	ctx := context.Background()
+
+	if _, err := applier.Apply(ctx, decode(t, manifests)); err != nil {
+		t.Fatal(err)
+	}
+	results, err := applier.Apply(ctx, decode(t, strings.Replace(manifests, "type: gp2", "type: gp3", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertActions(t, results, map[string]Action{
+		"StorageClass/managed-csi": Updated,
+		"Deployment/default/web":   Unchanged,
+	})
+
+	sc, err := client.Resource(storageClasses).Get(ctx, "managed-csi", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if volumeType, _, _ := unstructured.NestedString(sc.Object, "parameters", "type"); volumeType != "gp3" {
+		t.Errorf("got volume type %q, want gp3", volumeType)
+	}
+}
+
+func TestApplyPrunesDroppedObjects(t *testing.T) {
+	client := newFakeClient()
+	ctx := context.Background()
+	applier := &Applier{
+		Client:       client,
+		Mapper:       testMapper(),
+		FieldManager: "test",
+		Inventory:    "test-inventory",
+	}
+
+	if _, err := applier.Apply(ctx, decode(t, manifests)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without Prune the dropped Deployment stays, and stays in the inventory
+	storageClassOnly := strings.SplitN(manifests, "---", 2)[0]
+	if _, err := applier.Apply(ctx, decode(t, storageClassOnly)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Resource(deployments).Namespace("default").Get(ctx, "web", metav1.GetOptions{}); err != nil {
+		t.Fatalf("deployment removed without Prune: %v", err)
+	}
+
+	applier.Prune = true
+	results, err := applier.Apply(ctx, decode(t, storageClassOnly))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertActions(t, results, map[string]Action{
+		"StorageClass/managed-csi": Unchanged,
+		"Deployment/default/web":   Pruned,
+	})
+	_, err = client.Resource(deployments).Namespace("default").Get(ctx, "web", metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("deployment not pruned: %v", err)
+	}
+
+	inventory, err := applier.readInventory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inventory) != 1 || inventory[0].String() != "StorageClass/managed-csi" {
+		t.Errorf("got inventory %v", inventory)
+	}
+}
+
+func TestApplyKeepsObjectsMovedToAnotherVersion(t *testing.T) {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Group: "batch", Version: "v1beta1", Kind: "CronJob"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "CronJob"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	client := newFakeClient()
+	ctx := context.Background()
+	applier := &Applier{
+		Client:       client,
+		Mapper:       mapper,
+		FieldManager: "test",
+		Inventory:    "test-inventory",
+		Prune:        true,
+	}
+
+	cronJob := "apiVersion: batch/%s\nkind: CronJob\nmetadata:\n  name: backup\nspec:\n  schedule: \"0 3 * * *\"\n"
+	if _, err := applier.Apply(ctx, decode(t, fmt.Sprintf(cronJob, "v1beta1"))); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same object through the new version is not pruned
+	results, err := applier.Apply(ctx, decode(t, fmt.Sprintf(cronJob, "v1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if result.Action == Pruned {
+			t.Errorf("%s pruned after moving to batch/v1", result.Object)
+		}
+	}
+
+	inventory, err := applier.readInventory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inventory) != 1 || inventory[0].Version != "v1" {
+		t.Errorf("got inventory %v, want the CronJob at v1", inventory)
+	}
+}
+
+func TestApplyRecordsInventoryOnFailure(t *testing.T) {
+	client := newFakeClient()
+	ctx := context.Background()
+	applier := &Applier{
+		Client:       client,
+		Mapper:       testMapper(),
+		FieldManager: "test",
+		Inventory:    "test-inventory",
+		Prune:        true,
+	}
+
+	configMap := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n"
+	if _, err := applier.Apply(ctx, decode(t, configMap)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The Widget of the last wave can't be mapped: the objects of the
+	// first waves are recorded, and the ConfigMap is neither pruned nor
+	// dropped from the inventory
+	widget := "apiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: w\n"
+	results, err := applier.Apply(ctx, decode(t, manifests+widget))
+	if err == nil || !strings.Contains(err.Error(), "Widget/w") {
+		t.Fatalf("got %v, want an error naming Widget/w", err)
+	}
+	assertActions(t, results, map[string]Action{
+		"StorageClass/managed-csi": Created,
+		"Deployment/default/web":   Created,
+	})
+
+	inventory, err := applier.readInventory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]bool, len(inventory))
+	for _, ref := range inventory {
+		got[ref.String()] = true
+	}
+	for _, ref := range []string{"StorageClass/managed-csi", "Deployment/default/web", "ConfigMap/default/settings"} {
+		if !got[ref] {
+			t.Errorf("%s not in inventory %v", ref, inventory)
+		}
+	}
+}
+
+func TestApplyUnknownKind(t *testing.T) {
+	applier := &Applier{Client: newFakeClient(), Mapper: testMapper(), FieldManager: "test"}
+
+	_, err := applier.Apply(context.Background(), decode(t, "apiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: w\n"))
+	if err == nil || !strings.Contains(err.Error(), "Widget/w") {
+		t.Fatalf("got %v, want an error naming Widget/w", err)
+	}
+}
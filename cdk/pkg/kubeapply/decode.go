@@ -0,0 +1,45 @@
+package kubeapply
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// Decode splits YAML or JSON manifests into objects. Empty documents are
+// skipped; a document without apiVersion, kind or name is an error.
+func Decode(manifests ...[]byte) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+	for _, manifest := range manifests {
+		decoder := yamlutil.NewYAMLOrJSONDecoder(bytes.NewReader(manifest), 4096)
+		for {
+			var raw runtime.RawExtension
+			if err := decoder.Decode(&raw); err != nil {
+				if err == io.EOF {
+					break
+				}
+				return nil, err
+			}
+			raw.Raw = bytes.TrimSpace(raw.Raw)
+			if len(raw.Raw) == 0 || bytes.Equal(raw.Raw, []byte("null")) {
+				continue
+			}
+
+			// Decoding through Unstructured keeps integers as int64, as in
+			// the objects read back from the API server
+			obj := &unstructured.Unstructured{}
+			if err := obj.UnmarshalJSON(raw.Raw); err != nil {
+				return nil, err
+			}
+			if obj.GetName() == "" {
+				return nil, fmt.Errorf("%s has no metadata.name", obj.GetKind())
+			}
+			objs = append(objs, obj)
+		}
+	}
+	return objs, nil
+}
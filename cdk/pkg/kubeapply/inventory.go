@@ -0,0 +1,79 @@
+package kubeapply
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// inventoryKey is the ConfigMap data key holding the JSON list of objects.
+const inventoryKey = "objects"
+
+var configMaps = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+func (a *Applier) inventoryNamespace() string {
+	if a.InventoryNamespace == "" {
+		return "kube-system"
+	}
+	return a.InventoryNamespace
+}
+
+func (a *Applier) inventoryClient() dynamic.ResourceInterface {
+	return a.Client.Resource(configMaps).Namespace(a.inventoryNamespace())
+}
+
+// readInventory returns the objects recorded by the previous run, or none
+// when there was no previous run.
+func (a *Applier) readInventory(ctx context.Context) ([]ObjectRef, error) {
+	cm, err := a.inventoryClient().Get(ctx, a.Inventory, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading inventory %s: %w", a.Inventory, err)
+	}
+
+	data, _, err := unstructured.NestedString(cm.Object, "data", inventoryKey)
+	if err != nil || data == "" {
+		return nil, err
+	}
+	var refs []ObjectRef
+	if err := json.Unmarshal([]byte(data), &refs); err != nil {
+		return nil, fmt.Errorf("reading inventory %s: %w", a.Inventory, err)
+	}
+	return refs, nil
+}
+
+func (a *Applier) writeInventory(ctx context.Context, refs []ObjectRef) error {
+	sorted := append([]ObjectRef{}, refs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].String() < sorted[j].String() })
+	data, err := json.Marshal(sorted)
+	if err != nil {
+		return err
+	}
+
+	cm := &unstructured.Unstructured{}
+	cm.SetAPIVersion("v1")
+	cm.SetKind("ConfigMap")
+	cm.SetName(a.Inventory)
+	cm.SetNamespace(a.inventoryNamespace())
+	if err := unstructured.SetNestedField(cm.Object, string(data), "data", inventoryKey); err != nil {
+		return err
+	}
+
+	_, err = a.inventoryClient().Apply(ctx, a.Inventory, cm, metav1.ApplyOptions{
+		FieldManager: a.FieldManager,
+		Force:        true,
+	})
+	if err != nil {
+		return fmt.Errorf("writing inventory %s: %w", a.Inventory, err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package kubeapply
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// manifestExtensions are the files LoadDirs reads.
+var manifestExtensions = map[string]bool{".yaml": true, ".yml": true, ".json": true}
+
+var templateFuncs = template.FuncMap{
+	"quote": strconv.Quote,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// Render executes manifest as a Go template with values. Referencing a
+// value that does not exist is an error.
+func Render(name string, manifest []byte, values interface{}) ([]byte, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(string(manifest))
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, values); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// LoadDirs renders and decodes the manifest files (.yaml, .yml and .json)
+// found directly in dirs. Files are read in name order, directory after
+// directory; Apply then reorders the objects by kind.
+func LoadDirs(dirs []string, values interface{}) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+		for _, entry := range entries {
+			if entry.IsDir() || !manifestExtensions[filepath.Ext(entry.Name())] {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			rendered, err := Render(entry.Name(), content, values)
+			if err != nil {
+				return nil, fmt.Errorf("rendering %s: %w", path, err)
+			}
+			fileObjs, err := Decode(rendered)
+			if err != nil {
+				return nil, fmt.Errorf("decoding %s: %w", path, err)
+			}
+			objs = append(objs, fileObjs...)
+		}
+	}
+	return objs, nil
+}
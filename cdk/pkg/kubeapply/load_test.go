@@ -0,0 +1,62 @@
+package kubeapply
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testValues struct {
+	Index       string
+	ClusterName string
+}
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadDirs(t *testing.T) {
+	base := writeFiles(t, map[string]string{
+		"b-config.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cluster-{{ .Index }}\ndata:\n  cluster: {{ quote .ClusterName }}\n",
+		"a-ns.yml":      "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: tools{{ .Index }}\n",
+		"README.md":     "not a manifest {{ .Missing }}",
+	})
+	team := writeFiles(t, map[string]string{
+		"sa.json": `{"apiVersion": "v1", "kind": "ServiceAccount", "metadata": {"name": "{{ lower .ClusterName }}"}}`,
+	})
+
+	objs, err := LoadDirs([]string{base, team}, testValues{Index: "02", ClusterName: "SonarAWSTuto02"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var refs []string
+	for _, obj := range objs {
+		refs = append(refs, RefOf(obj).String())
+	}
+	if got := strings.Join(refs, ","); got != "Namespace/tools02,ConfigMap/cluster-02,ServiceAccount/sonarawstuto02" {
+		t.Errorf("got objects %s", got)
+	}
+	if cluster := objs[1].Object["data"].(map[string]interface{})["cluster"]; cluster != "SonarAWSTuto02" {
+		t.Errorf("got data.cluster %v", cluster)
+	}
+}
+
+func TestLoadDirsMissingValue(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"sc.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Unknown }}\n",
+	})
+
+	_, err := LoadDirs([]string{dir}, map[string]string{"Index": "02"})
+	if err == nil || !strings.Contains(err.Error(), "sc.yaml") {
+		t.Fatalf("got %v, want an error naming sc.yaml", err)
+	}
+}
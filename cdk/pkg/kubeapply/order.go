@@ -0,0 +1,90 @@
+package kubeapply
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/restmapper"
+)
+
+// Apply priorities. Objects are applied in waves of increasing priority, so
+// that CRDs and Namespaces exist before the objects that need them.
+const (
+	priorityCRD = iota
+	priorityNamespace
+	priorityRBAC
+	priorityConfig
+	priorityWorkload
+	priorityOther
+)
+
+var kindPriority = map[string]int{
+	"CustomResourceDefinition": priorityCRD,
+
+	"Namespace": priorityNamespace,
+
+	"ServiceAccount":     priorityRBAC,
+	"ClusterRole":        priorityRBAC,
+	"ClusterRoleBinding": priorityRBAC,
+	"Role":               priorityRBAC,
+	"RoleBinding":        priorityRBAC,
+
+	"ConfigMap":             priorityConfig,
+	"Secret":                priorityConfig,
+	"StorageClass":          priorityConfig,
+	"PriorityClass":         priorityConfig,
+	"ResourceQuota":         priorityConfig,
+	"LimitRange":            priorityConfig,
+	"PersistentVolume":      priorityConfig,
+	"PersistentVolumeClaim": priorityConfig,
+
+	"Service":     priorityWorkload,
+	"Deployment":  priorityWorkload,
+	"StatefulSet": priorityWorkload,
+	"DaemonSet":   priorityWorkload,
+	"Job":         priorityWorkload,
+	"CronJob":     priorityWorkload,
+	"Pod":         priorityWorkload,
+	"Ingress":     priorityWorkload,
+}
+
+// Priority returns the apply wave of a kind. Custom resources and unknown
+// kinds go last, after the CRDs and workloads they may depend on.
+func Priority(kind string) int {
+	if priority, ok := kindPriority[kind]; ok {
+		return priority
+	}
+	return priorityOther
+}
+
+// Waves groups objs by priority, keeping the manifest order within a wave.
+func Waves(objs []*unstructured.Unstructured) [][]*unstructured.Unstructured {
+	sorted := append([]*unstructured.Unstructured{}, objs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return Priority(sorted[i].GetKind()) < Priority(sorted[j].GetKind())
+	})
+
+	var waves [][]*unstructured.Unstructured
+	for i, obj := range sorted {
+		if i == 0 || Priority(obj.GetKind()) != Priority(sorted[i-1].GetKind()) {
+			waves = append(waves, nil)
+		}
+		waves[len(waves)-1] = append(waves[len(waves)-1], obj)
+	}
+	return waves
+}
+
+// NewMapper returns a REST mapper that runs discovery once and caches it.
+// Apply resets it after installing CRDs, so their kinds can be mapped.
+func NewMapper(client discovery.DiscoveryInterface) *restmapper.DeferredDiscoveryRESTMapper {
+	return restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(client))
+}
+
+var crdKind = schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}
+
+func isCRD(obj *unstructured.Unstructured) bool {
+	return obj.GroupVersionKind().GroupKind() == crdKind
+}
@@ -0,0 +1,262 @@
+package kubeapply
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+const orderedManifests = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: sonarqube
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: w
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: sonarqube
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+  namespace: sonarqube
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: web
+  namespace: sonarqube
+`
+
+func TestWaves(t *testing.T) {
+	var got [][]string
+	for _, wave := range Waves(decode(t, orderedManifests)) {
+		var kinds []string
+		for _, obj := range wave {
+			kinds = append(kinds, obj.GetKind())
+		}
+		got = append(got, kinds)
+	}
+
+	want := [][]string{
+		{"CustomResourceDefinition"},
+		{"Namespace"},
+		{"ServiceAccount"},
+		{"ConfigMap"},
+		{"Deployment"},
+		{"Widget"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got waves %v, want %v", got, want)
+	}
+	for i := range want {
+		if strings.Join(got[i], ",") != strings.Join(want[i], ",") {
+			t.Errorf("wave %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+// resettingMapper only knows the Widget kind once it has been reset, like a
+// discovery cache that predates the CRD.
+type resettingMapper struct {
+	meta.RESTMapper
+	afterReset meta.RESTMapper
+	resets     int
+}
+
+func (m *resettingMapper) Reset() {
+	m.resets++
+	m.RESTMapper = m.afterReset
+}
+
+func orderedMapper() *resettingMapper {
+	before := meta.NewDefaultRESTMapper(nil)
+	before.Add(schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition"}, meta.RESTScopeRoot)
+	before.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	before.Add(schema.GroupVersionKind{Version: "v1", Kind: "ServiceAccount"}, meta.RESTScopeNamespace)
+	before.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	before.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+
+	after := meta.NewDefaultRESTMapper(nil)
+	for _, gvk := range []schema.GroupVersionKind{
+		{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition"},
+		{Version: "v1", Kind: "Namespace"},
+	} {
+		after.Add(gvk, meta.RESTScopeRoot)
+	}
+	for _, gvk := range []schema.GroupVersionKind{
+		{Version: "v1", Kind: "ServiceAccount"},
+		{Version: "v1", Kind: "ConfigMap"},
+		{Group: "apps", Version: "v1", Kind: "Deployment"},
+	} {
+		after.Add(gvk, meta.RESTScopeNamespace)
+	}
+	after.Add(schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}, meta.RESTScopeRoot)
+
+	return &resettingMapper{RESTMapper: before, afterReset: after}
+}
+
+func TestApplyOrdersAndRefreshesDiscovery(t *testing.T) {
+	client := newFakeClient()
+	mapper := orderedMapper()
+	applier := &Applier{Client: client, Mapper: mapper, FieldManager: "test", PollInterval: time.Millisecond}
+
+	// The CRD is reported Established as soon as it is applied
+	tracker := client.Tracker()
+	client.PrependReactor("get", "customresourcedefinitions", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		get := action.(clienttesting.GetAction)
+		obj, err := tracker.Get(get.GetResource(), get.GetNamespace(), get.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		crd := obj.(*unstructured.Unstructured)
+		conditions := []interface{}{map[string]interface{}{"type": "Established", "status": "True"}}
+		return true, crd, unstructured.SetNestedSlice(crd.Object, conditions, "status", "conditions")
+	})
+
+	results, err := applier.Apply(context.Background(), decode(t, orderedManifests))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapper.resets != 1 {
+		t.Errorf("got %d discovery resets, want 1", mapper.resets)
+	}
+
+	var order []string
+	for _, result := range results {
+		order = append(order, result.Object.Kind)
+	}
+	if got := strings.Join(order, ","); got != "CustomResourceDefinition,Namespace,ServiceAccount,ConfigMap,Deployment,Widget" {
+		t.Errorf("got apply order %s", got)
+	}
+}
+
+func TestApplyWaitsForReadiness(t *testing.T) {
+	deployment := `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 2
+`
+	ready := func(available int64) *fake.FakeDynamicClient {
+		obj := decode(t, deployment)[0]
+		obj.SetNamespace("default")
+		obj.SetResourceVersion("1")
+		_ = unstructured.SetNestedField(obj.Object, int64(2), "status", "replicas")
+		_ = unstructured.SetNestedField(obj.Object, int64(2), "status", "updatedReplicas")
+		_ = unstructured.SetNestedField(obj.Object, available, "status", "availableReplicas")
+		client := newFakeClient()
+		if err := client.Tracker().Add(obj); err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+
+	applier := &Applier{
+		Client:       ready(2),
+		Mapper:       testMapper(),
+		FieldManager: "test",
+		WaitTimeout:  time.Second,
+		PollInterval: time.Millisecond,
+	}
+	if _, err := applier.Apply(context.Background(), decode(t, deployment)); err != nil {
+		t.Fatalf("ready deployment: %v", err)
+	}
+
+	applier.Client = ready(1)
+	applier.WaitTimeout = 20 * time.Millisecond
+	_, err := applier.Apply(context.Background(), decode(t, deployment))
+	if err == nil || !strings.Contains(err.Error(), "Deployment/default/web") {
+		t.Fatalf("got %v, want a timeout naming Deployment/default/web", err)
+	}
+}
+
+func TestApplyWaitsForBoundClaims(t *testing.T) {
+	classes := `
+apiVersion: storage.k8s.io/v1
+kind: StorageClass
+metadata:
+  name: immediate
+provisioner: ebs.csi.aws.com
+volumeBindingMode: Immediate
+---
+apiVersion: storage.k8s.io/v1
+kind: StorageClass
+metadata:
+  name: managed-csi
+provisioner: ebs.csi.aws.com
+volumeBindingMode: WaitForFirstConsumer
+`
+	claim := "apiVersion: v1\nkind: PersistentVolumeClaim\nmetadata:\n  name: data\nspec:\n  storageClassName: %s\n"
+	mapper := testMapper().(*meta.DefaultRESTMapper)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "PersistentVolumeClaim"}, meta.RESTScopeNamespace)
+	applier := &Applier{
+		Client:       newFakeClient(),
+		Mapper:       mapper,
+		FieldManager: "test",
+		WaitTimeout:  20 * time.Millisecond,
+		PollInterval: time.Millisecond,
+	}
+	if _, err := applier.Apply(context.Background(), decode(t, classes)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing binds the claim before a pod uses it
+	if _, err := applier.Apply(context.Background(), decode(t, fmt.Sprintf(claim, "managed-csi"))); err != nil {
+		t.Fatalf("claim waiting for its first consumer: %v", err)
+	}
+
+	_, err := applier.Apply(context.Background(), decode(t, fmt.Sprintf(claim, "immediate")))
+	if err == nil || !strings.Contains(err.Error(), "PersistentVolumeClaim/default/data") {
+		t.Fatalf("got %v, want a timeout naming PersistentVolumeClaim/default/data", err)
+	}
+}
+
+func TestReady(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		want     bool
+	}{
+		{"configmap", "apiVersion: v1\nkind: ConfigMap\nmetadata: {name: c}\n", true},
+		{"pending pvc", "apiVersion: v1\nkind: PersistentVolumeClaim\nmetadata: {name: p}\nstatus: {phase: Pending}\n", false},
+		{"bound pvc", "apiVersion: v1\nkind: PersistentVolumeClaim\nmetadata: {name: p}\nstatus: {phase: Bound}\n", true},
+		{"rolling deployment", "apiVersion: apps/v1\nkind: Deployment\nmetadata: {name: d, generation: 2}\nspec: {replicas: 2}\nstatus: {observedGeneration: 2, replicas: 3, updatedReplicas: 2, availableReplicas: 3}\n", false},
+		{"stale deployment", "apiVersion: apps/v1\nkind: Deployment\nmetadata: {name: d, generation: 2}\nspec: {replicas: 2}\nstatus: {observedGeneration: 1, replicas: 2, updatedReplicas: 2, availableReplicas: 2}\n", false},
+		{"ready deployment", "apiVersion: apps/v1\nkind: Deployment\nmetadata: {name: d, generation: 2}\nspec: {replicas: 2}\nstatus: {observedGeneration: 2, replicas: 2, updatedReplicas: 2, availableReplicas: 2}\n", true},
+		{"stale statefulset", "apiVersion: apps/v1\nkind: StatefulSet\nmetadata: {name: s, generation: 2}\nspec: {replicas: 1}\nstatus: {observedGeneration: 1, updatedReplicas: 1, readyReplicas: 1}\n", false},
+		{"ready statefulset", "apiVersion: apps/v1\nkind: StatefulSet\nmetadata: {name: s, generation: 2}\nspec: {replicas: 1}\nstatus: {observedGeneration: 2, updatedReplicas: 1, readyReplicas: 1}\n", true},
+		{"new crd", "apiVersion: apiextensions.k8s.io/v1\nkind: CustomResourceDefinition\nmetadata: {name: widgets.example.com}\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Ready(decode(t, tt.manifest)[0]); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package kubeapply
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+var storageClassResource = schema.GroupVersionResource{Group: "storage.k8s.io", Version: "v1", Resource: "storageclasses"}
+
+// selectedNodeAnnotation is set on a claim once the scheduler picked the
+// node of its first consumer.
+const selectedNodeAnnotation = "volume.kubernetes.io/selected-node"
+
+// Ready reports whether obj has reached the state its spec asks for.
+// Deployments need all replicas updated and available, and the old ones
+// gone; StatefulSets all replicas updated and ready. PersistentVolumeClaims
+// must be Bound and CRDs Established. Other kinds are ready as soon as they
+// are applied.
+func Ready(obj *unstructured.Unstructured) bool {
+	switch obj.GroupVersionKind().GroupKind() {
+	case crdKind:
+		return hasCondition(obj, "Established")
+	}
+
+	switch obj.GetKind() {
+	case "Deployment":
+		return observed(obj) &&
+			status(obj, "replicas") == replicas(obj) &&
+			status(obj, "updatedReplicas") >= replicas(obj) &&
+			status(obj, "availableReplicas") >= replicas(obj)
+	case "StatefulSet":
+		return observed(obj) &&
+			status(obj, "updatedReplicas") >= replicas(obj) &&
+			status(obj, "readyReplicas") >= replicas(obj)
+	case "PersistentVolumeClaim":
+		phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+		return phase == "Bound"
+	}
+	return true
+}
+
+func observed(obj *unstructured.Unstructured) bool {
+	return status(obj, "observedGeneration") >= obj.GetGeneration()
+}
+
+func replicas(obj *unstructured.Unstructured) int64 {
+	replicas, found, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	if !found {
+		return 1
+	}
+	return replicas
+}
+
+func status(obj *unstructured.Unstructured, field string) int64 {
+	value, _, _ := unstructured.NestedInt64(obj.Object, "status", field)
+	return value
+}
+
+func hasCondition(obj *unstructured.Unstructured, conditionType string) bool {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if ok && condition["type"] == conditionType && condition["status"] == "True" {
+			return true
+		}
+	}
+	return false
+}
+
+// ready reports whether obj is Ready. A Pending claim of a
+// WaitForFirstConsumer class is ready too until a pod uses it, as it isn't
+// bound before.
+func (a *Applier) ready(ctx context.Context, obj *unstructured.Unstructured) (bool, error) {
+	if Ready(obj) {
+		return true, nil
+	}
+	if obj.GetKind() != "PersistentVolumeClaim" || obj.GetAnnotations()[selectedNodeAnnotation] != "" {
+		return false, nil
+	}
+
+	// The API server sets the default class on claims without one
+	className, _, _ := unstructured.NestedString(obj.Object, "spec", "storageClassName")
+	if className == "" {
+		return false, nil
+	}
+	class, err := a.Client.Resource(storageClassResource).Get(ctx, className, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	mode, _, _ := unstructured.NestedString(class.Object, "volumeBindingMode")
+	return mode == "WaitForFirstConsumer", nil
+}
+
+// waitReady polls objs until they are all ready or timeout expires.
+func (a *Applier) waitReady(ctx context.Context, objs []*unstructured.Unstructured, timeout time.Duration) error {
+	pending := append([]*unstructured.Unstructured{}, objs...)
+
+	err := wait.PollUntilContextTimeout(ctx, a.pollInterval(), timeout, true, func(ctx context.Context) (bool, error) {
+		var still []*unstructured.Unstructured
+		for _, obj := range pending {
+			client, err := a.resourceClient(obj)
+			if err != nil {
+				return false, err
+			}
+			current, err := client.Get(ctx, obj.GetName(), metav1.GetOptions{})
+			if err != nil {
+				return false, err
+			}
+			ready, err := a.ready(ctx, current)
+			if err != nil {
+				return false, err
+			}
+			if !ready {
+				still = append(still, obj)
+			}
+		}
+		pending = still
+		return len(pending) == 0, nil
+	})
+	if err != nil && len(pending) > 0 {
+		names := make([]string, 0, len(pending))
+		for _, obj := range pending {
+			names = append(names, RefOf(obj).String())
+		}
+		return fmt.Errorf("waiting for %s to become ready: %w", strings.Join(names, ", "), err)
+	}
+	return err
+}
+
+func (a *Applier) pollInterval() time.Duration {
+	if a.PollInterval == 0 {
+		return 2 * time.Second
+	}
+	return a.PollInterval
+}
@@ -0,0 +1,51 @@
+// Package kubetunnel points a Kubernetes client at a local port-forward to a
+// private cluster endpoint.
+//
+// A cluster with a private endpoint is reached through a Session Manager
+// port-forward, e.g. localhost:8443 to the endpoint on port 443. The client
+// then connects to the local port, while still checking the certificate of
+// the endpoint and authenticating as its kubeconfig says.
+package kubetunnel
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"k8s.io/client-go/rest"
+)
+
+// EnvVar names the environment variable giving the local address of the
+// port-forward, e.g. localhost:8443. The endpoint is used directly when it
+// is not set.
+const EnvVar = "EKS_API_TUNNEL"
+
+// Apply redirects config to the tunnel address, host:port. The TLS server
+// name stays the endpoint's, so its certificate is still verified. An
+// empty tunnel leaves config unchanged.
+func Apply(config *rest.Config, tunnel string) error {
+	if tunnel == "" {
+		return nil
+	}
+	if strings.Contains(tunnel, "://") {
+		return fmt.Errorf("%s: %q must be host:port, without a scheme", EnvVar, tunnel)
+	}
+	endpoint, err := url.Parse(config.Host)
+	if err != nil {
+		return fmt.Errorf("parsing the endpoint %q: %w", config.Host, err)
+	}
+	if endpoint.Hostname() == "" {
+		return fmt.Errorf("endpoint %q has no host name", config.Host)
+	}
+	if config.TLSClientConfig.ServerName == "" {
+		config.TLSClientConfig.ServerName = endpoint.Hostname()
+	}
+	config.Host = "https://" + tunnel
+	return nil
+}
+
+// FromEnv applies the tunnel of EKS_API_TUNNEL to config.
+func FromEnv(config *rest.Config) error {
+	return Apply(config, os.Getenv(EnvVar))
+}
@@ -0,0 +1,41 @@
+package kubetunnel
+
+import (
+	"testing"
+
+	"k8s.io/client-go/rest"
+)
+
+func TestApply(t *testing.T) {
+	config := &rest.Config{Host: "https://0123456789ABCDEF.gr7.eu-central-1.eks.amazonaws.com"}
+	if err := Apply(config, "localhost:8443"); err != nil {
+		t.Fatal(err)
+	}
+	if config.Host != "https://localhost:8443" {
+		t.Errorf("Host = %q", config.Host)
+	}
+	if config.TLSClientConfig.ServerName != "0123456789ABCDEF.gr7.eu-central-1.eks.amazonaws.com" {
+		t.Errorf("ServerName = %q", config.TLSClientConfig.ServerName)
+	}
+}
+
+func TestApplyWithoutTunnel(t *testing.T) {
+	config := &rest.Config{Host: "https://0123456789ABCDEF.gr7.eu-central-1.eks.amazonaws.com"}
+	if err := Apply(config, ""); err != nil {
+		t.Fatal(err)
+	}
+	if config.Host != "https://0123456789ABCDEF.gr7.eu-central-1.eks.amazonaws.com" || config.TLSClientConfig.ServerName != "" {
+		t.Errorf("config changed: %+v", config)
+	}
+}
+
+func TestApplyInvalid(t *testing.T) {
+	for _, tc := range []struct{ host, tunnel string }{
+		{"https://0123456789ABCDEF.gr7.eu-central-1.eks.amazonaws.com", "https://localhost:8443"},
+		{"", "localhost:8443"},
+	} {
+		if err := Apply(&rest.Config{Host: tc.host}, tc.tunnel); err == nil {
+			t.Errorf("no error for host %q and tunnel %q", tc.host, tc.tunnel)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Package podidentity builds IAM roles for Kubernetes service accounts with
+// EKS Pod Identity.
+//
+// Unlike IRSA, the role trusts the static pods.eks.amazonaws.com principal
+// and is bound to the service account by an AWS::EKS::PodIdentityAssociation,
+// so nothing depends on the cluster's OIDC issuer. The cluster needs the
+// eks-pod-identity-agent addon.
+package podidentity
+
+import (
+	"CDK/pkg/irsa"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseks"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/constructs-go/constructs/v10"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// AgentAddonName is the EKS addon running the Pod Identity agent.
+const AgentAddonName = "eks-pod-identity-agent"
+
+// ServicePrincipal is the principal EKS Pod Identity assumes roles as.
+const ServicePrincipal = "pods.eks.amazonaws.com"
+
+// ServiceAccountRoleProps configures a ServiceAccountRole.
+type ServiceAccountRoleProps struct {
+	// ClusterName is the EKS cluster of the service account.
+	ClusterName *string
+	// Namespace and ServiceAccount name the Kubernetes service account
+	// allowed to assume the role.
+	Namespace      string
+	ServiceAccount string
+
+	// RoleName is the IAM role name. CloudFormation generates one when nil.
+	RoleName *string
+	// ManagedPolicies are attached to the role.
+	ManagedPolicies []awsiam.IManagedPolicy
+	// InlinePolicies are added to the role, by policy name.
+	InlinePolicies map[string]awsiam.PolicyDocument
+}
+
+// ServiceAccountRole is an IAM role associated with one Kubernetes service
+// account through EKS Pod Identity.
+type ServiceAccountRole struct {
+	// Construct scopes the role and its association, e.g. for dependencies.
+	Construct   constructs.Construct
+	Role        awsiam.Role
+	Association awscdk.CfnResource
+}
+
+// NewServiceAccountRole creates the role and its Pod Identity association.
+func NewServiceAccountRole(scope constructs.Construct, id string, props *ServiceAccountRoleProps) *ServiceAccountRole {
+	construct := constructs.NewConstruct(scope, &id)
+
+	// The agent assumes the role and tags the session with the pod's
+	// cluster, namespace and service account
+	principal := awsiam.NewServicePrincipal(jsii.String(ServicePrincipal), nil).
+		WithSessionTags()
+
+	role := awsiam.NewRole(construct, jsii.String("Role"), &awsiam.RoleProps{
+		RoleName:        props.RoleName,
+		AssumedBy:       principal,
+		ManagedPolicies: &props.ManagedPolicies,
+		InlinePolicies:  irsa.InlinePolicies(props.InlinePolicies),
+	})
+
+	// aws-cdk-go v2.101 and v2.102, the releases of this repo, have no
+	// awseks.CfnPodIdentityAssociation yet, so a raw CfnResource of the
+	// CloudFormation type stands in for it. Its properties are those of
+	// AWS::EKS::PodIdentityAssociation; switch to the L1 after upgrading
+	association := awscdk.NewCfnResource(construct, jsii.String("Association"), &awscdk.CfnResourceProps{
+		Type: jsii.String("AWS::EKS::PodIdentityAssociation"),
+		Properties: &map[string]interface{}{
+			"ClusterName":    props.ClusterName,
+			"Namespace":      props.Namespace,
+			"ServiceAccount": props.ServiceAccount,
+			"RoleArn":        role.RoleArn(),
+		},
+	})
+
+	return &ServiceAccountRole{Construct: construct, Role: role, Association: association}
+}
+
+// NewAgentAddon installs the Pod Identity agent on the cluster, with the
+// default version for its Kubernetes version when version is nil.
+func NewAgentAddon(scope constructs.Construct, id string, clusterName, version *string) awseks.CfnAddon {
+	return awseks.NewCfnAddon(scope, &id, &awseks.CfnAddonProps{
+		ClusterName:  clusterName,
+		AddonName:    jsii.String(AgentAddonName),
+		AddonVersion: version,
+	})
+}
@@ -0,0 +1,56 @@
+package podidentity
+
+import (
+	"testing"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/jsii-runtime-go"
+)
+
+func TestServiceAccountRole(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("TestStack"), nil)
+
+	NewAgentAddon(stack, "Agent", jsii.String("SonarAWSTuto02"), nil)
+	NewServiceAccountRole(stack, "EbsCsi", &ServiceAccountRoleProps{
+		ClusterName:    jsii.String("SonarAWSTuto02"),
+		Namespace:      "kube-system",
+		ServiceAccount: "ebs-csi-controller-sa",
+		RoleName:       jsii.String("SonarAWSTuto02CSIDriverRole"),
+		ManagedPolicies: []awsiam.IManagedPolicy{
+			awsiam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("service-role/AmazonEBSCSIDriverPolicy")),
+		},
+	})
+
+	template := assertions.Template_FromStack(stack, nil)
+
+	template.HasResourceProperties(jsii.String("AWS::EKS::Addon"), map[string]interface{}{
+		"AddonName":   "eks-pod-identity-agent",
+		"ClusterName": "SonarAWSTuto02",
+	})
+
+	template.HasResourceProperties(jsii.String("AWS::IAM::Role"), map[string]interface{}{
+		"RoleName": "SonarAWSTuto02CSIDriverRole",
+		"AssumeRolePolicyDocument": map[string]interface{}{
+			"Statement": []interface{}{
+				map[string]interface{}{
+					"Action":    []interface{}{"sts:AssumeRole", "sts:TagSession"},
+					"Effect":    "Allow",
+					"Principal": map[string]interface{}{"Service": "pods.eks.amazonaws.com"},
+				},
+			},
+			"Version": "2012-10-17",
+		},
+	})
+
+	template.HasResourceProperties(jsii.String("AWS::EKS::PodIdentityAssociation"), map[string]interface{}{
+		"ClusterName":    "SonarAWSTuto02",
+		"Namespace":      "kube-system",
+		"ServiceAccount": "ebs-csi-controller-sa",
+		"RoleArn": map[string]interface{}{
+			"Fn::GetAtt": []interface{}{assertions.Match_StringLikeRegexp(jsii.String("^EbsCsiRole")), "Arn"},
+		},
+	})
+}
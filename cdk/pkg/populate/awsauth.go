@@ -0,0 +1,84 @@
+package populate
+
+import (
+	"context"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	awsAuthNamespace = "kube-system"
+	awsAuthName      = "aws-auth"
+)
+
+// MapRole is one entry of the aws-auth mapRoles list.
+type MapRole struct {
+	RoleARN  string
+	Username string
+	Groups   []string
+}
+
+// AddAwsAuthRole adds role to the mapRoles of the aws-auth ConfigMap. It
+// reports whether the ConfigMap changed; a role ARN that is already mapped
+// is left alone.
+func AddAwsAuthRole(ctx context.Context, client kubernetes.Interface, role MapRole) (bool, error) {
+	changed := false
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		configMap, err := client.CoreV1().ConfigMaps(awsAuthNamespace).Get(ctx, awsAuthName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		mapRoles, added, err := addMapRole(configMap.Data["mapRoles"], role)
+		if err != nil || !added {
+			changed = false
+			return err
+		}
+
+		if configMap.Data == nil {
+			configMap.Data = make(map[string]string)
+		}
+		configMap.Data["mapRoles"] = mapRoles
+
+		_, err = client.CoreV1().ConfigMaps(awsAuthNamespace).Update(ctx, configMap, metav1.UpdateOptions{})
+		changed = err == nil
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("updating %s/%s: %w", awsAuthNamespace, awsAuthName, err)
+	}
+	return changed, nil
+}
+
+// addMapRole appends role to a mapRoles YAML list. Existing entries are kept
+// as they are, including keys this package doesn't know about.
+func addMapRole(mapRoles string, role MapRole) (string, bool, error) {
+	var entries []yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(mapRoles), &entries); err != nil {
+		return "", false, fmt.Errorf("parsing mapRoles: %w", err)
+	}
+
+	for _, entry := range entries {
+		for _, item := range entry {
+			if item.Key == "rolearn" && item.Value == role.RoleARN {
+				return mapRoles, false, nil
+			}
+		}
+	}
+
+	entries = append(entries, yaml.MapSlice{
+		{Key: "rolearn", Value: role.RoleARN},
+		{Key: "username", Value: role.Username},
+		{Key: "groups", Value: role.Groups},
+	})
+
+	out, err := yaml.Marshal(entries)
+	if err != nil {
+		return "", false, err
+	}
+	return string(out), true, nil
+}
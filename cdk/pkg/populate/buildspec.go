@@ -0,0 +1,62 @@
+package populate
+
+import (
+	"gopkg.in/yaml.v2"
+)
+
+// BuildSpecValues are the buildspec.yml settings that depend on the deployed
+// stacks. Empty values are left as they are in the file.
+type BuildSpecValues struct {
+	SonarTokenSecret   string // env.secrets-manager.SONAR_TOKEN
+	SonarHostURLSecret string // env.secrets-manager.SONAR_HOST_URL
+	ImageRepoName      string // env.variables.IMAGE_REPO_NAME
+	EKSClusterName     string // env.variables.EKS_CLUSTER_NAME
+	EKSRole            string // env.variables.EKS_ROLE
+}
+
+// PatchBuildSpec sets values in a buildspec document. Everything else in the
+// document, including key order and keys unknown to this package, is kept.
+func PatchBuildSpec(content []byte, values BuildSpecValues) ([]byte, error) {
+	var spec yaml.MapSlice
+	if err := yaml.Unmarshal(content, &spec); err != nil {
+		return nil, err
+	}
+
+	for _, patch := range []struct {
+		path  []string
+		value string
+	}{
+		{[]string{"env", "secrets-manager", "SONAR_TOKEN"}, values.SonarTokenSecret},
+		{[]string{"env", "secrets-manager", "SONAR_HOST_URL"}, values.SonarHostURLSecret},
+		{[]string{"env", "variables", "IMAGE_REPO_NAME"}, values.ImageRepoName},
+		{[]string{"env", "variables", "EKS_CLUSTER_NAME"}, values.EKSClusterName},
+		{[]string{"env", "variables", "EKS_ROLE"}, values.EKSRole},
+	} {
+		if patch.value != "" {
+			spec = setPath(spec, patch.path, patch.value)
+		}
+	}
+
+	return yaml.Marshal(spec)
+}
+
+// setPath sets the value at path, creating intermediate maps as needed.
+func setPath(m yaml.MapSlice, path []string, value interface{}) yaml.MapSlice {
+	for i := range m {
+		if m[i].Key != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			m[i].Value = value
+		} else {
+			child, _ := m[i].Value.(yaml.MapSlice)
+			m[i].Value = setPath(child, path[1:], value)
+		}
+		return m
+	}
+
+	if len(path) == 1 {
+		return append(m, yaml.MapItem{Key: path[0], Value: value})
+	}
+	return append(m, yaml.MapItem{Key: path[0], Value: setPath(nil, path[1:], value)})
+}
@@ -0,0 +1,145 @@
+package populate
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Git is the git client used to copy the sample application.
+type Git interface {
+	// Clone clones url into dir with a local branch for every remote branch.
+	Clone(url, dir string) error
+	// Checkout switches the worktree of dir to branch.
+	Checkout(dir, branch string) error
+	// CommitFile commits file, as it is in the worktree, on the current branch.
+	CommitFile(dir, file, message string) error
+	// CopyFile commits file as it is on fromBranch onto toBranch.
+	CopyFile(dir, fromBranch, toBranch, file, message string) error
+	// PushAll pushes every local branch of dir to remoteURL.
+	PushAll(dir, remoteURL string) error
+}
+
+// GoGit implements Git with go-git. Pushes go through the git command line
+// because codecommit:// remotes need the git-remote-codecommit helper.
+type GoGit struct {
+	AuthorName  string
+	AuthorEmail string
+}
+
+func (g *GoGit) Clone(url, dir string) error {
+	repo, err := git.PlainClone(dir, false, &git.CloneOptions{
+		URL: url,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Fetch all references (branches) from the remote repository
+	err = repo.Fetch(&git.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs: []gitconfig.RefSpec{
+			gitconfig.RefSpec("+refs/heads/*:refs/heads/*"),
+		},
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+	return nil
+}
+
+func (g *GoGit) Checkout(dir, branch string) error {
+	_, worktree, err := openWorktree(dir)
+	if err != nil {
+		return err
+	}
+	return worktree.Checkout(&git.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName(branch),
+		Keep:   true,
+	})
+}
+
+func (g *GoGit) CommitFile(dir, file, message string) error {
+	_, worktree, err := openWorktree(dir)
+	if err != nil {
+		return err
+	}
+	if _, err := worktree.Add(file); err != nil {
+		return err
+	}
+	_, err = worktree.Commit(message, &git.CommitOptions{
+		Author: g.signature(),
+	})
+	return err
+}
+
+func (g *GoGit) CopyFile(dir, fromBranch, toBranch, file, message string) error {
+	repo, worktree, err := openWorktree(dir)
+	if err != nil {
+		return err
+	}
+
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName(fromBranch), true)
+	if err != nil {
+		return err
+	}
+	commit, err := repo.CommitObject(ref.Hash())
+	if err != nil {
+		return err
+	}
+	source, err := commit.File(file)
+	if err != nil {
+		return err
+	}
+	content, err := source.Contents()
+	if err != nil {
+		return err
+	}
+
+	if err := worktree.Checkout(&git.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName(toBranch),
+	}); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, file), []byte(content), 0o644); err != nil {
+		return err
+	}
+	return g.CommitFile(dir, file, message)
+}
+
+func (g *GoGit) PushAll(dir, remoteURL string) error {
+	cmd := exec.Command("git", "push", "--all", remoteURL)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (g *GoGit) signature() *object.Signature {
+	return &object.Signature{
+		Name:  g.AuthorName,
+		Email: g.AuthorEmail,
+		When:  time.Now(),
+	}
+}
+
+func openWorktree(dir string) (*git.Repository, *git.Worktree, error) {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, nil, err
+	}
+	return repo, worktree, nil
+}
@@ -0,0 +1,187 @@
+// Package populate copies the sample Java application into the CodeCommit
+// repository created by DevopsStack and grants its build role access to the
+// EKS cluster.
+//
+// Every external system is reached through an interface so the flow can be
+// tested without AWS credentials or a cluster.
+package populate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"CDK/pkg/stackoutputs"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/codecommit"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"k8s.io/client-go/kubernetes"
+)
+
+// IAMAPI is the subset of the IAM client used to edit trust policies.
+// *iam.IAM satisfies it.
+type IAMAPI interface {
+	GetRole(*iam.GetRoleInput) (*iam.GetRoleOutput, error)
+	UpdateAssumeRolePolicy(*iam.UpdateAssumeRolePolicyInput) (*iam.UpdateAssumeRolePolicyOutput, error)
+}
+
+// CodeCommitAPI is the subset of the CodeCommit client used to wait for the
+// repository. *codecommit.CodeCommit satisfies it.
+type CodeCommitAPI interface {
+	GetRepository(*codecommit.GetRepositoryInput) (*codecommit.GetRepositoryOutput, error)
+}
+
+// Clients groups the external systems the flow talks to.
+type Clients struct {
+	IAM            IAMAPI
+	CloudFormation stackoutputs.CloudFormationAPI
+	CodeCommit     CodeCommitAPI
+	Kubernetes     kubernetes.Interface
+	Git            Git
+}
+
+// Config describes one populate run.
+type Config struct {
+	// RepoName is the CodeCommit repository, also used as the clone directory.
+	RepoName string
+	// SourceRepoURL is the sample application to copy.
+	SourceRepoURL string
+	// RemoteURL is the CodeCommit push URL (codecommit://profile@repo).
+	RemoteURL string
+	// MainBranch receives the patched buildspec; SecondBranch, when set,
+	// gets a copy of it.
+	MainBranch   string
+	SecondBranch string
+	// BuildSpecFile is the buildspec path inside the repository.
+	BuildSpecFile string
+	BuildSpec     BuildSpecValues
+
+	// DevopsStackName and BuildRoleOutputKey locate the build role ARN.
+	DevopsStackName    string
+	BuildRoleOutputKey string
+	// EksAdminRole is the role name the build role must be able to assume.
+	EksAdminRole string
+
+	// WorkDir holds the temporary clone. Defaults to the current directory.
+	WorkDir string
+	// PollInterval is the delay between CodeCommit checks. Defaults to 10s.
+	PollInterval time.Duration
+}
+
+// Run executes the whole populate flow. progress, when not nil, is called
+// after each completed step.
+func Run(ctx context.Context, cfg Config, clients Clients, progress func(string)) error {
+	step := func(msg string) {
+		if progress != nil {
+			progress(msg)
+		}
+	}
+
+	if err := WaitForRepository(ctx, clients.CodeCommit, cfg.RepoName, cfg.PollInterval); err != nil {
+		return err
+	}
+	step("CodeCommit repository created successful.")
+
+	outputs, err := stackoutputs.Lookup(clients.CloudFormation, cfg.DevopsStackName)
+	if err != nil {
+		return err
+	}
+	buildRoleArn, err := outputs.String(cfg.BuildRoleOutputKey)
+	if err != nil {
+		return err
+	}
+
+	if _, err := AddTrustedPrincipal(clients.IAM, cfg.EksAdminRole, buildRoleArn); err != nil {
+		return err
+	}
+	step("Successfully updated EKS Admin Role.")
+
+	if _, err := AddAwsAuthRole(ctx, clients.Kubernetes, MapRole{
+		RoleARN:  buildRoleArn,
+		Username: "admin",
+		Groups:   []string{"system:masters"},
+	}); err != nil {
+		return err
+	}
+	step("Successfully updated aws-auth ConfigMap.")
+
+	dir := filepath.Join(cfg.WorkDir, cfg.RepoName)
+	if err := clients.Git.Clone(cfg.SourceRepoURL, dir); err != nil {
+		return fmt.Errorf("cloning %s: %w", cfg.SourceRepoURL, err)
+	}
+	defer os.RemoveAll(dir)
+	step("Clone GitHub App Java Demo is successful.")
+
+	if err := updateBuildSpec(dir, cfg, clients.Git); err != nil {
+		return err
+	}
+	step("Modify buildspec.yaml is successful.")
+
+	if err := clients.Git.PushAll(dir, cfg.RemoteURL); err != nil {
+		return fmt.Errorf("pushing to %s: %w", cfg.RemoteURL, err)
+	}
+	step("Push Repository in CodeCommit Repository is successful.")
+
+	return nil
+}
+
+func updateBuildSpec(dir string, cfg Config, git Git) error {
+	if err := git.Checkout(dir, cfg.MainBranch); err != nil {
+		return fmt.Errorf("checking out %s: %w", cfg.MainBranch, err)
+	}
+
+	path := filepath.Join(dir, cfg.BuildSpecFile)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	patched, err := PatchBuildSpec(content, cfg.BuildSpec)
+	if err != nil {
+		return fmt.Errorf("patching %s: %w", cfg.BuildSpecFile, err)
+	}
+	if err := os.WriteFile(path, patched, 0o644); err != nil {
+		return err
+	}
+
+	message := "Update " + cfg.BuildSpecFile
+	if err := git.CommitFile(dir, cfg.BuildSpecFile, message); err != nil {
+		return fmt.Errorf("committing %s: %w", cfg.BuildSpecFile, err)
+	}
+	if cfg.SecondBranch == "" {
+		return nil
+	}
+	if err := git.CopyFile(dir, cfg.MainBranch, cfg.SecondBranch, cfg.BuildSpecFile, message); err != nil {
+		return fmt.Errorf("copying %s to %s: %w", cfg.BuildSpecFile, cfg.SecondBranch, err)
+	}
+	return nil
+}
+
+// WaitForRepository polls CodeCommit until repoName exists or ctx is done.
+func WaitForRepository(ctx context.Context, api CodeCommitAPI, repoName string, interval time.Duration) error {
+	if interval == 0 {
+		interval = 10 * time.Second
+	}
+	for {
+		_, err := api.GetRepository(&codecommit.GetRepositoryInput{
+			RepositoryName: aws.String(repoName),
+		})
+		if err == nil {
+			return nil
+		}
+		var aerr awserr.Error
+		if !errors.As(err, &aerr) || aerr.Code() != codecommit.ErrCodeRepositoryDoesNotExistException {
+			return fmt.Errorf("getting repository %s: %w", repoName, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for repository %s: %w", repoName, ctx.Err())
+		case <-time.After(interval):
+		}
+	}
+}
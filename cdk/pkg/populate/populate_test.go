@@ -0,0 +1,334 @@
+package populate
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/codecommit"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	adminRole    = "SonarAWSTuto02AdminRole"
+	buildRoleArn = "arn:aws:iam::123456789012:role/BuildAdminRole02"
+	nodeRoleArn  = "arn:aws:iam::123456789012:role/NodeInstanceRole"
+)
+
+// fakeIAM keeps trust policies in memory, URL encoded like IAM returns them.
+type fakeIAM struct {
+	policies map[string]string
+	updates  int
+}
+
+func (f *fakeIAM) GetRole(in *iam.GetRoleInput) (*iam.GetRoleOutput, error) {
+	policy, ok := f.policies[aws.StringValue(in.RoleName)]
+	if !ok {
+		return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "role not found", nil)
+	}
+	return &iam.GetRoleOutput{Role: &iam.Role{
+		RoleName:                 in.RoleName,
+		AssumeRolePolicyDocument: aws.String(url.QueryEscape(policy)),
+	}}, nil
+}
+
+func (f *fakeIAM) UpdateAssumeRolePolicy(in *iam.UpdateAssumeRolePolicyInput) (*iam.UpdateAssumeRolePolicyOutput, error) {
+	f.policies[aws.StringValue(in.RoleName)] = aws.StringValue(in.PolicyDocument)
+	f.updates++
+	return &iam.UpdateAssumeRolePolicyOutput{}, nil
+}
+
+type fakeCloudFormation struct{}
+
+func (fakeCloudFormation) DescribeStacks(in *cloudformation.DescribeStacksInput) (*cloudformation.DescribeStacksOutput, error) {
+	return &cloudformation.DescribeStacksOutput{Stacks: []*cloudformation.Stack{{
+		StackName:   in.StackName,
+		StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+		Outputs: []*cloudformation.Output{
+			{OutputKey: aws.String("ARNRoleBuildProject"), OutputValue: aws.String(buildRoleArn)},
+			{OutputKey: aws.String("SomethingElse"), OutputValue: aws.String("not-a-role")},
+		},
+	}}}, nil
+}
+
+func (fakeCloudFormation) ListExportsPages(*cloudformation.ListExportsInput, func(*cloudformation.ListExportsOutput, bool) bool) error {
+	return nil
+}
+
+// fakeCodeCommit reports the repository missing for the first calls.
+type fakeCodeCommit struct {
+	missing int
+}
+
+func (f *fakeCodeCommit) GetRepository(in *codecommit.GetRepositoryInput) (*codecommit.GetRepositoryOutput, error) {
+	if f.missing > 0 {
+		f.missing--
+		return nil, awserr.New(codecommit.ErrCodeRepositoryDoesNotExistException, "not yet", nil)
+	}
+	return &codecommit.GetRepositoryOutput{}, nil
+}
+
+func eksTrustPolicy() string {
+	return `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Service":"eks.amazonaws.com"},"Action":"sts:AssumeRole"}]}`
+}
+
+func awsAuthConfigMap() *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: awsAuthName, Namespace: awsAuthNamespace},
+		Data: map[string]string{
+			"mapRoles": "- rolearn: " + nodeRoleArn + "\n  username: system:node:{{EC2PrivateDNSName}}\n  groups:\n  - system:bootstrappers\n  - system:nodes\n",
+		},
+	}
+}
+
+func TestAddTrustedPrincipal(t *testing.T) {
+	api := &fakeIAM{policies: map[string]string{adminRole: eksTrustPolicy()}}
+
+	changed, err := AddTrustedPrincipal(api, adminRole, buildRoleArn)
+	if err != nil || !changed {
+		t.Fatalf("first call: changed=%v err=%v", changed, err)
+	}
+
+	var policy struct {
+		Statement []struct {
+			Effect    string
+			Principal map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal([]byte(api.policies[adminRole]), &policy); err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.Statement) != 2 || policy.Statement[1].Principal["AWS"] != buildRoleArn {
+		t.Fatalf("unexpected policy: %s", api.policies[adminRole])
+	}
+
+	// Running it again must not add a duplicate statement
+	changed, err = AddTrustedPrincipal(api, adminRole, buildRoleArn)
+	if err != nil || changed || api.updates != 1 {
+		t.Fatalf("second call: changed=%v err=%v updates=%d", changed, err, api.updates)
+	}
+
+	if _, err := AddTrustedPrincipal(api, "MissingRole", buildRoleArn); err == nil {
+		t.Error("missing role should fail")
+	}
+}
+
+func TestAddTrustStatementSingleObject(t *testing.T) {
+	document := `{"Version":"2012-10-17","Statement":{"Effect":"Allow","Principal":{"AWS":["arn:aws:iam::123456789012:root","` + buildRoleArn + `"]},"Action":"sts:AssumeRole"}}`
+
+	_, changed, err := addTrustStatement([]byte(document), buildRoleArn)
+	if err != nil || changed {
+		t.Fatalf("principal in a list should be found: changed=%v err=%v", changed, err)
+	}
+
+	updated, changed, err := addTrustStatement([]byte(document), nodeRoleArn)
+	if err != nil || !changed || !strings.Contains(string(updated), nodeRoleArn) {
+		t.Fatalf("changed=%v err=%v policy=%s", changed, err, updated)
+	}
+}
+
+func TestAddAwsAuthRole(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(awsAuthConfigMap())
+	role := MapRole{RoleARN: buildRoleArn, Username: "admin", Groups: []string{"system:masters"}}
+
+	changed, err := AddAwsAuthRole(ctx, client, role)
+	if err != nil || !changed {
+		t.Fatalf("first call: changed=%v err=%v", changed, err)
+	}
+
+	configMap, err := client.CoreV1().ConfigMaps(awsAuthNamespace).Get(ctx, awsAuthName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []map[string]interface{}
+	if err := yaml.Unmarshal([]byte(configMap.Data["mapRoles"]), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected node and build roles, got %v", entries)
+	}
+	if entries[0]["username"] != "system:node:{{EC2PrivateDNSName}}" {
+		t.Errorf("node role entry changed: %v", entries[0])
+	}
+	if entries[1]["rolearn"] != buildRoleArn || entries[1]["username"] != "admin" {
+		t.Errorf("unexpected build role entry: %v", entries[1])
+	}
+
+	changed, err = AddAwsAuthRole(ctx, client, role)
+	if err != nil || changed {
+		t.Fatalf("second call: changed=%v err=%v", changed, err)
+	}
+
+	if _, err := AddAwsAuthRole(ctx, fake.NewSimpleClientset(), role); err == nil {
+		t.Error("missing aws-auth ConfigMap should fail")
+	}
+}
+
+const sampleBuildSpec = `version: 0.2
+env:
+  secrets-manager:
+    SONAR_TOKEN: changeme
+    SONAR_HOST_URL: changeme
+  variables:
+    IMAGE_REPO_NAME: changeme
+    IMAGE_TAG: latest
+    EKS_CLUSTER_NAME: changeme
+  shell: bash
+phases:
+  build:
+    commands:
+    - mvn verify sonar:sonar
+reports:
+  junit:
+    files:
+    - target/surefire-reports/*.xml
+`
+
+func TestPatchBuildSpec(t *testing.T) {
+	patched, err := PatchBuildSpec([]byte(sampleBuildSpec), BuildSpecValues{
+		SonarTokenSecret:   "prod/sonar02:SONAR_TOKEN",
+		SonarHostURLSecret: "prod/sonar02:SONAR_HOST_URL",
+		ImageRepoName:      "app-container-repo-02",
+		EKSClusterName:     "SonarAWSTuto02",
+		EKSRole:            adminRole,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var spec struct {
+		Env struct {
+			SecretsManager map[string]string `yaml:"secrets-manager"`
+			Variables      map[string]string `yaml:"variables"`
+			Shell          string            `yaml:"shell"`
+		} `yaml:"env"`
+		Reports map[string]interface{} `yaml:"reports"`
+	}
+	if err := yaml.Unmarshal(patched, &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]string{
+		"SONAR_TOKEN":    "prod/sonar02:SONAR_TOKEN",
+		"SONAR_HOST_URL": "prod/sonar02:SONAR_HOST_URL",
+	} {
+		if got := spec.Env.SecretsManager[key]; got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	for key, want := range map[string]string{
+		"IMAGE_REPO_NAME":  "app-container-repo-02",
+		"IMAGE_TAG":        "latest",
+		"EKS_CLUSTER_NAME": "SonarAWSTuto02",
+		"EKS_ROLE":         adminRole,
+	} {
+		if got := spec.Env.Variables[key]; got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if spec.Env.Shell != "bash" || spec.Reports["junit"] == nil {
+		t.Errorf("keys outside the patch were lost:\n%s", patched)
+	}
+}
+
+func TestRun(t *testing.T) {
+	root := t.TempDir()
+	source := newSourceRepo(t, filepath.Join(root, "source.git"))
+	remote := filepath.Join(root, "remote.git")
+	runGit(t, root, "init", "--bare", "-b", "main", remote)
+
+	iamAPI := &fakeIAM{policies: map[string]string{adminRole: eksTrustPolicy()}}
+	kube := fake.NewSimpleClientset(awsAuthConfigMap())
+
+	var steps []string
+	err := Run(context.Background(), Config{
+		RepoName:           "sonar-sample-app-02",
+		SourceRepoURL:      source,
+		RemoteURL:          remote,
+		MainBranch:         "main",
+		SecondBranch:       "new-service",
+		BuildSpecFile:      "buildspec.yml",
+		BuildSpec:          BuildSpecValues{ImageRepoName: "app-container-repo-02", EKSRole: adminRole},
+		DevopsStackName:    "DevopsStack02",
+		BuildRoleOutputKey: "ARNRoleBuildProject",
+		EksAdminRole:       adminRole,
+		WorkDir:            filepath.Join(root, "work"),
+		PollInterval:       time.Millisecond,
+	}, Clients{
+		IAM:            iamAPI,
+		CloudFormation: fakeCloudFormation{},
+		CodeCommit:     &fakeCodeCommit{missing: 2},
+		Kubernetes:     kube,
+		Git:            &GoGit{AuthorName: "EC", AuthorEmail: "ec@localhost.com"},
+	}, func(step string) { steps = append(steps, step) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(steps) != 6 {
+		t.Errorf("expected 6 progress steps, got %v", steps)
+	}
+	if !strings.Contains(iamAPI.policies[adminRole], buildRoleArn) {
+		t.Error("build role was not added to the admin role trust policy")
+	}
+	for _, branch := range []string{"main", "new-service"} {
+		content := runGit(t, remote, "show", branch+":buildspec.yml")
+		if !strings.Contains(content, "app-container-repo-02") || !strings.Contains(content, adminRole) {
+			t.Errorf("buildspec on %s was not patched:\n%s", branch, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "work", "sonar-sample-app-02")); !os.IsNotExist(err) {
+		t.Error("local clone was not removed")
+	}
+}
+
+func TestWaitForRepositoryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := WaitForRepository(ctx, &fakeCodeCommit{missing: 1}, "repo", time.Hour)
+	if err == nil || !strings.Contains(err.Error(), "context canceled") {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+}
+
+// newSourceRepo creates a bare repository with a main and a new-service
+// branch, both holding sampleBuildSpec.
+func newSourceRepo(t *testing.T, bare string) string {
+	t.Helper()
+	work := t.TempDir()
+	runGit(t, work, "init", "-b", "main")
+	if err := os.WriteFile(filepath.Join(work, "buildspec.yml"), []byte(sampleBuildSpec), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, work, "add", "buildspec.yml")
+	runGit(t, work, "commit", "-m", "initial")
+	runGit(t, work, "branch", "new-service")
+	runGit(t, work, "clone", "--bare", work, bare)
+	return bare
+}
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@localhost"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return string(out)
+}
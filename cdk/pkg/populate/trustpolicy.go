@@ -0,0 +1,107 @@
+package populate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// AddTrustedPrincipal lets principalArn assume roleName. It reports whether
+// the trust policy changed; a principal that is already trusted is left alone.
+func AddTrustedPrincipal(api IAMAPI, roleName, principalArn string) (bool, error) {
+	role, err := api.GetRole(&iam.GetRoleInput{
+		RoleName: aws.String(roleName),
+	})
+	if err != nil {
+		return false, fmt.Errorf("getting role %s: %w", roleName, err)
+	}
+
+	// IAM returns the trust policy URL encoded
+	document, err := url.QueryUnescape(aws.StringValue(role.Role.AssumeRolePolicyDocument))
+	if err != nil {
+		return false, fmt.Errorf("decoding trust policy of %s: %w", roleName, err)
+	}
+
+	updated, changed, err := addTrustStatement([]byte(document), principalArn)
+	if err != nil {
+		return false, fmt.Errorf("editing trust policy of %s: %w", roleName, err)
+	}
+	if !changed {
+		return false, nil
+	}
+
+	_, err = api.UpdateAssumeRolePolicy(&iam.UpdateAssumeRolePolicyInput{
+		RoleName:       aws.String(roleName),
+		PolicyDocument: aws.String(string(updated)),
+	})
+	if err != nil {
+		return false, fmt.Errorf("updating trust policy of %s: %w", roleName, err)
+	}
+	return true, nil
+}
+
+// addTrustStatement appends an sts:AssumeRole statement for principalArn to
+// a trust policy document, unless an Allow statement already covers it.
+func addTrustStatement(document []byte, principalArn string) ([]byte, bool, error) {
+	var policy map[string]interface{}
+	if err := json.Unmarshal(document, &policy); err != nil {
+		return nil, false, err
+	}
+
+	// A policy with a single statement may hold it as an object
+	var statements []interface{}
+	switch s := policy["Statement"].(type) {
+	case []interface{}:
+		statements = s
+	case map[string]interface{}:
+		statements = []interface{}{s}
+	case nil:
+	default:
+		return nil, false, fmt.Errorf("unexpected Statement type %T", s)
+	}
+
+	for _, statement := range statements {
+		if trustsPrincipal(statement, principalArn) {
+			return document, false, nil
+		}
+	}
+
+	policy["Statement"] = append(statements, map[string]interface{}{
+		"Effect": "Allow",
+		"Principal": map[string]interface{}{
+			"AWS": principalArn,
+		},
+		"Action": "sts:AssumeRole",
+	})
+
+	updated, err := json.Marshal(policy)
+	if err != nil {
+		return nil, false, err
+	}
+	return updated, true, nil
+}
+
+func trustsPrincipal(statement interface{}, principalArn string) bool {
+	st, ok := statement.(map[string]interface{})
+	if !ok || st["Effect"] != "Allow" {
+		return false
+	}
+	principal, ok := st["Principal"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	switch p := principal["AWS"].(type) {
+	case string:
+		return p == principalArn
+	case []interface{}:
+		for _, arn := range p {
+			if arn == principalArn {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,3 @@
+module CDK/pkg/snapshot
+
+go 1.21.1
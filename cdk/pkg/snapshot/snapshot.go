@@ -0,0 +1,70 @@
+// Package snapshot compares synthesized CloudFormation templates with golden
+// files kept next to the tests, so a review shows exactly what a change does
+// to the infrastructure.
+//
+// Golden files live in testdata/<name>.template.json. Regenerate them with:
+//
+//	go test ./... -update
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden template files")
+
+// Match compares template, as returned by assertions.Template.ToJSON, with
+// the golden file for name. A missing golden file is created.
+func Match(t *testing.T, name string, template interface{}) {
+	t.Helper()
+
+	got, err := json.MarshalIndent(template, "", "  ")
+	if err != nil {
+		t.Fatalf("marshalling template %s: %v", name, err)
+	}
+	got = append(got, '\n')
+
+	path := filepath.Join("testdata", name+".template.json")
+	want, err := os.ReadFile(path)
+	if *update || os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("wrote %s", path)
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, want) {
+		line, wantLine, gotLine := firstDiff(want, got)
+		t.Errorf("template %s differs from %s at line %d:\n- %s\n+ %s\nreview the change and run 'go test ./... -update' to accept it",
+			name, path, line, wantLine, gotLine)
+	}
+}
+
+func firstDiff(want, got []byte) (int, string, string) {
+	wantLines := bytes.Split(want, []byte("\n"))
+	gotLines := bytes.Split(got, []byte("\n"))
+	for i := 0; ; i++ {
+		var w, g []byte
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if !bytes.Equal(w, g) || i >= len(wantLines) || i >= len(gotLines) {
+			return i + 1, string(bytes.TrimSpace(w)), string(bytes.TrimSpace(g))
+		}
+	}
+}
@@ -0,0 +1,7 @@
+module CDK/pkg/stackoutputs
+
+go 1.21.1
+
+require github.com/aws/aws-sdk-go v1.47.9
+
+require github.com/jmespath/go-jmespath v0.4.0 // indirect
@@ -0,0 +1,199 @@
+// Package stackoutputs reads the outputs of a deployed CloudFormation stack
+// into typed values.
+//
+// Outputs are looked up by their logical key (the OutputKey CloudFormation
+// reports, e.g. "ARNRoleBuildProject") or by their export name, so adding
+// another output to a stack never changes which value a caller gets back.
+package stackoutputs
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+var (
+	// ErrStackNotFound is returned when the stack does not exist.
+	ErrStackNotFound = errors.New("stack not found")
+	// ErrStackNotComplete is returned when the stack exists but is not in a
+	// usable *_COMPLETE state.
+	ErrStackNotComplete = errors.New("stack not in a *_COMPLETE state")
+	// ErrOutputNotFound is returned when the stack has no output with the
+	// requested key or export name.
+	ErrOutputNotFound = errors.New("output not found")
+)
+
+// CloudFormationAPI is the subset of the CloudFormation client used by this
+// package. *cloudformation.CloudFormation satisfies it.
+type CloudFormationAPI interface {
+	DescribeStacks(*cloudformation.DescribeStacksInput) (*cloudformation.DescribeStacksOutput, error)
+	ListExportsPages(*cloudformation.ListExportsInput, func(*cloudformation.ListExportsOutput, bool) bool) error
+}
+
+// Outputs holds the outputs of one stack, indexed by key and export name.
+type Outputs struct {
+	StackName string
+	Status    string
+
+	byKey    map[string]string
+	byExport map[string]string
+}
+
+// Lookup describes stackName and returns its outputs. It fails with
+// ErrStackNotFound or ErrStackNotComplete when the outputs can't be trusted.
+func Lookup(api CloudFormationAPI, stackName string) (*Outputs, error) {
+	result, err := api.DescribeStacks(&cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackName),
+	})
+	if err != nil {
+		if isStackNotFound(err) {
+			return nil, fmt.Errorf("%w: %s", ErrStackNotFound, stackName)
+		}
+		return nil, fmt.Errorf("describing stack %s: %w", stackName, err)
+	}
+	if len(result.Stacks) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrStackNotFound, stackName)
+	}
+
+	stack := result.Stacks[0]
+	status := aws.StringValue(stack.StackStatus)
+	if !IsComplete(status) {
+		return nil, fmt.Errorf("%w: %s is %s", ErrStackNotComplete, stackName, status)
+	}
+
+	outputs := &Outputs{
+		StackName: stackName,
+		Status:    status,
+		byKey:     make(map[string]string),
+		byExport:  make(map[string]string),
+	}
+	for _, output := range stack.Outputs {
+		value := aws.StringValue(output.OutputValue)
+		outputs.byKey[aws.StringValue(output.OutputKey)] = value
+		if output.ExportName != nil {
+			outputs.byExport[aws.StringValue(output.ExportName)] = value
+		}
+	}
+	return outputs, nil
+}
+
+// IsComplete reports whether a stack in the given status has reliable
+// outputs. ROLLBACK_COMPLETE (failed creation) and DELETE_COMPLETE are
+// terminal but leave nothing usable behind, so they don't count.
+func IsComplete(status string) bool {
+	switch status {
+	case cloudformation.StackStatusRollbackComplete, cloudformation.StackStatusDeleteComplete:
+		return false
+	}
+	return strings.HasSuffix(status, "_COMPLETE")
+}
+
+// String returns the output with the given logical key.
+func (o *Outputs) String(key string) (string, error) {
+	value, ok := o.byKey[key]
+	if !ok {
+		return "", fmt.Errorf("%w: %s has no output %q", ErrOutputNotFound, o.StackName, key)
+	}
+	return value, nil
+}
+
+// Export returns the output exported under the given name.
+func (o *Outputs) Export(name string) (string, error) {
+	value, ok := o.byExport[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s has no export %q", ErrOutputNotFound, o.StackName, name)
+	}
+	return value, nil
+}
+
+// ARN returns the output with the given key parsed as an ARN.
+func (o *Outputs) ARN(key string) (arn.ARN, error) {
+	value, err := o.String(key)
+	if err != nil {
+		return arn.ARN{}, err
+	}
+	parsed, err := arn.Parse(value)
+	if err != nil {
+		return arn.ARN{}, fmt.Errorf("output %q of %s is not an ARN: %w", key, o.StackName, err)
+	}
+	return parsed, nil
+}
+
+// Int returns the output with the given key parsed as an integer.
+func (o *Outputs) Int(key string) (int, error) {
+	value, err := o.String(key)
+	if err != nil {
+		return 0, err
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("output %q of %s is not an integer: %w", key, o.StackName, err)
+	}
+	return parsed, nil
+}
+
+// Bool returns the output with the given key parsed as a boolean.
+func (o *Outputs) Bool(key string) (bool, error) {
+	value, err := o.String(key)
+	if err != nil {
+		return false, err
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("output %q of %s is not a boolean: %w", key, o.StackName, err)
+	}
+	return parsed, nil
+}
+
+// StringList returns the output with the given key split on commas, the
+// way CloudFormation joins list values (Fn::Join with ",").
+func (o *Outputs) StringList(key string) ([]string, error) {
+	value, err := o.String(key)
+	if err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return nil, nil
+	}
+	return strings.Split(value, ","), nil
+}
+
+// Export returns the value of a region-wide CloudFormation export, whichever
+// stack created it.
+func Export(api CloudFormationAPI, name string) (string, error) {
+	var value string
+	found := false
+	err := api.ListExportsPages(&cloudformation.ListExportsInput{}, func(page *cloudformation.ListExportsOutput, lastPage bool) bool {
+		for _, export := range page.Exports {
+			if aws.StringValue(export.Name) == name {
+				value = aws.StringValue(export.Value)
+				found = true
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return "", fmt.Errorf("listing exports: %w", err)
+	}
+	if !found {
+		return "", fmt.Errorf("%w: no export %q", ErrOutputNotFound, name)
+	}
+	return value, nil
+}
+
+// CloudFormation reports a missing stack as a ValidationError rather than a
+// dedicated error code.
+func isStackNotFound(err error) bool {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		return aerr.Code() == "ValidationError" && strings.Contains(aerr.Message(), "does not exist")
+	}
+	return false
+}
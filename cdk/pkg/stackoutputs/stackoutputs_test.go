@@ -0,0 +1,121 @@
+package stackoutputs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+type fakeCloudFormation struct {
+	stacks  map[string]*cloudformation.Stack
+	exports []*cloudformation.Export
+}
+
+func (f *fakeCloudFormation) DescribeStacks(in *cloudformation.DescribeStacksInput) (*cloudformation.DescribeStacksOutput, error) {
+	stack, ok := f.stacks[aws.StringValue(in.StackName)]
+	if !ok {
+		return nil, awserr.New("ValidationError", "Stack with id "+aws.StringValue(in.StackName)+" does not exist", nil)
+	}
+	return &cloudformation.DescribeStacksOutput{Stacks: []*cloudformation.Stack{stack}}, nil
+}
+
+func (f *fakeCloudFormation) ListExportsPages(in *cloudformation.ListExportsInput, fn func(*cloudformation.ListExportsOutput, bool) bool) error {
+	fn(&cloudformation.ListExportsOutput{Exports: f.exports}, true)
+	return nil
+}
+
+func newFake() *fakeCloudFormation {
+	return &fakeCloudFormation{
+		stacks: map[string]*cloudformation.Stack{
+			"DevopsStack02": {
+				StackStatus: aws.String(cloudformation.StackStatusUpdateComplete),
+				Outputs: []*cloudformation.Output{
+					{OutputKey: aws.String("ARNRoleBuildProject"), OutputValue: aws.String("arn:aws:iam::123456789012:role/BuildAdminRole02")},
+					{OutputKey: aws.String("PipelineName"), OutputValue: aws.String("main-java-code-build-02"), ExportName: aws.String("DevopsStack02-PipelineName")},
+					{OutputKey: aws.String("Replicas"), OutputValue: aws.String("3")},
+					{OutputKey: aws.String("Subnets"), OutputValue: aws.String("subnet-a,subnet-b")},
+				},
+			},
+			"EksStack02": {
+				StackStatus: aws.String(cloudformation.StackStatusCreateInProgress),
+			},
+		},
+		exports: []*cloudformation.Export{
+			{Name: aws.String("DevopsStack02-PipelineName"), Value: aws.String("main-java-code-build-02")},
+		},
+	}
+}
+
+func TestLookupTypedValues(t *testing.T) {
+	outputs, err := Lookup(newFake(), "DevopsStack02")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	role, err := outputs.ARN("ARNRoleBuildProject")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role.Resource != "role/BuildAdminRole02" {
+		t.Errorf("role resource = %q", role.Resource)
+	}
+
+	if n, err := outputs.Int("Replicas"); err != nil || n != 3 {
+		t.Errorf("Int = %d, %v", n, err)
+	}
+	if subnets, err := outputs.StringList("Subnets"); err != nil || len(subnets) != 2 {
+		t.Errorf("StringList = %v, %v", subnets, err)
+	}
+	if name, err := outputs.Export("DevopsStack02-PipelineName"); err != nil || name != "main-java-code-build-02" {
+		t.Errorf("Export = %q, %v", name, err)
+	}
+	if _, err := outputs.Int("PipelineName"); err == nil {
+		t.Error("Int on a non-numeric output should fail")
+	}
+}
+
+func TestLookupErrors(t *testing.T) {
+	api := newFake()
+
+	if _, err := Lookup(api, "MissingStack"); !errors.Is(err, ErrStackNotFound) {
+		t.Errorf("missing stack: got %v", err)
+	}
+	if _, err := Lookup(api, "EksStack02"); !errors.Is(err, ErrStackNotComplete) {
+		t.Errorf("in-progress stack: got %v", err)
+	}
+
+	outputs, err := Lookup(api, "DevopsStack02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := outputs.String("Nope"); !errors.Is(err, ErrOutputNotFound) {
+		t.Errorf("missing output: got %v", err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	api := newFake()
+
+	if value, err := Export(api, "DevopsStack02-PipelineName"); err != nil || value != "main-java-code-build-02" {
+		t.Errorf("Export = %q, %v", value, err)
+	}
+	if _, err := Export(api, "Nope"); !errors.Is(err, ErrOutputNotFound) {
+		t.Errorf("missing export: got %v", err)
+	}
+}
+
+func TestIsComplete(t *testing.T) {
+	for status, want := range map[string]bool{
+		cloudformation.StackStatusCreateComplete:         true,
+		cloudformation.StackStatusUpdateRollbackComplete: true,
+		cloudformation.StackStatusRollbackComplete:       false,
+		cloudformation.StackStatusUpdateInProgress:       false,
+	} {
+		if got := IsComplete(status); got != want {
+			t.Errorf("IsComplete(%s) = %v, want %v", status, got, want)
+		}
+	}
+}
@@ -1,8 +1,9 @@
 module vpc3
 
-go 1.18
+go 1.21.1
 
 require (
+	CDK/pkg/snapshot v1.0.0
 	github.com/aws/aws-cdk-go/awscdk/v2 v2.101.0
 	github.com/aws/constructs-go/constructs/v10 v10.2.70
 	github.com/aws/jsii-runtime-go v1.89.0
@@ -16,3 +17,5 @@ require (
 	github.com/cdklabs/awscdk-asset-node-proxy-agent-go/nodeproxyagentv6/v2 v2.0.1 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 )
+
+replace CDK/pkg/snapshot v1.0.0 => ../pkg/snapshot
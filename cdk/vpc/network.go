@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// SubnetGroup is a subnet per availability zone, of one type.
+type SubnetGroup struct {
+	Name string
+	// public, private (outbound through the NAT gateways) or isolated
+	Type string
+	// Size of each subnet, e.g. 24 for 256 addresses. The remaining space
+	// of the VPC is split evenly when 0
+	CidrMask float64
+}
+
+var subnetTypes = map[string]awsec2.SubnetType{
+	"public":   awsec2.SubnetType_PUBLIC,
+	"private":  awsec2.SubnetType_PRIVATE_WITH_EGRESS,
+	"isolated": awsec2.SubnetType_PRIVATE_ISOLATED,
+}
+
+// subnetConfiguration returns the subnet groups of the VPC, nil for the CDK
+// default of a public and a private group. It checks that the private
+// subnets have NAT gateways to go out through.
+func subnetConfiguration(AppConfig Configuration) (*[]*awsec2.SubnetConfiguration, error) {
+	count := map[string]int{"public": 0, "private": 0, "isolated": 0}
+	var groups []*awsec2.SubnetConfiguration
+	names := make(map[string]bool)
+	for _, group := range AppConfig.Subnets {
+		subnetType, ok := subnetTypes[group.Type]
+		if !ok {
+			return nil, fmt.Errorf("subnet group %q: unsupported Type %q, use public, private or isolated", group.Name, group.Type)
+		}
+		if group.Name == "" || names[group.Name] {
+			return nil, fmt.Errorf("subnet groups need a unique Name, got %q", group.Name)
+		}
+		if group.CidrMask != 0 && (group.CidrMask < 16 || group.CidrMask > 28) {
+			return nil, fmt.Errorf("subnet group %s: CidrMask must be between 16 and 28, got %v", group.Name, group.CidrMask)
+		}
+		names[group.Name] = true
+		count[group.Type]++
+		groups = append(groups, &awsec2.SubnetConfiguration{
+			Name:       jsii.String(group.Name),
+			SubnetType: subnetType,
+			CidrMask:   sizeOrNil(group.CidrMask),
+		})
+	}
+
+	natGateways := AppConfig.NatGateways
+	if groups == nil {
+		// The default layout has both
+		count["public"], count["private"] = 1, 1
+	}
+	switch {
+	case natGateways != nil && *natGateways < 0:
+		return nil, fmt.Errorf("NatGateways can't be negative")
+	case count["private"] > 0 && natGateways != nil && *natGateways == 0:
+		return nil, fmt.Errorf("private subnets go out through NAT gateways: set NatGateways, or use isolated subnets and VPC endpoints")
+	case count["private"] > 0 && count["public"] == 0:
+		return nil, fmt.Errorf("private subnets need a public subnet group for their NAT gateways")
+	case count["private"] == 0 && natGateways != nil && *natGateways > 0:
+		return nil, fmt.Errorf("NatGateways needs a private subnet group")
+	}
+
+	if groups == nil {
+		return nil, nil
+	}
+	return &groups, nil
+}
+
+// natProvider returns what the private subnets go out through: NAT gateways,
+// or NAT instances that cost less but are not managed by AWS.
+func natProvider(AppConfig Configuration) (awsec2.NatProvider, error) {
+	switch AppConfig.NatProvider {
+	case "", "gateway":
+		if AppConfig.NatInstanceType != "" {
+			return nil, fmt.Errorf("NatInstanceType needs NatProvider instance")
+		}
+		return awsec2.NatProvider_Gateway(nil), nil
+	case "instance":
+		instanceType := AppConfig.NatInstanceType
+		if instanceType == "" {
+			instanceType = "t3.micro"
+		}
+		// The NAT AMI is looked up once and cached in cdk.context.json.
+		// Inbound traffic is opened to the VPC only, once it exists
+		return awsec2.NatProvider_Instance(&awsec2.NatInstanceProps{
+			InstanceType:          awsec2.NewInstanceType(jsii.String(instanceType)),
+			DefaultAllowedTraffic: awsec2.NatTrafficDirection_OUTBOUND_ONLY,
+		}), nil
+	}
+	return nil, fmt.Errorf("unsupported NatProvider %q, use gateway or instance", AppConfig.NatProvider)
+}
+
+var gatewayEndpointServices = map[string]awsec2.GatewayVpcEndpointAwsService{
+	"s3":       awsec2.GatewayVpcEndpointAwsService_S3(),
+	"dynamodb": awsec2.GatewayVpcEndpointAwsService_DYNAMODB(),
+}
+
+// interfaceEndpointName is the short name of an AWS service endpoint, e.g.
+// ecr.dkr for com.amazonaws.<region>.ecr.dkr.
+var interfaceEndpointName = regexp.MustCompile(`^[a-z0-9-]+(\.[a-z0-9-]+)*$`)
+
+// checkEndpoints checks the names of the VPC endpoints.
+func checkEndpoints(AppConfig Configuration) error {
+	for _, name := range AppConfig.GatewayEndpoints {
+		if _, ok := gatewayEndpointServices[name]; !ok {
+			return fmt.Errorf("unsupported gateway endpoint %q, use s3 or dynamodb", name)
+		}
+	}
+	for _, name := range AppConfig.InterfaceEndpoints {
+		if !interfaceEndpointName.MatchString(name) {
+			return fmt.Errorf("invalid interface endpoint %q, e.g. ecr.dkr", name)
+		}
+	}
+	return nil
+}
+
+// addEndpoints adds the VPC endpoints of AppConfig, so that the private and
+// isolated subnets reach these AWS services without NAT. The interface
+// endpoints go to the private subnets, or the isolated ones when there are
+// none, and accept HTTPS from the VPC.
+func addEndpoints(vpc awsec2.Vpc, AppConfig Configuration) {
+	for _, name := range AppConfig.GatewayEndpoints {
+		vpc.AddGatewayEndpoint(jsii.String(name+"Endpoint"), &awsec2.GatewayVpcEndpointOptions{
+			Service: gatewayEndpointServices[name],
+		})
+	}
+	for _, name := range AppConfig.InterfaceEndpoints {
+		vpc.AddInterfaceEndpoint(jsii.String(name+"Endpoint"), &awsec2.InterfaceVpcEndpointOptions{
+			Service:           awsec2.NewInterfaceVpcEndpointAwsService(jsii.String(name), nil, nil),
+			PrivateDnsEnabled: jsii.Bool(true),
+		})
+	}
+}
+
+func sizeOrNil(size float64) *float64 {
+	if size == 0 {
+		return nil
+	}
+	return &size
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// IngressRule opens ports of the security group to one peer: a CIDR, a
+// managed prefix list or another security group.
+type IngressRule struct {
+	Description string
+	// tcp (default), udp, icmp (ICMPv6 for an IPv6 Cidr) or all. The ports
+	// are only set for tcp and udp
+	Protocol string
+	FromPort float64
+	// ToPort is FromPort when 0
+	ToPort          float64
+	Cidr            string
+	PrefixListId    string
+	SecurityGroupId string
+}
+
+// ingressPeer returns the peer of rule, which must set exactly one.
+func ingressPeer(rule IngressRule) (awsec2.IPeer, error) {
+	peers := 0
+	for _, value := range []string{rule.Cidr, rule.PrefixListId, rule.SecurityGroupId} {
+		if value != "" {
+			peers++
+		}
+	}
+	if peers != 1 {
+		return nil, fmt.Errorf("set one of Cidr, PrefixListId or SecurityGroupId")
+	}
+
+	switch {
+	case rule.Cidr != "":
+		ip, _, err := net.ParseCIDR(rule.Cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Cidr %q", rule.Cidr)
+		}
+		if ip.To4() == nil {
+			return awsec2.Peer_Ipv6(jsii.String(rule.Cidr)), nil
+		}
+		return awsec2.Peer_Ipv4(jsii.String(rule.Cidr)), nil
+	case rule.PrefixListId != "":
+		if !strings.HasPrefix(rule.PrefixListId, "pl-") {
+			return nil, fmt.Errorf("invalid PrefixListId %q, e.g. pl-0123456789abcdef0", rule.PrefixListId)
+		}
+		return awsec2.Peer_PrefixList(jsii.String(rule.PrefixListId)), nil
+	}
+	if !strings.HasPrefix(rule.SecurityGroupId, "sg-") {
+		return nil, fmt.Errorf("invalid SecurityGroupId %q, e.g. sg-0123456789abcdef0", rule.SecurityGroupId)
+	}
+	return awsec2.Peer_SecurityGroupId(jsii.String(rule.SecurityGroupId), nil), nil
+}
+
+// ingressPort returns the protocol and port range of rule.
+func ingressPort(rule IngressRule) (awsec2.Port, error) {
+	from, to := rule.FromPort, rule.ToPort
+	if to == 0 {
+		to = from
+	}
+
+	switch rule.Protocol {
+	case "", "tcp", "udp":
+		if from < 1 || to > 65535 || to < from {
+			return nil, fmt.Errorf("invalid port range %v-%v", from, to)
+		}
+		if rule.Protocol == "udp" {
+			return awsec2.Port_UdpRange(jsii.Number(from), jsii.Number(to)), nil
+		}
+		return awsec2.Port_TcpRange(jsii.Number(from), jsii.Number(to)), nil
+	case "icmp", "all":
+		if from != 0 || to != 0 {
+			return nil, fmt.Errorf("protocol %s takes no ports", rule.Protocol)
+		}
+		if rule.Protocol == "icmp" && strings.Contains(rule.Cidr, ":") {
+			return awsec2.Port_AllIcmpV6(), nil
+		}
+		if rule.Protocol == "icmp" {
+			return awsec2.Port_AllIcmp(), nil
+		}
+		return awsec2.Port_AllTraffic(), nil
+	}
+	return nil, fmt.Errorf("unsupported Protocol %q, use tcp, udp, icmp or all", rule.Protocol)
+}
+
+// addIngressRules adds the IngressRules of AppConfig to securityGroup.
+func addIngressRules(securityGroup awsec2.SecurityGroup, AppConfig Configuration) error {
+	for i, rule := range AppConfig.IngressRules {
+		peer, err := ingressPeer(rule)
+		if err != nil {
+			return fmt.Errorf("ingress rule %d: %w", i+1, err)
+		}
+		port, err := ingressPort(rule)
+		if err != nil {
+			return fmt.Errorf("ingress rule %d: %w", i+1, err)
+		}
+		var description *string
+		if rule.Description != "" {
+			description = jsii.String(rule.Description)
+		}
+		securityGroup.AddIngressRule(peer, port, description, jsii.Bool(false))
+	}
+	return nil
+}
+
+// newSecurityGroup creates the security group of the stack id in vpc, with
+// the IngressRules of AppConfig, and exports its ID as id-SecurityGroupId.
+func newSecurityGroup(stack awscdk.Stack, id string, vpc awsec2.IVpc, name string, AppConfig Configuration) awsec2.SecurityGroup {
+	securityGroup := awsec2.NewSecurityGroup(stack, &name, &awsec2.SecurityGroupProps{
+		Vpc:               vpc,
+		SecurityGroupName: &name,
+		Description:       &AppConfig.SgDescription,
+	})
+
+	// Add ingress and egress rules to the security group.
+	securityGroup.AddEgressRule(awsec2.Peer_AnyIpv4(), awsec2.Port_AllTraffic(), jsii.String("Allow all outbound traffic"), jsii.Bool(true))
+	if err := addIngressRules(securityGroup, AppConfig); err != nil {
+		panic("❌ Invalid security group configuration: " + err.Error())
+	}
+
+	// Exported for the stacks attaching the security group, e.g. the
+	// nodes of the EKS stack or the CodeBuild project
+	awscdk.NewCfnOutput(stack, jsii.String("SecurityGroupId"), &awscdk.CfnOutputProps{
+		Description: jsii.String("The security group created in the VPC"),
+		Value:       securityGroup.SecurityGroupId(),
+		ExportName:  jsii.String(id + "-SecurityGroupId"),
+	})
+	return securityGroup
+}
@@ -7,9 +7,6 @@ import (
 
 	"github.com/aws/aws-cdk-go/awscdk/v2"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/constructs-go/constructs/v10"
 	"github.com/aws/jsii-runtime-go"
 )
@@ -31,6 +28,21 @@ type Configuration struct {
 	Za            float64
 	SgName        string
 	SgDescription string
+	ExistingVPCid string
+	// Subnet groups of the VPC, a public and a private one when empty
+	Subnets []SubnetGroup
+	// NAT gateways shared by the private subnets, one per availability zone
+	// when null
+	NatGateways *float64
+	// gateway (default) or instance, with NatInstanceType (t3.micro)
+	NatProvider     string
+	NatInstanceType string
+	// VPC endpoints of AWS services: s3 or dynamodb gateways, and interfaces
+	// such as ecr.api, ecr.dkr, sts, secretsmanager or logs
+	GatewayEndpoints   []string
+	InterfaceEndpoints []string
+	// Inbound rules of the security group, e.g. SonarQube on port 9000
+	IngressRules []IngressRule
 }
 
 func GetConfig(configcrd ConfAuth, configjs Configuration) (ConfAuth, Configuration) {
@@ -38,7 +50,6 @@ func GetConfig(configcrd ConfAuth, configjs Configuration) (ConfAuth, Configurat
 	fconfig, err := os.ReadFile("config.json")
 	if err != nil {
 		panic("❌ Problem with the configuration file : config.json")
-		os.Exit(1)
 	}
 	if err := json.Unmarshal(fconfig, &configjs); err != nil {
 		fmt.Println("❌ Error unmarshaling JSON:", err)
@@ -48,7 +59,6 @@ func GetConfig(configcrd ConfAuth, configjs Configuration) (ConfAuth, Configurat
 	fconfig2, err := os.ReadFile("../config_crd.json")
 	if err != nil {
 		panic("❌ Problem with the configuration file : config_crd.json")
-		os.Exit(1)
 	}
 	if err := json.Unmarshal(fconfig2, &configcrd); err != nil {
 		fmt.Println("❌ Error unmarshaling JSON:", err)
@@ -67,54 +77,44 @@ func NewVpc3Stack(scope constructs.Construct, id string, props *Vpc3StackProps,
 	var vpcName = AppConfig.VpcName + AppConfig1.Index
 	var SGName = AppConfig.SgName + AppConfig1.Index
 
-	// Open AWS session
-	sess := session.Must(session.NewSession(&aws.Config{
-		Region: &AppConfig1.Region,
-	}))
-
 	tagProps := &awscdk.TagProps{
 		ApplyToLaunchedInstances: jsii.Bool(false),
 		Priority:                 jsii.Number(123),
 	}
 
-	// Get VPCID by VPCName for testing if VPC exist
-	svc1 := ec2.New(sess)
-
-	vpcimport, err := svc1.DescribeVpcs(&ec2.DescribeVpcsInput{
-		Filters: []*ec2.Filter{
-			{
-				Name:   aws.String("tag:Name"),
-				Values: []*string{&vpcName},
-			},
-		},
-	})
-
-	if err != nil {
-		fmt.Println("❌ Error listing VPC:", err)
-		os.Exit(1)
-	}
-
-	size := len(vpcimport.Vpcs)
+	// An existing VPC is set explicitly in config.json, so synth never
+	// needs to call AWS to find out whether the VPC must be created
+	if AppConfig.ExistingVPCid == "" {
+		subnets, err := subnetConfiguration(AppConfig)
+		if err != nil {
+			panic("❌ Invalid VPC configuration: " + err.Error())
+		}
+		nat, err := natProvider(AppConfig)
+		if err != nil {
+			panic("❌ Invalid VPC configuration: " + err.Error())
+		}
+		if err := checkEndpoints(AppConfig); err != nil {
+			panic("❌ Invalid VPC configuration: " + err.Error())
+		}
 
-	if size == 0 {
 		// Create a new VPC
 		// Define the VPC with IPv4 CIDR block.
 		vpc := awsec2.NewVpc(stack, &vpcName, &awsec2.VpcProps{
-			IpAddresses: awsec2.IpAddresses_Cidr(&AppConfig.Vpccidr),
-			MaxAzs:      &AppConfig.Za,
-			VpcName:     &vpcName,
+			IpAddresses:         awsec2.IpAddresses_Cidr(&AppConfig.Vpccidr),
+			MaxAzs:              &AppConfig.Za,
+			VpcName:             &vpcName,
+			SubnetConfiguration: subnets,
+			NatGateways:         AppConfig.NatGateways,
+			NatGatewayProvider:  nat,
 		})
+		if instances, ok := nat.(awsec2.NatInstanceProvider); ok {
+			instances.Connections().AllowFrom(awsec2.Peer_Ipv4(vpc.VpcCidrBlock()), awsec2.Port_AllTraffic(), jsii.String("Traffic of the VPC to NAT"))
+		}
+		addEndpoints(vpc, AppConfig)
 
 		// Create a security group within the VPC.
-		securityGroup := awsec2.NewSecurityGroup(stack, &SGName, &awsec2.SecurityGroupProps{
-			Vpc:               vpc,
-			SecurityGroupName: &SGName,
-			Description:       &AppConfig.SgDescription,
-		})
-
+		securityGroup := newSecurityGroup(stack, id, vpc, SGName, AppConfig)
 		securityGroup.Node().AddDependency(vpc)
-		// Add ingress and egress rules to the security group.
-		securityGroup.AddEgressRule(awsec2.Peer_AnyIpv4(), awsec2.Port_AllTraffic(), jsii.String("Allow all outbound traffic"), jsii.Bool(true))
 
 		// Tags Subnets for  to be used by EKS
 
@@ -122,20 +122,34 @@ func NewVpc3Stack(scope constructs.Construct, id string, props *Vpc3StackProps,
 			awscdk.Tags_Of(subnet).Add(jsii.String("kubernetes.io/role/elb"), jsii.String("1"), tagProps)
 		}
 
-		for _, subnet := range *vpc.PrivateSubnets() {
+		internalSubnets := vpc.PrivateSubnets()
+		if len(*internalSubnets) == 0 {
+			// Without NAT, internal load balancers go to the isolated subnets
+			internalSubnets = vpc.IsolatedSubnets()
+		}
+		for _, subnet := range *internalSubnets {
 			awscdk.Tags_Of(subnet).Add(jsii.String("kubernetes.io/role/internal-elb"), jsii.String("1"), tagProps)
 		}
 
-		awscdk.NewCfnOutput(stack, aws.String("VPC_CREATED"), &awscdk.CfnOutputProps{
-			Description: aws.String("The VPC Created"),
+		awscdk.NewCfnOutput(stack, jsii.String("VPC_CREATED"), &awscdk.CfnOutputProps{
+			Description: jsii.String("The VPC Created"),
 			Value:       vpc.VpcId(),
 		})
 
 	} else {
-		awscdk.NewCfnOutput(stack, aws.String("VPC_EXIST"), &awscdk.CfnOutputProps{
-			Description: aws.String("The VPC already exists"),
-			Value:       vpcimport.Vpcs[0].VpcId,
+		// Resolved by the CDK context provider and cached in cdk.context.json
+		vpc := awsec2.Vpc_FromLookup(stack, &vpcName, &awsec2.VpcLookupOptions{
+			VpcId: &AppConfig.ExistingVPCid,
 		})
+
+		awscdk.NewCfnOutput(stack, jsii.String("VPC_EXIST"), &awscdk.CfnOutputProps{
+			Description: jsii.String("The VPC already exists"),
+			Value:       vpc.VpcId(),
+		})
+
+		// The stacks attaching the security group don't know whether the
+		// VPC was created, so it is created and exported in both cases
+		newSecurityGroup(stack, id, vpc, SGName, AppConfig)
 	}
 
 	return stack
@@ -1,26 +1,295 @@
 package main
 
-// import (
-// 	"testing"
-
-// 	"github.com/aws/aws-cdk-go/awscdk/v2"
-// 	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
-// 	"github.com/aws/jsii-runtime-go"
-// )
-
-// example tests. To run these tests, uncomment this file along with the
-// example resource in vpc3_test.go
-// func TestVpc3Stack(t *testing.T) {
-// 	// GIVEN
-// 	app := awscdk.NewApp(nil)
-
-// 	// WHEN
-// 	stack := NewVpc3Stack(app, "MyStack", nil)
-
-// 	// THEN
-// 	template := assertions.Template_FromStack(stack)
-
-// 	template.HasResourceProperties(jsii.String("AWS::SQS::Queue"), map[string]interface{}{
-// 		"VisibilityTimeout": 300,
-// 	})
-// }
+import (
+	"strings"
+	"testing"
+
+	"CDK/pkg/snapshot"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
+	"github.com/aws/jsii-runtime-go"
+)
+
+func testConfig() (Configuration, ConfAuth) {
+	return Configuration{
+		VpcName:       "AWSSonarTuto",
+		Vpccidr:       "192.168.0.0/16",
+		Za:            2,
+		SgName:        "AWSSonarTuto_vpc",
+		SgDescription: "Security group for AWSSonarTuto",
+	}, ConfAuth{
+		Region:  "eu-central-1",
+		Account: "123456789012",
+		Index:   "02",
+	}
+}
+
+func synthVpc3Stack(AppConfig Configuration, AppConfig1 ConfAuth) assertions.Template {
+	app := awscdk.NewApp(nil)
+	stack := NewVpc3Stack(app, "VPCStack"+AppConfig1.Index, &Vpc3StackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+	return assertions.Template_FromStack(stack, nil)
+}
+
+func TestVpc3Stack(t *testing.T) {
+	// GIVEN
+	AppConfig, AppConfig1 := testConfig()
+
+	// WHEN
+	template := synthVpc3Stack(AppConfig, AppConfig1)
+
+	// THEN
+	template.ResourceCountIs(jsii.String("AWS::EC2::VPC"), jsii.Number(1))
+	template.ResourceCountIs(jsii.String("AWS::EC2::Subnet"), jsii.Number(4))
+	template.ResourceCountIs(jsii.String("AWS::EC2::NatGateway"), jsii.Number(2))
+
+	template.HasResourceProperties(jsii.String("AWS::EC2::VPC"), map[string]interface{}{
+		"CidrBlock": "192.168.0.0/16",
+		"Tags": assertions.Match_ArrayWith(&[]interface{}{
+			map[string]interface{}{"Key": "Name", "Value": "AWSSonarTuto02"},
+		}),
+	})
+
+	// Subnets must carry the load balancer role tags EKS looks for
+	template.ResourcePropertiesCountIs(jsii.String("AWS::EC2::Subnet"), map[string]interface{}{
+		"MapPublicIpOnLaunch": true,
+		"Tags": assertions.Match_ArrayWith(&[]interface{}{
+			map[string]interface{}{"Key": "kubernetes.io/role/elb", "Value": "1"},
+		}),
+	}, jsii.Number(2))
+	template.ResourcePropertiesCountIs(jsii.String("AWS::EC2::Subnet"), map[string]interface{}{
+		"MapPublicIpOnLaunch": false,
+		"Tags": assertions.Match_ArrayWith(&[]interface{}{
+			map[string]interface{}{"Key": "kubernetes.io/role/internal-elb", "Value": "1"},
+		}),
+	}, jsii.Number(2))
+
+	template.HasResourceProperties(jsii.String("AWS::EC2::SecurityGroup"), map[string]interface{}{
+		"GroupName":        "AWSSonarTuto_vpc02",
+		"GroupDescription": "Security group for AWSSonarTuto",
+		"SecurityGroupEgress": []interface{}{
+			map[string]interface{}{"CidrIp": "0.0.0.0/0", "IpProtocol": "-1"},
+		},
+	})
+
+	template.HasOutput(jsii.String("VPCCREATED"), map[string]interface{}{})
+	template.HasOutput(jsii.String("SecurityGroupId"), map[string]interface{}{
+		"Export": map[string]interface{}{"Name": "VPCStack02-SecurityGroupId"},
+	})
+
+	snapshot.Match(t, "VPCStack", template.ToJSON())
+}
+
+func TestVpc3StackExistingVpc(t *testing.T) {
+	// GIVEN
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.ExistingVPCid = "vpc-0123456789abcdef0"
+
+	// WHEN
+	template := synthVpc3Stack(AppConfig, AppConfig1)
+
+	// THEN
+	template.ResourceCountIs(jsii.String("AWS::EC2::VPC"), jsii.Number(0))
+	template.HasOutput(jsii.String("VPCEXIST"), map[string]interface{}{})
+
+	// The security group is exported like for a created VPC
+	template.ResourceCountIs(jsii.String("AWS::EC2::SecurityGroup"), jsii.Number(1))
+	template.HasResourceProperties(jsii.String("AWS::EC2::SecurityGroup"), map[string]interface{}{
+		"GroupName": "AWSSonarTuto_vpc02",
+		"VpcId":     "vpc-12345",
+	})
+	template.HasOutput(jsii.String("SecurityGroupId"), map[string]interface{}{
+		"Export": map[string]interface{}{"Name": "VPCStack02-SecurityGroupId"},
+	})
+
+	snapshot.Match(t, "VPCStackExistingVpc", template.ToJSON())
+}
+
+func TestVpc3StackLayout(t *testing.T) {
+	// GIVEN
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.Subnets = []SubnetGroup{
+		{Name: "public", Type: "public", CidrMask: 24},
+		{Name: "private", Type: "private", CidrMask: 19},
+		{Name: "database", Type: "isolated", CidrMask: 24},
+	}
+	AppConfig.NatGateways = jsii.Number(1)
+	AppConfig.NatProvider = "instance"
+	AppConfig.GatewayEndpoints = []string{"s3"}
+	AppConfig.InterfaceEndpoints = []string{"ecr.api", "ecr.dkr", "sts"}
+
+	// WHEN
+	template := synthVpc3Stack(AppConfig, AppConfig1)
+
+	// THEN
+	template.ResourceCountIs(jsii.String("AWS::EC2::Subnet"), jsii.Number(6))
+	template.ResourcePropertiesCountIs(jsii.String("AWS::EC2::Subnet"), map[string]interface{}{
+		"CidrBlock": assertions.Match_StringLikeRegexp(jsii.String(`/19$`)),
+	}, jsii.Number(2))
+	template.ResourceCountIs(jsii.String("AWS::EC2::NatGateway"), jsii.Number(0))
+	template.ResourceCountIs(jsii.String("AWS::EC2::Instance"), jsii.Number(1))
+	template.HasResourceProperties(jsii.String("AWS::EC2::Instance"), map[string]interface{}{
+		"InstanceType":    "t3.micro",
+		"SourceDestCheck": false,
+	})
+	// The NAT instance only accepts traffic from the VPC
+	template.HasResourceProperties(jsii.String("AWS::EC2::SecurityGroup"), map[string]interface{}{
+		"SecurityGroupIngress": []interface{}{
+			map[string]interface{}{
+				"CidrIp":      map[string]interface{}{"Fn::GetAtt": assertions.Match_ArrayWith(&[]interface{}{"CidrBlock"})},
+				"Description": "Traffic of the VPC to NAT",
+				"IpProtocol":  "-1",
+			},
+		},
+	})
+
+	template.HasResourceProperties(jsii.String("AWS::EC2::VPCEndpoint"), map[string]interface{}{
+		"VpcEndpointType": "Gateway",
+		"ServiceName":     assertions.Match_AnyValue(),
+	})
+	template.ResourcePropertiesCountIs(jsii.String("AWS::EC2::VPCEndpoint"), map[string]interface{}{
+		"VpcEndpointType":   "Interface",
+		"PrivateDnsEnabled": true,
+	}, jsii.Number(3))
+	template.HasResourceProperties(jsii.String("AWS::EC2::VPCEndpoint"), map[string]interface{}{
+		"ServiceName": "com.amazonaws.eu-central-1.ecr.dkr",
+	})
+
+	// Internal load balancers still go to the private subnets
+	template.ResourcePropertiesCountIs(jsii.String("AWS::EC2::Subnet"), map[string]interface{}{
+		"Tags": assertions.Match_ArrayWith(&[]interface{}{
+			map[string]interface{}{"Key": "kubernetes.io/role/internal-elb", "Value": "1"},
+		}),
+	}, jsii.Number(2))
+}
+
+func TestVpc3StackWithoutNat(t *testing.T) {
+	// GIVEN
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.Subnets = []SubnetGroup{
+		{Name: "public", Type: "public"},
+		{Name: "nodes", Type: "isolated"},
+	}
+	AppConfig.NatGateways = jsii.Number(0)
+	AppConfig.GatewayEndpoints = []string{"s3"}
+	AppConfig.InterfaceEndpoints = []string{"ecr.api", "ecr.dkr", "sts", "secretsmanager", "logs"}
+
+	// WHEN
+	template := synthVpc3Stack(AppConfig, AppConfig1)
+
+	// THEN
+	template.ResourceCountIs(jsii.String("AWS::EC2::NatGateway"), jsii.Number(0))
+	template.ResourceCountIs(jsii.String("AWS::EC2::VPCEndpoint"), jsii.Number(6))
+	template.ResourcePropertiesCountIs(jsii.String("AWS::EC2::Subnet"), map[string]interface{}{
+		"MapPublicIpOnLaunch": false,
+		"Tags": assertions.Match_ArrayWith(&[]interface{}{
+			map[string]interface{}{"Key": "kubernetes.io/role/internal-elb", "Value": "1"},
+		}),
+	}, jsii.Number(2))
+}
+
+func TestVpcConfigurationInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Configuration)
+		want   string
+	}{
+		{"subnet type", func(c *Configuration) { c.Subnets = []SubnetGroup{{Name: "a", Type: "dmz"}} }, "unsupported Type"},
+		{"subnet name", func(c *Configuration) {
+			c.Subnets = []SubnetGroup{{Name: "a", Type: "public"}, {Name: "a", Type: "isolated"}}
+		}, "unique Name"},
+		{"cidr mask", func(c *Configuration) { c.Subnets = []SubnetGroup{{Name: "a", Type: "public", CidrMask: 30}} }, "between 16 and 28"},
+		{"private without nat", func(c *Configuration) { c.NatGateways = jsii.Number(0) }, "set NatGateways"},
+		{"private without public", func(c *Configuration) { c.Subnets = []SubnetGroup{{Name: "a", Type: "private"}} }, "public subnet group"},
+		{"nat without private", func(c *Configuration) {
+			c.Subnets = []SubnetGroup{{Name: "a", Type: "public"}, {Name: "b", Type: "isolated"}}
+			c.NatGateways = jsii.Number(1)
+		}, "needs a private subnet group"},
+		{"nat provider", func(c *Configuration) { c.NatProvider = "nat" }, "unsupported NatProvider"},
+		{"nat instance type", func(c *Configuration) { c.NatInstanceType = "t3.nano" }, "needs NatProvider instance"},
+		{"gateway endpoint", func(c *Configuration) { c.GatewayEndpoints = []string{"ecr.api"} }, "unsupported gateway endpoint"},
+		{"interface endpoint", func(c *Configuration) { c.InterfaceEndpoints = []string{"com.amazonaws.eu-central-1.sts "} }, "invalid interface endpoint"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			AppConfig, _ := testConfig()
+			tt.modify(&AppConfig)
+			_, err := subnetConfiguration(AppConfig)
+			if err == nil {
+				_, err = natProvider(AppConfig)
+			}
+			if err == nil {
+				err = checkEndpoints(AppConfig)
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVpc3StackIngressRules(t *testing.T) {
+	// GIVEN
+	AppConfig, AppConfig1 := testConfig()
+	AppConfig.IngressRules = []IngressRule{
+		{Description: "SonarQube", FromPort: 9000, Cidr: "192.168.0.0/16"},
+		{Description: "NodePorts", FromPort: 30000, ToPort: 32767, Protocol: "udp", PrefixListId: "pl-0123456789abcdef0"},
+		{Protocol: "all", SecurityGroupId: "sg-0123456789abcdef0"},
+		{Protocol: "icmp", Cidr: "2001:db8::/32"},
+	}
+
+	// WHEN
+	template := synthVpc3Stack(AppConfig, AppConfig1)
+
+	// THEN
+	template.HasResourceProperties(jsii.String("AWS::EC2::SecurityGroup"), map[string]interface{}{
+		"GroupName": "AWSSonarTuto_vpc02",
+		"SecurityGroupIngress": []interface{}{
+			map[string]interface{}{"CidrIp": "192.168.0.0/16", "Description": "SonarQube", "IpProtocol": "tcp", "FromPort": 9000, "ToPort": 9000},
+			map[string]interface{}{"SourceSecurityGroupId": "sg-0123456789abcdef0", "Description": assertions.Match_AnyValue(), "IpProtocol": "-1"},
+			map[string]interface{}{"CidrIpv6": "2001:db8::/32", "Description": assertions.Match_AnyValue(), "IpProtocol": "icmpv6", "FromPort": -1, "ToPort": -1},
+		},
+	})
+	// CDK keeps the prefix list rules out of the group
+	template.HasResourceProperties(jsii.String("AWS::EC2::SecurityGroupIngress"), map[string]interface{}{
+		"SourcePrefixListId": "pl-0123456789abcdef0",
+		"Description":        "NodePorts",
+		"IpProtocol":         "udp",
+		"FromPort":           30000,
+		"ToPort":             32767,
+	})
+}
+
+func TestIngressRuleInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		rule IngressRule
+		want string
+	}{
+		{"no peer", IngressRule{FromPort: 9000}, "set one of"},
+		{"two peers", IngressRule{FromPort: 9000, Cidr: "10.0.0.0/8", SecurityGroupId: "sg-1"}, "set one of"},
+		{"cidr", IngressRule{FromPort: 9000, Cidr: "10.0.0.1"}, "invalid Cidr"},
+		{"prefix list", IngressRule{FromPort: 9000, PrefixListId: "com.amazonaws.global.cloudfront"}, "invalid PrefixListId"},
+		{"security group", IngressRule{FromPort: 9000, SecurityGroupId: "AWSSonarTuto_vpc"}, "invalid SecurityGroupId"},
+		{"no port", IngressRule{Cidr: "10.0.0.0/8"}, "invalid port range"},
+		{"port range", IngressRule{FromPort: 9000, ToPort: 8000, Cidr: "10.0.0.0/8"}, "invalid port range"},
+		{"port", IngressRule{FromPort: 70000, Cidr: "10.0.0.0/8"}, "invalid port range"},
+		{"icmp port", IngressRule{Protocol: "icmp", FromPort: 8, Cidr: "10.0.0.0/8"}, "takes no ports"},
+		{"protocol", IngressRule{Protocol: "sctp", FromPort: 9000, Cidr: "10.0.0.0/8"}, "unsupported Protocol"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ingressPeer(tt.rule)
+			if err == nil {
+				_, err = ingressPort(tt.rule)
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}